@@ -0,0 +1,134 @@
+package main
+
+import (
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+const (
+	defAFKTimeout = 5 * time.Minute  // AFK timeout used if there is none on the command line
+	minAFKTimeout = 30 * time.Second // lower ones would not even give the time to read the warning
+	afkSweepEvery = 10 * time.Second // how often the idle players are checked
+)
+
+// Get after how long a player is warned that he's going to lose for inactivity (when a third of the timeout is left)
+func afkWarningAfter(timeout time.Duration) time.Duration {
+	return timeout - timeout/3
+}
+
+/* Keep checking the ongoing duels: the players that are not moving since a while are warned
+ * and, if they keep not moving, they lose the duel (in team duels they are just knocked out).
+ * If both players are not moving the duel is abandoned so they can start new ones.
+ * It should run on its own goroutine
+ */
+func sweepIdlePlayers() {
+	var ticker = time.NewTicker(afkSweepEvery)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var (
+			idle     = duels.IdlePlayers()
+			timedOut = make(map[int64]bool)
+		)
+
+		for _, player := range idle {
+			timedOut[player.UserID] = player.Idle >= player.Timeout
+		}
+
+		for _, player := range idle {
+			switch true {
+			case !timedOut[player.UserID]:
+				if !player.Warned {
+					warnIdlePlayer(player)
+				}
+			case player.Team:
+				b := &bot{player.UserID, echotron.NewAPI(TOKEN)}
+				b.RetireTeamPlayer(player.UserID, EventTimeout)
+			case timedOut[player.OpponentID]:
+				// Both timed out, the duel is closed only once
+				if player.UserID < player.OpponentID {
+					abandonDuel(player.UserID)
+				}
+			default:
+				forfeitIdlePlayer(player.UserID, player.OpponentID)
+			}
+		}
+	}
+}
+
+// Warn a player that he will lose the duel if he doesn't move
+func warnIdlePlayer(player IdlePlayer) {
+	if duels.SetWarned(player.UserID) != nil {
+		return
+	}
+	b := &bot{player.UserID, echotron.NewAPI(TOKEN)}
+	b.NotifyIdle(player.Idle, player.Timeout-player.Idle)
+}
+
+// End the duel as a loss for the player that didn't move for too long
+func forfeitIdlePlayer(idleID, opponentID int64) {
+	var b = &bot{opponentID, echotron.NewAPI(TOKEN)}
+
+	settings, changes, ok := recordForfeit(idleID, opponentID)
+	if !ok {
+		return
+	}
+
+	b.UpdateSpectators(idleID, "⏰ <b>"+GenUserLink(idleID, b.GetUserName(idleID))+" lost for inactivity</b>", true)
+	b.NotifyTimeout(idleID)
+	b.NotifyEndDuel(opponentID, changes)
+	b.ContinueSeries(settings)
+
+	duels.EndDuel(idleID)
+	StopAI(opponentID)
+}
+
+/* Claim the end of the duel as a loss of the idle player and record it, ok is false
+ * if he moved or the duel ended since then. The duel still needs to be ended
+ */
+func recordForfeit(idleID, opponentID int64) (settings DuelSettings, changes RatingChanges, ok bool) {
+	// The idle players were checked without locking, he might have moved or the duel ended since then
+	if !duels.ClaimEnd(idleID, idleID) {
+		return
+	}
+	settings, err := duels.GetSettings(idleID)
+	if err != nil {
+		return
+	}
+	duels.LogEnd(idleID, EventTimeout)
+	changes = RecordEndDuel(&opponentID, idleID, opponentID, settings)
+	duels.SetRatingChanges(idleID, changes)
+	settings, _ = duels.UpdateSeries(idleID, func(series *SeriesScore) { series.Leave(idleID) })
+	return settings, changes, true
+}
+
+// End the duel without a winner because both players didn't move for too long
+func abandonDuel(userID int64) {
+	var b = &bot{userID, echotron.NewAPI(TOKEN)}
+
+	opponentID, settings, ok := recordAbandon(userID)
+	if !ok {
+		return
+	}
+
+	b.UpdateSpectators(userID, "💤 <b>The duel was abandoned</b>", true)
+	b.NotifyAbandon(userID, opponentID)
+	b.ContinueSeries(settings)
+
+	duels.EndDuel(userID)
+	StopAI(opponentID)
+}
+
+/* Claim the end of the duel as abandoned by both players, without changing their records.
+ * ok is false if one of them moved or the duel ended since then. The duel still needs to be ended
+ */
+func recordAbandon(userID int64) (opponentID int64, settings DuelSettings, ok bool) {
+	opponentID, err := duels.GetOpponentID(userID)
+	if err != nil || !duels.ClaimEnd(userID, userID, opponentID) {
+		return
+	}
+	duels.LogEnd(userID, EventAbandon)
+	settings, _ = duels.UpdateSeries(userID, func(series *SeriesScore) { series.Ended = true })
+	return opponentID, settings, true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Engage a duel on the registry of the bot, it's ended when the test is over
+func engageIdleTest(t *testing.T, firstID, secondID int64, settings DuelSettings) {
+	if _, err := duels.EngageDuel(firstID, secondID, settings); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { duels.EndDuel(firstID) })
+}
+
+// Make a player look like he didn't move since a while
+func setIdle(userID int64, idle time.Duration) {
+	duels.withPlayer(userID, func(p *Player) { p.lastMove = time.Now().Add(-idle) })
+}
+
+// Check if a player is found idle and if he already timed out
+func findIdle(userID int64) (found, timedOut bool) {
+	for _, player := range duels.IdlePlayers() {
+		if player.UserID == userID {
+			return true, player.Idle >= player.Timeout
+		}
+	}
+	return false, false
+}
+
+func TestForfeitIdlePlayer(t *testing.T) {
+	const idleID, activeID = 4001, 4002
+
+	engageIdleTest(t, idleID, activeID, DuelSettings{})
+
+	// Warned first, then timed out
+	setIdle(idleID, afkWarningAfter(AFK_TIMEOUT))
+	if found, timedOut := findIdle(idleID); !found || timedOut {
+		t.Errorf("after the warning time the player is found %v and timed out %v", found, timedOut)
+	}
+	setIdle(idleID, AFK_TIMEOUT)
+	if found, timedOut := findIdle(idleID); !found || !timedOut {
+		t.Errorf("after the timeout the player is found %v and timed out %v", found, timedOut)
+	}
+	if found, _ := findIdle(activeID); found {
+		t.Error("the active player is idle")
+	}
+
+	idle, active := GetProfile(idleID), GetProfile(activeID)
+	if _, _, ok := recordForfeit(activeID, idleID); ok {
+		t.Error("the active player lost for inactivity")
+	}
+	if _, _, ok := recordForfeit(idleID, activeID); !ok {
+		t.Fatal("the idle player didn't lose")
+	}
+	if _, _, ok := recordForfeit(idleID, activeID); ok {
+		t.Error("the idle player lost twice")
+	}
+
+	if p := GetProfile(idleID); p.Losses != idle.Losses+1 || p.Wins != idle.Wins {
+		t.Errorf("the profile of the idle player went from %+v to %+v", idle, p)
+	}
+	if p := GetProfile(activeID); p.Wins != active.Wins+1 || p.Losses != active.Losses {
+		t.Errorf("the profile of the active player went from %+v to %+v", active, p)
+	}
+	record, err := duels.GetDuelLog(idleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if winnerID, fleeingID, over := record.Outcome(); !over || winnerID == nil || *winnerID != activeID || fleeingID != idleID {
+		t.Errorf("the duel ended won by %v with %d inactive (over %v)", winnerID, fleeingID, over)
+	}
+	if !record.EndedBy(EventTimeout) {
+		t.Errorf("the duel ended with the event %+v", record.Events[len(record.Events)-1])
+	}
+	if found, _ := findIdle(idleID); found {
+		t.Error("the player is still idle after the end of the duel")
+	}
+}
+
+func TestAbandonDuel(t *testing.T) {
+	const firstID, secondID = 4003, 4004
+
+	engageIdleTest(t, firstID, secondID, DuelSettings{BestOf: 3, Series: &SeriesScore{}})
+
+	setIdle(firstID, AFK_TIMEOUT)
+	if _, _, ok := recordAbandon(firstID); ok {
+		t.Fatal("abandoned a duel where a player is still moving")
+	}
+
+	first, second := GetProfile(firstID), GetProfile(secondID)
+	setIdle(secondID, AFK_TIMEOUT)
+	opponentID, settings, ok := recordAbandon(firstID)
+	switch true {
+	case !ok:
+		t.Fatal("the duel of two idle players was not abandoned")
+	case opponentID != secondID:
+		t.Errorf("abandoned against %d instead of %d", opponentID, secondID)
+	case settings.Series == nil || !settings.Series.Ended:
+		t.Errorf("the series goes on after the abandon: %+v", settings.Series)
+	}
+	if _, _, ok = recordAbandon(secondID); ok {
+		t.Error("the duel was abandoned twice")
+	}
+
+	// Nobody won nor lost
+	if p := GetProfile(firstID); p.Wins != first.Wins || p.Losses != first.Losses || p.Draws != first.Draws {
+		t.Errorf("the profile of the first player went from %+v to %+v", first, p)
+	}
+	if p := GetProfile(secondID); p.Wins != second.Wins || p.Losses != second.Losses || p.Draws != second.Draws {
+		t.Errorf("the profile of the second player went from %+v to %+v", second, p)
+	}
+	record, err := duels.GetDuelLog(firstID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if winnerID, _, over := record.Outcome(); !over || winnerID != nil || !record.EndedBy(EventAbandon) {
+		t.Errorf("the duel ended won by %v (over %v)", winnerID, over)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"DuelBot/pg"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+// Difficulty of an AI opponent, it decide how it choose its actions
+type AILevel string
+
+const (
+	AIRandom    AILevel = "random"    // choose a random action from time to time
+	AIReactive  AILevel = "reactive"  // stay on guard and counter the action of the opponent
+	AILookahead AILevel = "lookahead" // simulate the next clashes and choose the best action
+)
+
+// Details of a difficulty shown to the players
+type AIDifficulty struct {
+	Level       AILevel
+	Name        string
+	Description string
+	reaction    time.Duration // time needed to react to the opponent action
+	patience    time.Duration // time waited before acting without knowing the opponent action
+}
+
+// All the available difficulties, from the easiest
+var aiDifficulties = []AIDifficulty{{
+	Level:       AIRandom,
+	Name:        "🤖 Rookie bot",
+	Description: "it doesn't really know what it's doing",
+	reaction:    1500 * time.Millisecond,
+	patience:    3 * time.Second,
+}, {
+	Level:       AIReactive,
+	Name:        "🤖 Sentinel bot",
+	Description: "it stays on guard and counters your moves",
+	reaction:    1200 * time.Millisecond,
+	patience:    5 * time.Second,
+}, {
+	Level:       AILookahead,
+	Name:        "🤖 Master bot",
+	Description: "it thinks a couple of clashes ahead before moving",
+	reaction:    800 * time.Millisecond,
+	patience:    4 * time.Second,
+}}
+
+const (
+	aiIDBase  int64 = -1 << 60 // AI opponents have IDs below this value so they never match a Telegram chat
+	aiIDRange int64 = 1 << 40  // how many different IDs can be given to AI opponents
+	aiDepth         = 2        // how many clashes the lookahead difficulty simulates
+)
+
+// An AI opponent fighting in a duel, it runs on its own goroutine
+type AIPlayer struct {
+	ID         int64
+	Difficulty AIDifficulty
+	spotted    chan string   // action of the opponent, "" when a new round start
+	stop       chan struct{} // closed when the duel ends
+}
+
+var (
+	// Actions that the AI can choose to do something (GUARD means waiting)
+	aiActions = []pg.Status{pg.ATTACK, pg.DEFEND, pg.DODGE}
+
+	opponents   = make(map[int64]*AIPlayer) // AI ID -> AI opponent
+	opponentsMu sync.Mutex
+)
+
+// Check if the ID belongs to an AI opponent
+func isAI(userID int64) bool {
+	return userID <= aiIDBase
+}
+
+// Get the difficulty with the given level
+func findDifficulty(level AILevel) (AIDifficulty, bool) {
+	for _, difficulty := range aiDifficulties {
+		if difficulty.Level == level {
+			return difficulty, true
+		}
+	}
+	return AIDifficulty{}, false
+}
+
+// Generate a new ID for an AI opponent that is not used by any ongoing duel
+func newAIID() (aiID int64) {
+	for {
+		aiID = aiIDBase - rand.Int63n(aiIDRange)
+		if !duels.IsPlayerBusy(aiID) {
+			return
+		}
+	}
+}
+
+// Get the name of an AI opponent
+func aiName(aiID int64) string {
+	opponentsMu.Lock()
+	defer opponentsMu.Unlock()
+
+	if ai := opponents[aiID]; ai != nil {
+		return ai.Difficulty.Name
+	}
+	return "🤖 DuelBot"
+}
+
+// Make an AI opponent start fighting in the duel it's engaged
+func StartAI(aiID int64, level AILevel) error {
+	difficulty, ok := findDifficulty(level)
+	if !ok {
+		return errors.New("Invalid AI level")
+	}
+
+	ai := &AIPlayer{
+		ID:         aiID,
+		Difficulty: difficulty,
+		spotted:    make(chan string, 1),
+		stop:       make(chan struct{}),
+	}
+
+	opponentsMu.Lock()
+	if opponents[aiID] != nil {
+		opponentsMu.Unlock()
+		return errors.New("AI is already fighting")
+	}
+	opponents[aiID] = ai
+	opponentsMu.Unlock()
+
+	go ai.run()
+	return nil
+}
+
+// Make an AI opponent stop fighting (nothing happens if userID is not an AI)
+func StopAI(userID int64) {
+	opponentsMu.Lock()
+	defer opponentsMu.Unlock()
+
+	if ai := opponents[userID]; ai != nil {
+		close(ai.stop)
+		delete(opponents, userID)
+	}
+}
+
+// Tell an AI opponent what happened: the action of the opponent or a new round if move == ""
+func NotifyAI(aiID int64, move string) {
+	opponentsMu.Lock()
+	ai := opponents[aiID]
+	opponentsMu.Unlock()
+
+	if ai == nil {
+		return
+	}
+
+	// Only the last event matters, the old one is discarded if the AI is still thinking
+	for {
+		select {
+		case ai.spotted <- move:
+			return
+		case <-ai.stop:
+			return
+		default:
+			select {
+			case <-ai.spotted:
+			default:
+			}
+		}
+	}
+}
+
+/* Keep choosing the actions of the AI until the duel ends. The AI reacts to the spotted actions
+ * of the opponent and, if nothing happens for a while, it does something on its own
+ */
+func (ai *AIPlayer) run() {
+	var idle = time.NewTimer(ai.Difficulty.patience)
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-ai.stop:
+			return
+
+		case move := <-ai.spotted:
+			if move == "" || ai.Difficulty.Level == AIRandom {
+				resetTimer(idle, ai.patience())
+				continue
+			}
+			// Even robots need some time to react
+			select {
+			case <-time.After(ai.Difficulty.reaction):
+			case <-ai.stop:
+				return
+			}
+			if ai.act(toStatus[move]) == defAction {
+				resetTimer(idle, ai.patience())
+			}
+
+		case <-idle.C:
+			if ai.act(pg.HELPLESS) == defAction {
+				resetTimer(idle, ai.patience())
+			}
+		}
+	}
+}
+
+// Get how long the AI waits before acting on its own
+func (ai *AIPlayer) patience() time.Duration {
+	if ai.Difficulty.Level == AIRandom {
+		return time.Second + time.Duration(rand.Int63n(int64(ai.Difficulty.patience)))
+	}
+	return ai.Difficulty.patience
+}
+
+// Stop the timer and make it expire after the given duration
+func resetTimer(timer *time.Timer, duration time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(duration)
+}
+
+// Choose and set the action of the AI against the spotted one (HELPLESS if unknown)
+func (ai *AIPlayer) act(spotted pg.Status) (chosen pg.Status) {
+	own, enemy, err := duels.GetCreatures(ai.ID)
+	if err != nil {
+		return defAction
+	}
+	// Stunned or exausted, nothing to do untill the next clash
+	if own.IsOnStatus(pg.HELPLESS) {
+		return defAction
+	}
+
+	switch ai.Difficulty.Level {
+	case AIRandom:
+		chosen = chooseRandom()
+	case AIReactive:
+		chosen = chooseCounter(own, enemy, spotted)
+	case AILookahead:
+		chosen = chooseLookahead(own, enemy, spotted)
+	}
+
+	b := &bot{ai.ID, echotron.NewAPI(TOKEN)}
+	if err = b.applyMove(ai.ID, toString[chosen]); err != nil {
+		log.Println("act", "applyMove", err)
+	}
+	return
+}
+
+// Choose a random action, the AI might also go back on guard
+func chooseRandom() pg.Status {
+	if rand.Intn(len(aiActions)+1) == 0 {
+		return pg.GUARD
+	}
+	return aiActions[rand.Intn(len(aiActions))]
+}
+
+// Choose the action that counters the spotted one, if unknown attack or recover stamina
+func chooseCounter(own, enemy pg.Creature, spotted pg.Status) pg.Status {
+	_, ownStamina, maxStamina, _ := own.GetInfo()
+	_, enemyStamina, _, _ := enemy.GetInfo()
+
+	switch spotted {
+	case pg.ATTACK:
+		// Dodging works only if faster than the opponent
+		if ownStamina >= enemyStamina {
+			return pg.DODGE
+		}
+		return pg.DEFEND
+	case pg.DEFEND, pg.ITEM:
+		if ownStamina > 1 {
+			return pg.ATTACK
+		}
+		return pg.GUARD
+	case pg.DODGE:
+		return pg.DEFEND
+	case pg.GUARD:
+		return pg.GUARD
+	}
+
+	if ownStamina*2 >= maxStamina {
+		return pg.ATTACK
+	}
+	return pg.DEFEND
+}
+
+/* Choose the action with the best outcome simulating the next clashes. If the action of the
+ * opponent is unknown all of them are considered equally likely
+ */
+func chooseLookahead(own, enemy pg.Creature, spotted pg.Status) pg.Status {
+	var (
+		enemyActions = append([]pg.Status{pg.GUARD}, aiActions...)
+		chosen       = pg.GUARD
+		best         float64
+	)
+
+	switch spotted {
+	case pg.HELPLESS:
+	case pg.ATTACK, pg.DODGE:
+		// The clash will happen anyway, even if AI stays on guard
+		enemyActions = []pg.Status{spotted}
+		best = simulate(own, enemy, pg.GUARD, enemyActions, aiDepth)
+	default:
+		// Staying on guard means no clash at all
+		enemyActions = []pg.Status{spotted}
+		best = evaluate(own, enemy)
+	}
+
+	for i, action := range aiActions {
+		score := simulate(own, enemy, action, enemyActions, aiDepth)
+		if (i == 0 && spotted == pg.HELPLESS) || score > best {
+			chosen, best = action, score
+		}
+	}
+
+	return chosen
+}
+
+// Get the average outcome of an action against the given ones of the enemy looking depth clashes ahead
+func simulate(own, enemy pg.Creature, action pg.Status, enemyActions []pg.Status, depth int) (score float64) {
+	for _, enemyAction := range enemyActions {
+		var (
+			o, e  = own.Clone(), enemy.Clone()
+			value float64
+		)
+
+		o.SetAction(action)
+		e.SetAction(enemyAction)
+		if winner, _ := pg.PerformAction(&o, &e); winner != 0 || depth <= 1 {
+			value = evaluate(o, e)
+		} else {
+			o.SetAction(defAction)
+			e.SetAction(defAction)
+			for i, next := range aiActions {
+				nextValue := simulate(o, e, next, append([]pg.Status{pg.GUARD}, aiActions...), depth-1)
+				if i == 0 || nextValue > value {
+					value = nextValue
+				}
+			}
+		}
+		score += value
+	}
+
+	return score / float64(len(enemyActions))
+}
+
+// Give a score to a situation from the point of view of the AI (own creature)
+func evaluate(own, enemy pg.Creature) (score float64) {
+	ownLife, ownStamina, _, _ := own.GetInfo()
+	enemyLife, enemyStamina, _, _ := enemy.GetInfo()
+
+	switch true {
+	case own.IsDead() && enemy.IsDead():
+		return -50
+	case own.IsDead():
+		return -1000
+	case enemy.IsDead():
+		return 1000
+	}
+
+	score = float64(ownLife-enemyLife)*4 + float64(int(ownStamina)-int(enemyStamina))
+	if own.IsOnStatus(pg.STUNNED) || own.IsOnStatus(pg.EXAUSTED) {
+		score -= 5
+	}
+	if enemy.IsOnStatus(pg.STUNNED) || enemy.IsOnStatus(pg.EXAUSTED) {
+		score += 5
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+
+	"DuelBot/pg"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+// Register an AI opponent that doesn't act on its own, so the test can read what it spots
+func spyTestAI(t *testing.T, aiID int64, level AILevel) *AIPlayer {
+	difficulty, _ := findDifficulty(level)
+	ai := &AIPlayer{ID: aiID, Difficulty: difficulty, spotted: make(chan string, 1), stop: make(chan struct{})}
+
+	opponentsMu.Lock()
+	opponents[aiID] = ai
+	opponentsMu.Unlock()
+	t.Cleanup(func() { StopAI(aiID) })
+	return ai
+}
+
+func TestReactiveCounter(t *testing.T) {
+	const userID, aiID = 1, aiIDBase - 1
+
+	var (
+		ai = spyTestAI(t, aiID, AIReactive)
+		b  = &bot{userID, echotron.NewAPI(TOKEN)}
+	)
+
+	tests := []struct {
+		name       string
+		own, enemy pg.Class // the rogue is the fastest, the knight the slowest
+		move       string
+		want       pg.Status
+	}{
+		{"dodge an attack", pg.ROGUE, pg.KNIGHT, "ATTACK", pg.DODGE},
+		{"dodge an attack as fast", pg.NOCLASS, pg.NOCLASS, "ATTACK", pg.DODGE},
+		{"defend from a faster attack", pg.KNIGHT, pg.ROGUE, "ATTACK", pg.DEFEND},
+		{"attack who defends", pg.NOCLASS, pg.NOCLASS, "DEFEND", pg.ATTACK},
+		{"attack who drinks", pg.NOCLASS, pg.NOCLASS, "POTION", pg.ATTACK},
+		{"stun who dodges", pg.NOCLASS, pg.NOCLASS, "DODGE", pg.DEFEND},
+		{"wait who waits", pg.NOCLASS, pg.NOCLASS, "GUARD", pg.GUARD},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The action is revealed to the AI the same way it's revealed to a player on guard
+			b.SpyAction(aiID, userID, test.move)
+
+			var move string
+			select {
+			case move = <-ai.spotted:
+			default:
+				t.Fatal("the AI didn't spot the action")
+			}
+			if move != test.move {
+				t.Fatalf("the AI spotted %s instead of %s", move, test.move)
+			}
+			if chosen := chooseCounter(pg.NewClassCreature(nil, test.own), pg.NewClassCreature(nil, test.enemy), toStatus[move]); chosen != test.want {
+				t.Errorf("countered %s with %v instead of %v", move, chosen, test.want)
+			}
+		})
+	}
+
+	// Only the last action matters if the AI is still thinking
+	b.SpyAction(aiID, userID, "ATTACK")
+	b.SpyAction(aiID, userID, "DEFEND")
+	if move := <-ai.spotted; move != "DEFEND" {
+		t.Errorf("the AI spotted %s instead of the last action", move)
+	}
+}
+
+func TestReactiveTired(t *testing.T) {
+	own, enemy := pg.NewCreature(nil), pg.NewCreature(nil)
+
+	// Without knowing the action of the enemy it attacks only with enough stamina
+	if chosen := chooseCounter(own, enemy, pg.HELPLESS); chosen != pg.ATTACK {
+		t.Errorf("rested, it chose %v instead of attacking", chosen)
+	}
+	for i := 0; i < 5; i++ {
+		own.SetAction(pg.ATTACK)
+		pg.PerformAction(&own, &enemy)
+	}
+	if chosen := chooseCounter(own, enemy, pg.HELPLESS); chosen != pg.DEFEND {
+		t.Errorf("tired, it chose %v instead of defending", chosen)
+	}
+	if chosen := chooseCounter(own, enemy, pg.DEFEND); chosen != pg.GUARD {
+		t.Errorf("without stamina, it chose %v against a defense instead of waiting", chosen)
+	}
+}
+
+func TestLookaheadFinish(t *testing.T) {
+	own, enemy := pg.NewCreature(nil), pg.NewCreature(nil)
+
+	// Bring the enemy to a single hit from death
+	for life, _, _, _ := enemy.GetInfo(); life > 5; life, _, _, _ = enemy.GetInfo() {
+		own.SetAction(pg.ATTACK)
+		pg.PerformAction(&own, &enemy)
+	}
+
+	for _, spotted := range []pg.Status{pg.HELPLESS, pg.GUARD, pg.DEFEND} {
+		if chosen := chooseLookahead(own, enemy, spotted); chosen != pg.ATTACK {
+			t.Errorf("against %v it chose %v instead of the last attack", spotted, chosen)
+		}
+	}
+}
+
+func TestRandomActions(t *testing.T) {
+	var chosen = make(map[pg.Status]bool)
+
+	for i := 0; i < 1000; i++ {
+		chosen[chooseRandom()] = true
+	}
+	for _, action := range append([]pg.Status{pg.GUARD}, aiActions...) {
+		if !chosen[action] {
+			t.Errorf("%v was never chosen", action)
+		}
+	}
+	if len(chosen) != len(aiActions)+1 {
+		t.Errorf("chose %v", chosen)
+	}
+}
@@ -2,4 +2,8 @@ module DuelBot
 
 go 1.16
 
-require github.com/NicoNex/echotron/v3 v3.6.0
+require (
+	github.com/NicoNex/echotron/v3 v3.6.0
+	go.etcd.io/bbolt v1.3.6
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -1,38 +1,37 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"strings"
+	"time"
+
+	"DuelBot/pg"
 
 	"github.com/NicoNex/echotron/v3"
 )
 
-// TEMP: here will bw saved the battle history of a player
-var lastBattle = make(map[int64][]string, 0)
-
-// TEMP: generate the entire battle history ready to be displayed
-func GenPlayerHistory(ownerID int64) string {
-	return strings.Join(lastBattle[ownerID], "\n  ➖➖➖➖➖➖➖➖➖")
-}
-
-// TEMP: add a new report to the battle history of a player
-func addToPlayerHistory(ownerID int64, msgReport string) {
-	lastBattle[ownerID] = append(lastBattle[ownerID], msgReport)
-}
-
 // Make the actions (ME, ATTACK, GUARD ecc.. ) more pretty
 func Prettfy(rawAction string, conditional bool, emoji int8) (pretty string) {
 	var selectEmoji = map[string]string{
-		"ME":       "👤",
-		"ENEMY":    "👤",
-		"GUARD":    "👁‍🗨",
-		"ATTACK":   "⚔️",
-		"DEFEND":   "🛡",
-		"DODGE":    "➰",
-		"STUNNED":  "💫",
-		"EXAUSTED": "🥵",
-		"HELPLESS": "😵",
+		"ME":        "👤",
+		"ENEMY":     "👤",
+		"GUARD":     "👁‍🗨",
+		"ATTACK":    "⚔️",
+		"DEFEND":    "🛡",
+		"DODGE":     "➰",
+		"STUNNED":   "💫",
+		"EXAUSTED":  "🥵",
+		"HELPLESS":  "😵",
+		"POTION":    "🧪",
+		"TONIC":     "🍵",
+		"SMOKE":     "💨",
+		"NOCLASS":   "👤",
+		"KNIGHT":    "🏰",
+		"ROGUE":     "🥷",
+		"BERSERKER": "🪓",
 	}
 
 	switch rawAction {
@@ -40,6 +39,10 @@ func Prettfy(rawAction string, conditional bool, emoji int8) (pretty string) {
 		pretty = "Unable to fight"
 	case "GUARD":
 		pretty = "On Guard"
+	case "SMOKE":
+		pretty = "Smoke bomb"
+	case "NOCLASS":
+		pretty = "No class"
 	default:
 		pretty = fmt.Sprint(string(rawAction[0]), strings.ToLower(rawAction[1:]))
 	}
@@ -50,6 +53,10 @@ func Prettfy(rawAction string, conditional bool, emoji int8) (pretty string) {
 			pretty = pretty[:len(pretty)-1] + "ing"
 		case "ATTACK", "DEFEND":
 			pretty += "ing"
+		case "POTION", "TONIC":
+			pretty = "Drinking a " + strings.ToLower(pretty)
+		case "SMOKE":
+			pretty = "Throwing a " + strings.ToLower(pretty)
 		}
 	}
 
@@ -79,8 +86,8 @@ func GenOffsetInfoBar(lifeOffset, staminaOffset, damageDealt int) string {
 		bar = append(bar, fmt.Sprint("⚡ ", staminaOffset))
 	}
 
-	if damageDealt < 0 {
-		bar = append(bar, fmt.Sprint("🗡 ", damageDealt*-1))
+	if damageDealt > 0 {
+		bar = append(bar, fmt.Sprint("🗡 ", damageDealt))
 	}
 
 	return strings.Join(bar, "|")
@@ -88,7 +95,7 @@ func GenOffsetInfoBar(lifeOffset, staminaOffset, damageDealt int) string {
 
 // Generate the info bar with life points and stamina bar
 func genInfoBar(userID int64) string {
-	life, stamina, max, _, err := GetPlayerInfo(userID)
+	life, stamina, max, _, err := duels.GetPlayerInfo(userID)
 	if err != nil {
 		return ""
 	}
@@ -98,9 +105,49 @@ func genInfoBar(userID int64) string {
 	)
 }
 
+// Generate the tag with the class of a player inside a duel ("" if he has none)
+func genClassTag(userID int64) string {
+	class, err := duels.GetPlayerClass(userID)
+	if err != nil || class == pg.NOCLASS {
+		return ""
+	}
+	return " (" + Prettfy(classNames[class], false, -1) + ")"
+}
+
+// Generate the description of how a class changes stats and actions
+func genClassInfo(class pg.Class) string {
+	var (
+		profile = RULES.ClassProfile(class)
+		mods    []string
+	)
+
+	if profile.Health != 0 {
+		mods = append(mods, fmt.Sprintf("❤ %+d", profile.Health))
+	}
+	if profile.Damage != 0 {
+		mods = append(mods, fmt.Sprintf("⚔️ %+d", profile.Damage))
+	}
+	if profile.Stamina != 0 {
+		mods = append(mods, fmt.Sprintf("⚡ %+d", profile.Stamina))
+	}
+	if profile.Defend != 0 {
+		mods = append(mods, fmt.Sprint("🛡 takes ", RULES.Multipliers.Defend+profile.Defend, "x damage"))
+	}
+	if profile.DodgeCost != 0 {
+		mods = append(mods, fmt.Sprintf("➰ %+d stamina to dodge", profile.DodgeCost))
+	}
+	if profile.AttackCost != 0 {
+		mods = append(mods, fmt.Sprint("⚔️ costs ", profile.AttackCost, " health"))
+	}
+	if mods == nil {
+		return "just the base stats"
+	}
+	return strings.Join(mods, ", ")
+}
+
 // Generate the info bar with the action of the player
 func genActionBar(userID int64) string {
-	move, err := GetPlayerAction(userID)
+	move, err := duels.GetPlayerAction(userID)
 	if err != nil {
 		return ""
 	}
@@ -109,6 +156,12 @@ func genActionBar(userID int64) string {
 
 // Warn a user of the new status of the opponent
 func (b *bot) SpyAction(toUserID, opponentID int64, move string) {
+	// AI opponents don't need messages, they just need to know
+	if isAI(toUserID) {
+		NotifyAI(toUserID, move)
+		return
+	}
+
 	text := fmt.Sprint(
 		"👁‍🗨 <b>", GenUserLink(opponentID, "Enemy"), " is ",
 		strings.ToLower(Prettfy(move, true, 1)), "</b>\n",
@@ -121,8 +174,12 @@ func (b *bot) SpyAction(toUserID, opponentID int64, move string) {
 // Notify the result of a perform
 func (b *bot) NotifyBattleReport(report BattleReport) {
 	for i, current := range report.PlayersInfo {
-		enemy := report.PlayersInfo[1-i]
-		DisplayReport(current, enemy)
+		if !report.Team {
+			DisplayReport(current, report.PlayersInfo[1-i])
+		} else if !isAI(current.UserID) {
+			names, _ := duels.GetNames(current.UserID)
+			UpdateReport(current.UserID, b.genTeamReportText(report, i, names))
+		}
 		if !report.EndDuel {
 			DisplayStatus(current.UserID, false)
 		}
@@ -131,16 +188,36 @@ func (b *bot) NotifyBattleReport(report BattleReport) {
 
 // Display the last battle report deleting the previous
 func DisplayReport(current, enemy PlayerReport) {
-	var text string
+	if !isAI(current.UserID) {
+		UpdateReport(current.UserID, genReportText(current, enemy))
+	}
+}
 
+// Generate the text of the report of a clash from the point of view of a player
+func genReportText(current, enemy PlayerReport) (text string) {
 	if current.GainEffect != nil {
 		text = "\n<b>You got " + Prettfy(*current.GainEffect, false, 1) + "</b>"
 	}
 
-	text += "\nYou %s" + Prettfy(current.Performed, false, 1) + "%s"
+	text += genOwnActionLine(current)
+	text += "\nEnemy <b>was " + Prettfy(enemy.Performed, true, 1) + "</b>"
+
+	if enemy.GainEffect != nil {
+		text += "\n<b>Enemy got " + Prettfy(*enemy.GainEffect, false, 1) + "</b>"
+	}
+
+	text += "\n\n" + GenOffsetInfoBar(current.LifeOff, current.StaminaOff, enemy.Damage)
+	return
+}
+
+// Generate the line of the report with the action performed by the player
+func genOwnActionLine(current PlayerReport) (text string) {
+	text = "\nYou %s" + Prettfy(current.Performed, false, 1) + "%s"
 	switch current.Performed {
 	case "HELPLESS", "STUNNED", "EXAUSTED":
 		text = fmt.Sprintf(text, "<b>were ", "</b>")
+	case "POTION", "TONIC", "SMOKE":
+		text = fmt.Sprintf(text, "<b>used a ", "</b>\nmeanwhile")
 	default:
 		if current.Success {
 			text = fmt.Sprintf(text, "<b>", " successfully</b>\nmeanwhile")
@@ -148,46 +225,227 @@ func DisplayReport(current, enemy PlayerReport) {
 			text = fmt.Sprintf(text, "<b>tried to ", "</b> but...")
 		}
 	}
+	return
+}
 
-	text += "\nEnemy <b>was " + Prettfy(enemy.Performed, true, 1) + "</b>"
+/* Generate the text of the report of a clash of a team duel from the point of view of the i-th player,
+ * names are the ones of the players saved when the duel started
+ */
+func (b *bot) genTeamReportText(report BattleReport, i int, names map[int64]string) (text string) {
+	var current = report.PlayersInfo[i]
 
-	if enemy.GainEffect != nil {
-		text += "\n<b>Enemy got " + Prettfy(*enemy.GainEffect, false, 1) + "</b>"
+	if current.GainEffect != nil {
+		text = "\n<b>You got " + Prettfy(*current.GainEffect, false, 1) + "</b>"
 	}
+	text += genOwnActionLine(current)
 
-	text += "\n\n" + GenOffsetInfoBar(current.LifeOff, current.StaminaOff, enemy.LifeOff)
+	for _, info := range report.PlayersInfo {
+		if info.UserID == current.UserID {
+			continue
+		}
+		text += "\n" + GenUserLink(info.UserID, b.knownName(names, info.UserID)) + " <b>was " + Prettfy(info.Performed, true, 1) + "</b>"
+		switch true {
+		case info.Performed != "ATTACK" && info.Performed != "DEFEND":
+		case info.Target == current.UserID:
+			text += " you"
+		default:
+			text += " " + GenUserLink(info.Target, b.knownName(names, info.Target))
+		}
+		if info.GainEffect != nil {
+			text += " and got " + Prettfy(*info.GainEffect, false, 1)
+		}
+	}
 
-	addToPlayerHistory(current.UserID, text)
-	UpdateReport(current.UserID, text)
+	text += "\n\n" + GenOffsetInfoBar(current.LifeOff, current.StaminaOff, report.damageDealt(i))
+	return
 }
 
 // Display the current status of a user
 func DisplayStatus(toUserID int64, newMessage bool) {
 	var text string
 
-	enemyID, _ := GetOpponentID(toUserID)
+	if members, err := duels.GetTeamMembers(toUserID); err == nil {
+		UpdateStatus(toUserID, genTeamStatus(toUserID, members), newMessage)
+		return
+	}
+
+	enemyID, _ := duels.GetOpponentID(toUserID)
 
 	text = fmt.Sprint(
-		"🏷 <b>You</b>: ", genInfoBar(toUserID), "\n\n",
-		"👤 <b>", GenUserLink(enemyID, "Enemy"), "</b>",
+		"🏷 <b>You</b>", genClassTag(toUserID), ": ", genInfoBar(toUserID), "\n\n",
+		"👤 <b>", GenUserLink(enemyID, "Enemy"), "</b>", genClassTag(enemyID),
 	)
-	if onGurad, _ := IsPlayerOnGuard(toUserID); onGurad {
+	if onGurad, _ := duels.IsPlayerOnGuard(toUserID); onGurad {
 		text += " current status: " + genActionBar(enemyID) + "\n"
 	} else {
 		text += ": "
 	}
 	text += genInfoBar(enemyID)
 
+	if settings, _ := duels.GetSettings(toUserID); settings.Series != nil {
+		text += "\n\n" + genSeriesLine(toUserID, settings)
+	}
+
 	UpdateStatus(toUserID, text, newMessage)
 }
 
-// Generate the inline keyboard with all the actions
-func genActionKbd(move string) (markup echotron.InlineKeyboardMarkup) {
+// Get a participant of a team duel (an empty one if not found)
+func findMember(members []TeamMember, userID int64) TeamMember {
+	for _, member := range members {
+		if member.UserID == userID {
+			return member
+		}
+	}
+	return TeamMember{Team: -1}
+}
+
+// Get the name of the team of a participant of a team duel
+func genTeamName(members []TeamMember, userID int64) string {
+	if team := findMember(members, userID).Team; team >= 0 && team < len(teamNames) {
+		return teamNames[team]
+	}
+	return ""
+}
+
+// Get the name of a player saved when his duel started, Telegram is asked only if it's missing
+func (b *bot) knownName(names map[int64]string, userID int64) string {
+	if name, ok := names[userID]; ok {
+		return name
+	}
+	return b.GetUserName(userID)
+}
+
+// Get the name of a participant of a team duel
+func (b *bot) memberName(member TeamMember) string {
+	if member.Name != "" {
+		return member.Name
+	}
+	return b.GetUserName(member.UserID)
+}
+
+// Generate the links to some users separated by commas
+func (b *bot) genUserList(userIDs []int64) string {
+	var links []string
+
+	for _, userID := range userIDs {
+		links = append(links, GenUserLink(userID, b.GetUserName(userID)))
+	}
+	return strings.Join(links, ", ")
+}
+
+// Get the headers of the groups of players shown inside a team duel, a brawl has only one
+func genTeamHeaders(brawl bool) []string {
+	var headers []string
+
+	if brawl {
+		return []string{"🥊 Brawl"}
+	}
+	for _, name := range teamNames {
+		headers = append(headers, name+" team")
+	}
+	return headers
+}
+
+/* Generate the status of a player of a team duel (or brawl): every participant grouped by team,
+ * with the actions of the enemies if he's on guard and who he's targeting or protecting
+ */
+func genTeamStatus(toUserID int64, members []TeamMember) string {
 	var (
-		mainActions = []string{"GUARD", "ATTACK", "DEFEND", "DODGE"}
-		row         []echotron.InlineKeyboardButton
+		b          = &bot{toUserID, echotron.NewAPI(TOKEN)}
+		self       = findMember(members, toUserID)
+		onGuard, _ = duels.IsPlayerOnGuard(toUserID)
+		brawl      = isBrawl(toUserID)
+		lines      []string
 	)
 
+	for team, header := range genTeamHeaders(brawl) {
+		lines = append(lines, "<b>"+header+"</b>")
+		for _, member := range members {
+			if !brawl && member.Team != team {
+				continue
+			}
+
+			line := "👤 <b>" + GenUserLink(member.UserID, b.memberName(member)) + "</b>"
+			if member.UserID == toUserID {
+				line = "🏷 <b>You</b>"
+			}
+			line += genClassTag(member.UserID)
+			switch true {
+			case member.Out:
+				lines = append(lines, line+": ☠ out of the duel")
+				continue
+			case onGuard && member.Team != self.Team:
+				line += " current status: " + genActionBar(member.UserID) + "\n"
+			default:
+				line += ": "
+			}
+			line += genInfoBar(member.UserID)
+
+			if member.UserID == self.Target {
+				line += " 🎯"
+			} else if member.UserID == self.Protect {
+				line += " 🔰"
+			}
+			lines = append(lines, line)
+		}
+		lines = append(lines, "")
+	}
+
+	if self.Out {
+		lines = append(lines, "<i>You are out, your allies are still fighting</i>")
+	} else {
+		lines = append(lines, "<i>🎯 is your target, 🔰 is the ally you protect when defending</i>")
+	}
+	return strings.Join(lines, "\n")
+}
+
+/* Generate the keyboard of the status of a player, in team duels it has also the buttons to choose
+ * his target between the enemies and the ally to protect. It's empty for who is out of the duel
+ */
+func genStatusKbd(userID int64, move string) (markup echotron.InlineKeyboardMarkup) {
+	var row []echotron.InlineKeyboardButton
+
+	items, _ := duels.GetPlayerItems(userID)
+	members, err := duels.GetTeamMembers(userID)
+	if err != nil {
+		return genActionKbd(move, items)
+	}
+	self := findMember(members, userID)
+	if self.Out {
+		return
+	}
+
+	b := &bot{userID, echotron.NewAPI(TOKEN)}
+	markup = genActionKbd(move, items)
+	for _, member := range members {
+		if member.UserID == userID || member.Out {
+			continue
+		}
+
+		text := "🎯 " + b.memberName(member)
+		if member.Team == self.Team {
+			text = "🔰 " + b.memberName(member)
+		}
+		if member.UserID == self.Target || member.UserID == self.Protect {
+			text = "▶️ " + text + " ◀️"
+		}
+		row = append(row, echotron.InlineKeyboardButton{Text: text, CallbackData: fmt.Sprint("/target ", member.UserID)})
+
+		if len(row) == 2 {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+			row = nil
+		}
+	}
+	if row != nil {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+	return
+}
+
+// Generate the inline keyboard with all the actions and the items still carried
+func genActionKbd(move string, items pg.Loadout) (markup echotron.InlineKeyboardMarkup) {
+	var row, itemsRow []echotron.InlineKeyboardButton
+
 	for i, action := range mainActions {
 		btn := echotron.InlineKeyboardButton{CallbackData: "/action " + action}
 
@@ -208,6 +466,23 @@ func genActionKbd(move string) (markup echotron.InlineKeyboardMarkup) {
 		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
 	}
 
+	for item, count := range items {
+		if count == 0 {
+			continue
+		}
+		btn := echotron.InlineKeyboardButton{CallbackData: "/action " + itemMoves[item]}
+
+		if move == itemMoves[item] {
+			btn.Text = fmt.Sprint("▶️ ", Prettfy(itemMoves[item], false, 0), " ×", count, " ◀️")
+		} else {
+			btn.Text = fmt.Sprint(Prettfy(itemMoves[item], false, -1), " ×", count)
+		}
+		itemsRow = append(itemsRow, btn)
+	}
+	if itemsRow != nil {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, itemsRow)
+	}
+
 	return
 }
 
@@ -215,12 +490,451 @@ func genActionKbd(move string) (markup echotron.InlineKeyboardMarkup) {
 func genRematchKbd(opponentID int64) (markup *echotron.MessageReplyMarkup) {
 	var kbd echotron.InlineKeyboardMarkup
 
-	kbd.InlineKeyboard = [][]echotron.InlineKeyboardButton{{
-		{Text: "📜 Battle history", CallbackData: "/history"},
-		{Text: "🔄 Rematch", CallbackData: fmt.Sprint("/inviteid ", opponentID, " rematch")},
-	}}
+	kbd.InlineKeyboard = [][]echotron.InlineKeyboardButton{
+		{{Text: "📜 Battle history", CallbackData: "/history"}},
+		{
+			{Text: "🔄 Rematch", CallbackData: fmt.Sprint("/inviteid ", opponentID, " rematch")},
+			{Text: "🤝 Friendly rematch", CallbackData: fmt.Sprint("/inviteid ", opponentID, " rematch friendly")},
+		},
+	}
+
+	// Against the AI the rematch is just another practice with the same difficulty
+	switch settings, _ := duels.GetSettings(opponentID); true {
+	case isAI(opponentID):
+		kbd.InlineKeyboard[1] = []echotron.InlineKeyboardButton{
+			{Text: "🔄 Rematch", CallbackData: fmt.Sprint("/practice ", settings.AI)},
+			{Text: "🤖 Change difficulty", CallbackData: "/practice"},
+		}
+	case settings.Series != nil && !settings.Series.Over(settings.BestOf), settings.Tournament:
+		// The next round of the series or the next match of the tournament will start by itself
+		kbd.InlineKeyboard = kbd.InlineKeyboard[:1]
+	}
+
+	return &echotron.MessageReplyMarkup{ReplyMarkup: kbd}
+}
+
+// Generate the line that describe an invite inside a list
+func genInviteLine(position int, invite Invite) string {
+	var line = fmt.Sprint("<code>", position, ".</code> ")
+
+	if invite.Ranked {
+		line += "🏅 "
+	} else {
+		line += "🤝 "
+	}
+	if invite.Name != "" {
+		line += "<b>" + html.EscapeString(invite.Name) + "</b>"
+	} else {
+		line += "<i>unnamed</i>"
+	}
+	if invite.GroupID != 0 {
+		line += " (👥 group)"
+	}
+	if invite.BestOf > 1 {
+		line += fmt.Sprint(" (🏆 bo", invite.BestOf, ")")
+	}
+
+	if invite.MaxUses == 0 {
+		line += fmt.Sprint(" - 🎟 ", invite.Uses, "/♾")
+	} else {
+		line += fmt.Sprint(" - 🎟 ", invite.Uses, "/", invite.MaxUses)
+	}
+	return line + " - ⏳ " + formatDuration(time.Until(invite.Expires))
+}
+
+// Display the outstanding invites of a user with the buttons to cancel them
+func (b *bot) DisplayInvites(userID int64, IDO *echotron.MessageIDOptions) {
+	var (
+		text    = "📋 <b>Your invites</b>\n"
+		invites = getInvites(userID)
+		row     []echotron.InlineKeyboardButton
+		kbd     echotron.InlineKeyboardMarkup
+	)
+
+	if len(invites) == 0 {
+		text += "\n<i>You have no outstanding invites</i>"
+	}
+	for i, invite := range invites {
+		text += "\n" + genInviteLine(i+1, invite)
+
+		row = append(row, echotron.InlineKeyboardButton{
+			Text:         fmt.Sprint("❌ Cancel ", i+1),
+			CallbackData: fmt.Sprint("/invites cancel ", invite.Nonce),
+		})
+		if len(row) == 3 {
+			kbd.InlineKeyboard = append(kbd.InlineKeyboard, row)
+			row = nil
+		}
+	}
+	if row != nil {
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, row)
+	}
+	if len(invites) > 1 {
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{
+			{Text: "🗑 Cancel all", CallbackData: "/invites cancel all"},
+		})
+	}
+	text += "\n\n<i>Canceled invites can't be used anymore to start a duel against you</i>"
+
+	kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{
+		{Text: "🔗 New invite", CallbackData: "/invite refresh"},
+		{Text: "🔙 Go Back", CallbackData: "/start invitationInfo"},
+	})
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+// Display the difficulties of the AI opponent that can be choosen for a practice duel
+func (b *bot) DisplayPractice(IDO *echotron.MessageIDOptions) {
+	var (
+		text = "🤖 <b>Practice against me</b>\nChoose how strong you want me to be:\n"
+		kbd  echotron.InlineKeyboardMarkup
+	)
+
+	for _, difficulty := range aiDifficulties {
+		text += "\n<b>" + difficulty.Name + "</b> - <i>" + difficulty.Description + "</i>"
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{
+			{Text: difficulty.Name, CallbackData: fmt.Sprint("/practice ", difficulty.Level)},
+		})
+	}
+	text += "\n\n🤝 <i>Practice duels will not change your rating</i>"
+	kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{
+		{Text: "🔙 Go Back", CallbackData: "/start invitationInfo"},
+	})
+
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+// Display the lifetime statistics of a player
+func (b *bot) DisplayProfile(userID int64, IDO *echotron.MessageIDOptions) {
+	var profile = GetProfile(userID)
+
+	text := fmt.Sprint(
+		"👤 <b>Profile of ", GenUserLink(userID, b.GetUserName(userID)), "</b>\n",
+		"\n🏆 Wins: <code>", profile.Wins, "</code>",
+		"\n☠ Losses: <code>", profile.Losses, "</code>",
+		"\n⚖️ Draws: <code>", profile.Draws, "</code>",
+		"\n🏳️ Flees: <code>", profile.Flees, "</code>\n",
+		"\n🗡 Damage dealt: <code>", profile.DamageDealt, "</code>",
+		"\n💔 Damage taken: <code>", profile.DamageTaken, "</code>\n",
+	)
+
+	if favourite := profile.FavouriteAction(); favourite != "" {
+		text += "\n❤️ Favourite action: <b>" + Prettfy(favourite, false, 1) + "</b>\n"
+	}
+
+	text += "\n<b>Success rate</b>"
+	for _, action := range mainActions {
+		text += "\n" + Prettfy(action, false, -1) + ": "
+		if rate := profile.SuccessRate(action); rate == -1 {
+			text += "<i>never performed</i>"
+		} else {
+			text += fmt.Sprint("<code>", rate, "%</code> (", profile.Succeeded[action], "/", profile.Performed[action], ")")
+		}
+	}
+
+	kbd := echotron.InlineKeyboardMarkup{
+		InlineKeyboard: [][]echotron.InlineKeyboardButton{
+			{{Text: "🏅 Rank", CallbackData: "/rank"}, {Text: "🎭 Class", CallbackData: "/class"}},
+			{{Text: "🔙 Main menu", CallbackData: "/start"}},
+		},
+	}
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+// Display the classes that a player can choose for his next duels
+func (b *bot) DisplayClasses(userID int64, IDO *echotron.MessageIDOptions) {
+	var (
+		current = toClass[GetProfile(userID).Class]
+		text    = "🎭 <b>Choose your class</b>\nIt changes your stats and how your actions work in the next duels:\n"
+		kbd     echotron.InlineKeyboardMarkup
+	)
+
+	for class, name := range classNames {
+		text += "\n<b>" + Prettfy(name, false, -1) + "</b> - <i>" + genClassInfo(pg.Class(class)) + "</i>"
+
+		btn := echotron.InlineKeyboardButton{Text: Prettfy(name, false, -1), CallbackData: "/class " + name + " menu"}
+		if pg.Class(class) == current {
+			btn.Text = "▶️ " + Prettfy(name, false, 0) + " ◀️"
+		}
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{btn})
+	}
+	text += "\n\n<i>The class can't be changed while fighting, the new one is used from the next duel</i>"
+	kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{
+		{Text: "🔙 Go Back", CallbackData: "/profile"},
+	})
+
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+// Display the rating of a player
+func (b *bot) DisplayRank(userID int64, IDO *echotron.MessageIDOptions) {
+	var profile = GetProfile(userID)
+
+	text := fmt.Sprint(
+		"🏅 <b>Rating of ", GenUserLink(userID, b.GetUserName(userID)), "</b>: <code>", profile.Rating, "</code>\n",
+		"Ranked duels played: <code>", profile.Ranked, "</code>\n",
+	)
+	if position := GlobalPosition(userID); position != 0 {
+		text += fmt.Sprint("Global leaderboard position: <code>#", position, "</code>\n")
+	}
+	text += fmt.Sprint(
+		"\n<i>The rating change only after a ranked duel: you gain points when you win and lose ",
+		"them when you loose or flee. Beating a stronger opponent is worth more points</i>",
+	)
+
+	kbd := echotron.InlineKeyboardMarkup{
+		InlineKeyboard: [][]echotron.InlineKeyboardButton{
+			{{Text: "👤 Profile", CallbackData: "/profile"}, {Text: "🏆 Leaderboard", CallbackData: "/top global 0"}},
+			{{Text: "🔙 Main menu", CallbackData: "/start"}},
+		},
+	}
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+// Generate the emoji with the position of a player in a leaderboard
+func genPositionIcon(position int) string {
+	switch position {
+	case 1:
+		return "🥇"
+	case 2:
+		return "🥈"
+	case 3:
+		return "🥉"
+	}
+	return fmt.Sprint("<code>", position, ".</code>")
+}
+
+/* Display a page of the global leaderboard or of the one of a group (groupID == 0 for global).
+ * The page are navigated like the help section using the Prev. and Next buttons
+ */
+func (b *bot) DisplayLeaderboard(groupID int64, page int, IDO *echotron.MessageIDOptions) {
+	var (
+		text, scope string
+		total       int
+		lines       []string
+		nav         []echotron.InlineKeyboardButton
+		kbd         echotron.InlineKeyboardMarkup
+	)
+
+	if groupID == 0 {
+		scope, text = "global", "🏆 <b>Global leaderboard</b>\n"
+		ranking := GlobalLeaderboard()
+		total = len(ranking)
+		if first, last, ok := pageBounds(page, leaderboardPageSize, total); ok {
+			for i, profile := range ranking[first:last] {
+				lines = append(lines, fmt.Sprint(
+					genPositionIcon(first+i+1), " ", GenUserLink(profile.UserID, b.GetUserName(profile.UserID)),
+					" - 🏅 <code>", profile.Rating, "</code>",
+				))
+			}
+		}
+	} else {
+		scope, text = "group", "🏆 <b>Leaderboard of this group</b>\n<i>Only duels started from invites posted here</i>\n"
+		ranking := GroupLeaderboard(groupID)
+		total = len(ranking)
+		if first, last, ok := pageBounds(page, leaderboardPageSize, total); ok {
+			for i, record := range ranking[first:last] {
+				lines = append(lines, fmt.Sprint(
+					genPositionIcon(first+i+1), " ", GenUserLink(record.UserID, b.GetUserName(record.UserID)),
+					" - 🏆 ", record.Wins, " | ☠ ", record.Losses, " | ⚖️ ", record.Draws,
+				))
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		text += "\n<i>There is nothing to see here, nobody fought yet</i>"
+	} else {
+		text += "\n" + strings.Join(lines, "\n")
+	}
+
+	if page > 0 {
+		nav = append(nav, echotron.InlineKeyboardButton{Text: "⏮ Prev.", CallbackData: fmt.Sprint("/top ", scope, " ", page-1)})
+	}
+	if (page+1)*leaderboardPageSize < total {
+		nav = append(nav, echotron.InlineKeyboardButton{Text: "Next ⏭", CallbackData: fmt.Sprint("/top ", scope, " ", page+1)})
+	}
+	if nav != nil {
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, nav)
+	}
+
+	switch true {
+	case isGroup(b.chatID) && groupID == 0:
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{{Text: "👥 This group", CallbackData: "/top group 0"}})
+	case isGroup(b.chatID):
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{{Text: "🌍 Global", CallbackData: "/top global 0"}})
+	default:
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{{Text: "🔙 Main menu", CallbackData: "/start"}})
+	}
+	kbd.InlineKeyboard = append(kbd.InlineKeyboard, []echotron.InlineKeyboardButton{{Text: "❌ Close", CallbackData: "/top close"}})
+
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+// Generate the result of an ended duel from the point of view of a player, with his rating change
+func genHistoryResult(userID int64, record DuelLog) (result string) {
+	switch winnerID, fleeingID, over := record.Outcome(); true {
+	case !over:
+		result = "⏳ Not ended"
+	case record.Teams != nil && winnerID == nil:
+		result = "⚖️ Draw"
+	case record.Teams != nil && record.Won(userID):
+		result = "🥇 Won"
+	case record.Teams != nil:
+		result = "☠ Lost"
+	case record.EndedBy(EventAbandon):
+		result = "💤 Abandoned"
+	case record.EndedBy(EventTimeout) && fleeingID == userID:
+		result = "⏰ Lost for inactivity"
+	case record.EndedBy(EventTimeout):
+		result = "🥇 Won (opponent inactive)"
+	case fleeingID == userID:
+		result = "🏳️ Fled"
+	case fleeingID != 0:
+		result = "🥇 Won (opponent fled)"
+	case winnerID == nil:
+		result = "⚖️ Draw"
+	case *winnerID == userID:
+		result = "🥇 Won"
+	default:
+		result = "☠ Lost"
+	}
+
+	if delta, ok := record.Changes[userID]; ok {
+		result += fmt.Sprintf(" <code>%+d</code>", delta)
+	}
+	return
+}
+
+/* Display the list of the past duels of a player, from the latest. Every page has
+ * historyPageSize duels and they are navigated like the help section
+ */
+func (b *bot) DisplayHistory(userID int64, page int, IDO *echotron.MessageIDOptions) {
+	var (
+		text    = "📜 <b>Your past duels</b>\n"
+		lines   []string
+		choices []echotron.InlineKeyboardButton
+		nav     []echotron.InlineKeyboardButton
+		kbd     echotron.InlineKeyboardMarkup
+	)
+
+	IDs, err := STORE.LoadPlayedDuels(userID)
+	if err != nil {
+		log.Println("DisplayHistory", "LoadPlayedDuels", err)
+	}
+
+	if first, last, ok := pageBounds(page, historyPageSize, len(IDs)); ok {
+		for i := first; i < last; i++ {
+			// The latest duels are at the end
+			record, err := STORE.LoadDuelLog(IDs[len(IDs)-1-i])
+			if err != nil || record == nil {
+				log.Println("DisplayHistory", "LoadDuelLog", err)
+				continue
+			}
+
+			lines = append(lines, fmt.Sprint(
+				"<b>", i+1, ".</b> vs ", b.genUserList(record.Enemies(userID)),
+				" - ", record.Started.Format("02/01/2006"), "\n      ", genHistoryResult(userID, *record),
+			))
+			choices = append(choices, echotron.InlineKeyboardButton{
+				Text:         fmt.Sprint(i + 1),
+				CallbackData: fmt.Sprint("/history ", record.ID, " 0 ", page),
+			})
+		}
+	}
+
+	if len(lines) == 0 {
+		text += "\n<i>There is nothing to see here, you never fought</i>"
+	} else {
+		text += "\n" + strings.Join(lines, "\n") + "\n\n<i>Select a duel to see its clashes</i>"
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, choices)
+	}
+
+	if page > 0 {
+		nav = append(nav, echotron.InlineKeyboardButton{Text: "⏮ Prev.", CallbackData: fmt.Sprint("/history page ", page-1)})
+	}
+	if (page+1)*historyPageSize < len(IDs) {
+		nav = append(nav, echotron.InlineKeyboardButton{Text: "Next ⏭", CallbackData: fmt.Sprint("/history page ", page+1)})
+	}
+	if nav != nil {
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, nav)
+	}
+	kbd.InlineKeyboard = append(kbd.InlineKeyboard,
+		[]echotron.InlineKeyboardButton{{Text: "🔙 Main menu", CallbackData: "/start"}},
+		[]echotron.InlineKeyboardButton{{Text: "❌ Close", CallbackData: "/history close"}},
+	)
 
-	return &echotron.MessageReplyMarkup{kbd}
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+/* Display the report of a clash of a past duel of a player, the same he recived while fighting.
+ * The clashes are navigated like the help section, page is the one of the list to go back to
+ */
+func (b *bot) DisplayDuelHistory(userID int64, record DuelLog, clash, page int, IDO *echotron.MessageIDOptions) {
+	var (
+		reports = record.Reports()
+		nav     []echotron.InlineKeyboardButton
+	)
+
+	text := fmt.Sprint(
+		"📜 <b>Duel against ", b.genUserList(record.Enemies(userID)), "</b>\n",
+		"<i>", record.Started.Format("02/01/2006 15:04"), "</i> - <code>", record.ID, "</code>\n\n",
+	)
+
+	if clash < 0 || clash >= len(reports) {
+		clash = len(reports) - 1
+	}
+	switch true {
+	case clash < 0:
+		text += "<i>No clash happened during this duel</i>"
+	case reports[clash].Team:
+		text += fmt.Sprint("⚔️ <b>Clash ", clash+1, " of ", len(reports), "</b>\n")
+		if i := reports[clash].indexOf(userID); i == -1 {
+			text += "<i>You were already out of the duel</i>"
+		} else {
+			text += b.genTeamReportText(reports[clash], i, record.Names)
+		}
+	default:
+		var current, enemy PlayerReport
+		for _, info := range reports[clash].PlayersInfo {
+			if info.UserID == userID {
+				current = info
+			} else {
+				enemy = info
+			}
+		}
+		text += fmt.Sprint("⚔️ <b>Clash ", clash+1, " of ", len(reports), "</b>\n", genReportText(current, enemy))
+	}
+	if clash == len(reports)-1 {
+		text += "\n\n<b>Result</b>: " + genHistoryResult(userID, record)
+	}
+
+	if clash > 0 {
+		nav = append(nav, echotron.InlineKeyboardButton{Text: "⏮ Prev.", CallbackData: fmt.Sprint("/history ", record.ID, " ", clash-1, " ", page)})
+	}
+	if clash < len(reports)-1 {
+		nav = append(nav, echotron.InlineKeyboardButton{Text: "Next ⏭", CallbackData: fmt.Sprint("/history ", record.ID, " ", clash+1, " ", page)})
+	}
+
+	kbd := echotron.InlineKeyboardMarkup{}
+	if nav != nil {
+		kbd.InlineKeyboard = append(kbd.InlineKeyboard, nav)
+	}
+	kbd.InlineKeyboard = append(kbd.InlineKeyboard,
+		[]echotron.InlineKeyboardButton{{Text: "📄 Export", CallbackData: "/history html " + record.ID}},
+		[]echotron.InlineKeyboardButton{{Text: "🔙 All duels", CallbackData: fmt.Sprint("/history page ", page)}},
+	)
+
+	b.DisplayMessage(text, IDO, false, &kbd)
+}
+
+// Generate the hint about how the friends of a player can watch his duel
+func genWatchHint(userID int64) string {
+	duelID, err := duels.GetDuelID(userID)
+	if err != nil {
+		return ""
+	}
+	return "\n<i>Your friends can watch it using</i> <code>/watch " + duelID + "</code>"
 }
 
 // Notify the users that the duel is starting
@@ -228,10 +942,12 @@ func (b *bot) NotifyAcceptDuel(firstID, secondID int64) {
 	var IDs = [2]int64{firstID, secondID}
 
 	for i, currentID := range IDs {
-		delete(lastBattle, currentID)
+		if isAI(currentID) {
+			continue
+		}
 		user := GenUserLink(IDs[1-i], b.GetUserName(IDs[1-i]))
 		b.SendMessage(
-			fmt.Sprint("Duel against ", user, " is now starting 🏁"),
+			fmt.Sprint("Duel against ", user, " is now starting 🏁", genWatchHint(currentID)),
 			currentID,
 			&echotron.MessageOptions{ParseMode: echotron.HTML},
 		)
@@ -240,71 +956,659 @@ func (b *bot) NotifyAcceptDuel(firstID, secondID int64) {
 	}
 }
 
-// Notify the users of the end of a match by draw
-func (b *bot) NotifyDraw(player1ID, player2ID int64) {
+// Edit the message of an open challenge showing who is fighting and a button to watch them
+func (b *bot) AnnounceDuel(posted MessageRef, challengerID, opponentID int64) {
+	var opt = echotron.MessageTextOptions{ParseMode: echotron.HTML}
+
+	if duelID, err := duels.GetDuelID(challengerID); err == nil {
+		opt.ReplyMarkup = echotron.InlineKeyboardMarkup{
+			InlineKeyboard: [][]echotron.InlineKeyboardButton{
+				{{Text: "👀 Watch", CallbackData: "/watch " + duelID}},
+			},
+		}
+	}
+
+	b.EditMessageText(
+		fmt.Sprint(
+			"⚔️ <b>Challenge accepted</b>\n",
+			GenUserLink(challengerID, b.GetUserName(challengerID)), " is fighting against ",
+			GenUserLink(opponentID, b.GetUserName(opponentID)), "\n",
+			"\n<i>The result of the duel will be shown here</i>",
+		),
+		posted.IDO(),
+		&opt,
+	)
+}
+
+// Generate the read-only live view of the duel of a player for the spectators
+func (b *bot) genSpectatorView(userID int64, footer string) (text string) {
+	if members, err := duels.GetTeamMembers(userID); err == nil {
+		brawl := isBrawl(userID)
+		text = "👀 <b>Live team duel</b>\n"
+		if brawl {
+			text = "👀 <b>Live brawl</b>\n"
+		}
+		for team, header := range genTeamHeaders(brawl) {
+			text += "\n<b>" + header + "</b>"
+			for _, member := range members {
+				if !brawl && member.Team != team {
+					continue
+				}
+				text += "\n👤 <b>" + GenUserLink(member.UserID, b.memberName(member)) + "</b>: "
+				if member.Out {
+					text += "☠ out of the duel"
+				} else {
+					text += genInfoBar(member.UserID)
+				}
+			}
+		}
+	} else {
+		enemyID, _ := duels.GetOpponentID(userID)
+		text = fmt.Sprint(
+			"👀 <b>Live duel</b>\n\n",
+			"👤 <b>", GenUserLink(userID, b.GetUserName(userID)), "</b>: ", genInfoBar(userID), "\n",
+			"👤 <b>", GenUserLink(enemyID, b.GetUserName(enemyID)), "</b>: ", genInfoBar(enemyID),
+		)
+	}
+
+	if footer != "" {
+		text += "\n\n" + footer
+	}
+	return text
+}
+
+// Generate the summary of a clash for the spectators
+func (b *bot) genClashSummary(report BattleReport) string {
+	var lines = []string{"<b>Last clash</b>"}
+
+	for i, current := range report.PlayersInfo {
+		line := fmt.Sprint(
+			GenUserLink(current.UserID, b.GetUserName(current.UserID)), ": ", Prettfy(current.Performed, false, 1),
+		)
+		if current.Success {
+			line += " ✅"
+		}
+		if bar := GenOffsetInfoBar(current.LifeOff, current.StaminaOff, report.damageDealt(i)); bar != "" {
+			line += " (" + bar + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Send the live view of the duel of a player to a chat that will watch it
+func (b *bot) DisplaySpectatorView(userID, chatID int64) error {
+	duelID, err := duels.GetDuelID(userID)
+	if err != nil {
+		return err
+	}
+
+	// Only one live view for chat
+	if spectators, _ := duels.GetSpectators(userID); spectators != nil {
+		if messageID, watching := spectators[chatID]; watching {
+			b.DeleteMessage(chatID, messageID)
+		}
+	}
+
+	res, err := b.SendMessage(
+		b.genSpectatorView(userID, "<i>The view will be updated after every clash</i>"),
+		chatID,
+		&echotron.MessageOptions{
+			ParseMode: echotron.HTML,
+			BaseOptions: echotron.BaseOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{
+				InlineKeyboard: [][]echotron.InlineKeyboardButton{
+					{{Text: "🚪 Stop watching", CallbackData: "/unwatch " + duelID}},
+				},
+			}},
+		},
+	)
+	if err != nil || res.Result == nil {
+		log.Println("DisplaySpectatorView", "SendMessage", err)
+		return errors.New("Unable to send the live view")
+	}
+
+	return duels.AddSpectator(userID, chatID, res.Result.ID)
+}
+
+// Update the live view of all the spectators of the duel of a player, if final it can't be stopped anymore
+func (b *bot) UpdateSpectators(userID int64, footer string, final bool) {
+	var opt = echotron.MessageTextOptions{ParseMode: echotron.HTML}
+
+	spectators, err := duels.GetSpectators(userID)
+	if err != nil || len(spectators) == 0 {
+		return
+	}
+	if duelID, err := duels.GetDuelID(userID); err == nil && !final {
+		opt.ReplyMarkup = echotron.InlineKeyboardMarkup{
+			InlineKeyboard: [][]echotron.InlineKeyboardButton{
+				{{Text: "🚪 Stop watching", CallbackData: "/unwatch " + duelID}},
+			},
+		}
+	}
+
+	text := b.genSpectatorView(userID, footer)
+	for chatID, messageID := range spectators {
+		b.EditMessageText(text, echotron.NewMessageID(chatID, messageID), &opt)
+	}
+}
+
+// Edit the message of the open challenge that started the duel of a player with its result
+func (b *bot) AnnounceResult(userID int64, text string) {
+	posted, err := duels.GetPosted(userID)
+	if err != nil || posted == nil {
+		return
+	}
+	b.EditMessageText(text, posted.IDO(), &echotron.MessageTextOptions{ParseMode: echotron.HTML})
+}
+
+// Notify the user that the duel was paused (bot restarted) and now is resuming
+func (b *bot) NotifyResume(userID int64) {
+	enemyIDs, err := duels.GetEnemies(userID)
+	if err != nil {
+		log.Println("NotifyResume", "GetEnemies", err)
+		return
+	}
+
+	// The old menu might still be there, remove it before sending the new one
+	if menuID, err := duels.GetPlayerMenuID(userID); err == nil && menuID != -1 {
+		b.DeleteMessage(userID, menuID)
+	}
+
+	b.SendMessage(
+		fmt.Sprint(
+			"⏸ <b>The duel against ", b.genUserList(enemyIDs), " was paused</b>\n",
+			"<i>Sorry for the inconvenience, I needed a little break. The fight is now resumed</i> ▶️",
+		),
+		userID,
+		&echotron.MessageOptions{ParseMode: echotron.HTML},
+	)
+	DisplayStatus(userID, true)
+	UpdateReport(userID, "The fight is resumed, both of you are back on guard\n<i>Here will be displayed the report of the next clash</i>")
+}
+
+// Resume all the duels restored from the store notifying their players and restarting the AI opponents
+func resumeDuels(userIDs []int64) {
+	var b = &bot{API: echotron.NewAPI(TOKEN)}
+
+	for _, userID := range userIDs {
+		if !isAI(userID) {
+			b.NotifyResume(userID)
+			continue
+		}
+		if settings, err := duels.GetSettings(userID); err != nil {
+			log.Println("resumeDuels", "GetSettings", err)
+		} else if err = StartAI(userID, settings.AI); err != nil {
+			log.Println("resumeDuels", "StartAI", err)
+		}
+	}
+}
+
+// Notify the users of the end of a match by draw (changes is nil if duel is not ranked)
+func (b *bot) NotifyDraw(player1ID, player2ID int64, changes RatingChanges) {
 	var IDs = []int64{player1ID, player2ID}
 
+	b.AdvanceTournament(player1ID, 0, true)
+
+	b.AnnounceResult(player1ID, fmt.Sprint(
+		"⚖️ <b>The duel between ", GenUserLink(player1ID, b.GetUserName(player1ID)), " and ",
+		GenUserLink(player2ID, b.GetUserName(player2ID)), " is a draw</b>",
+	))
+
 	for i, id := range IDs {
+		if isAI(id) {
+			continue
+		}
 		enemy := GenUserLink(IDs[1-i], b.GetUserName(IDs[1-i]))
 		res, _ := b.SendMessage(
-			fmt.Sprint("⚖️ <b>The match is a draw</b> in the battle against ", enemy, "\n"),
+			fmt.Sprint("⚖️ <b>The match is a draw</b> in the battle against ", enemy, "\n", genRatingLine(id, changes)),
 			id,
 			&echotron.MessageOptions{ParseMode: echotron.HTML},
 		)
 		b.EditMessageReplyMarkup(echotron.NewMessageID(id, res.Result.ID), genRematchKbd(IDs[1-i]))
-		addToPlayerHistory(id, "⚖️ <b>The match is a draw</b>")
 	}
 }
 
-// Notify the users of the win / lost of a match
-func (b *bot) NotifyEndDuel(winnerID int64) {
+// Notify the users of the win / lost of a match (changes is nil if duel is not ranked)
+func (b *bot) NotifyEndDuel(winnerID int64, changes RatingChanges) {
 	var opt = echotron.MessageOptions{ParseMode: echotron.HTML}
 
-	looserID, err := GetOpponentID(winnerID)
+	looserID, err := duels.GetOpponentID(winnerID)
 	if err != nil {
 		log.Println("NotifyEndDuel", "GetOpponentID", err)
 		return
 	}
+	b.AdvanceTournament(winnerID, winnerID, false)
 	winnerName, looserName := b.GetUserName(winnerID), b.GetUserName(looserID)
 
+	b.AnnounceResult(winnerID, fmt.Sprint(
+		"🏆 <b>", GenUserLink(winnerID, winnerName), " won the duel against ", GenUserLink(looserID, looserName), "</b>",
+	))
+
 	text := fmt.Sprint(
 		"🥇 <b>You win</b> in the battle against ", GenUserLink(looserID, looserName), "\n",
 		"<i>Congratulation ", winnerName, " the big spirit of the war is proud of you</i>",
+		genRatingLine(winnerID, changes),
 	)
-	addToPlayerHistory(winnerID, "🥇 <b>You win</b>")
-	res, _ := b.SendMessage(text, winnerID, &opt)
-	b.EditMessageReplyMarkup(echotron.NewMessageID(winnerID, res.Result.ID), genRematchKbd(looserID))
+	if !isAI(winnerID) {
+		res, _ := b.SendMessage(text, winnerID, &opt)
+		b.EditMessageReplyMarkup(echotron.NewMessageID(winnerID, res.Result.ID), genRematchKbd(looserID))
+	}
 
 	text = fmt.Sprint(
 		"☠ <b>You loose</b> the battle against ", GenUserLink(winnerID, winnerName), "\n",
 		"<i>I hope that the guardian spirit can assist you in the next battle</i>",
+		genRatingLine(looserID, changes),
+	)
+	if !isAI(looserID) {
+		res, _ := b.SendMessage(text, looserID, &opt)
+		b.EditMessageReplyMarkup(echotron.NewMessageID(looserID, res.Result.ID), genRematchKbd(winnerID))
+	}
+}
+
+// Warn the user that he will lose the duel if he doesn't move in the time left
+func (b *bot) NotifyIdle(idle, left time.Duration) {
+	b.SendMessage(
+		fmt.Sprint(
+			"⏰ <b>Are you still there?</b>\n",
+			"You didn't move for ", formatDuration(idle), ", if you don't do anything in the next ",
+			formatDuration(left), " you will lose the duel",
+		),
+		b.chatID,
+		&echotron.MessageOptions{ParseMode: echotron.HTML},
+	)
+}
+
+// Notify the user that he lost the duel because he didn't move for too long
+func (b *bot) NotifyTimeout(idleID int64) {
+	if isAI(idleID) {
+		return
+	}
+	b.SendMessage(
+		"⏰ <b>Time is up</b>\n<i>You didn't move for too long, your opponent won the duel</i>",
+		idleID,
+		&echotron.MessageOptions{ParseMode: echotron.HTML},
+	)
+}
+
+// Notify the users that their duel was closed because both of them didn't move for too long
+func (b *bot) NotifyAbandon(firstID, secondID int64) {
+	IDs := [2]int64{firstID, secondID}
+
+	b.AdvanceTournament(firstID, 0, false)
+
+	b.AnnounceResult(firstID, fmt.Sprint(
+		"💤 <b>The duel between ", GenUserLink(firstID, b.GetUserName(firstID)), " and ",
+		GenUserLink(secondID, b.GetUserName(secondID)), " was abandoned</b>",
+	))
+
+	for i, id := range IDs {
+		if isAI(id) {
+			continue
+		}
+		res, _ := b.SendMessage(
+			"💤 <b>The duel was abandoned</b>\n<i>Both of you didn't move for too long, nobody won</i>",
+			id,
+			&echotron.MessageOptions{ParseMode: echotron.HTML},
+		)
+		if res.Result != nil {
+			b.EditMessageReplyMarkup(echotron.NewMessageID(id, res.Result.ID), genRematchKbd(IDs[1-i]))
+		}
+	}
+}
+
+// Notify the players of a series the score after a round and that the next one is coming
+func (b *bot) NotifySeriesScore(settings DuelSettings) {
+	for _, id := range settings.Series.Players {
+		b.SendMessage(
+			fmt.Sprint(
+				genSeriesLine(id, settings), "\n",
+				"<i>The next round will start in ", seriesNextRoundIn, ", get ready</i>",
+			),
+			id,
+			&echotron.MessageOptions{ParseMode: echotron.HTML},
+		)
+	}
+}
+
+// Notify the players of a series who won it
+func (b *bot) NotifySeriesEnd(settings DuelSettings) {
+	var (
+		series              = settings.Series
+		winnerID, hasWinner = series.Winner()
+		IDs                 = series.Players
 	)
-	addToPlayerHistory(looserID, "☠ <b>You loose</b>")
-	res, _ = b.SendMessage(text, looserID, &opt)
-	b.EditMessageReplyMarkup(echotron.NewMessageID(looserID, res.Result.ID), genRematchKbd(winnerID))
+
+	for i, id := range IDs {
+		var (
+			own, enemy = series.Score(id)
+			opponent   = GenUserLink(IDs[1-i], b.GetUserName(IDs[1-i]))
+			text       string
+		)
+		switch true {
+		case !hasWinner:
+			text = fmt.Sprint("⚖️ <b>The series against ", opponent, " is a draw</b>")
+		case winnerID == id:
+			text = fmt.Sprint("🏆 <b>You won the series</b> against ", opponent)
+		default:
+			text = fmt.Sprint("☠ <b>You lost the series</b> against ", opponent)
+		}
+		b.SendMessage(
+			fmt.Sprint(text, "\nFinal score (best of ", settings.BestOf, "): <b>", own, " : ", enemy, "</b>"),
+			id,
+			&echotron.MessageOptions{ParseMode: echotron.HTML},
+		)
+	}
+
+	if hasWinner {
+		b.AnnounceResult(IDs[0], fmt.Sprint(
+			"🏆 <b>", GenUserLink(winnerID, b.GetUserName(winnerID)), " won the best of ", settings.BestOf, " series</b>",
+		))
+	}
 }
 
-// Notify the users of the withdrawn of one of the two
-func (b *bot) NotifyCancel() {
+// Notify the users of the withdrawn of one of the two (changes is nil if duel is not ranked)
+func (b *bot) NotifyCancel(changes RatingChanges) {
 	var opt = echotron.MessageOptions{ParseMode: echotron.HTML}
 
-	winnerID, err := GetOpponentID(b.chatID)
+	winnerID, err := duels.GetOpponentID(b.chatID)
 	if err != nil {
 		log.Println("NotifyCancel", "GetOpponentID", err)
 		return
 	}
+	b.AdvanceTournament(b.chatID, winnerID, false)
+
+	b.AnnounceResult(b.chatID, fmt.Sprint(
+		"🏳️ <b>", GenUserLink(b.chatID, b.GetUserName(b.chatID)), " fled from the duel against ",
+		GenUserLink(winnerID, b.GetUserName(winnerID)), "</b>",
+	))
 
 	text := fmt.Sprint(
 		"🏳️ <b>You flee</b> from the battle against ", GenUserLink(winnerID, b.GetUserName(winnerID)), "\n",
 		"<i>The big spirit of the war will not like this behaviour...</i>",
+		genRatingLine(b.chatID, changes),
 	)
 	b.SendMessage(text, b.chatID, &opt)
-	delete(lastBattle, b.chatID)
+	if isAI(winnerID) {
+		return
+	}
 
 	text = fmt.Sprint(
 		"🏃 <b>Your ", GenUserLink(b.chatID, "opponent"), " has withdrawn</b>\n",
 		"<i>Probably you are too strong for him or maybe he doesn't like your face...</i>",
+		genRatingLine(winnerID, changes),
 	)
 	b.SendMessage(text, winnerID, &opt)
-	delete(lastBattle, winnerID)
+}
+
+// Generate the line of a match inside the bracket of a tournament
+func genMatchLine(t Tournament, match TournamentMatch) (line string) {
+	var (
+		first  = html.EscapeString(t.Names[match.Players[0]])
+		second = html.EscapeString(t.Names[match.Players[1]])
+	)
+
+	switch true {
+	case match.Final:
+		line = "🏁 <i>Final</i> "
+	case t.Format == DoubleElimination && match.Losses == 1:
+		line = "🔻 "
+	}
+
+	switch true {
+	case match.Players[1] == 0:
+		return line + "➡️ " + first + " has a bye"
+	case !match.Played && match.DuelID != "":
+		return line + fmt.Sprint("⚔️ ", first, " vs ", second, " - <code>/watch ", match.DuelID, "</code>")
+	case !match.Played:
+		return line + "⏳ " + first + " vs " + second
+	case match.WinnerID == 0 && t.Format == RoundRobin:
+		return line + "⚖️ " + first + " and " + second + " drew"
+	case match.WinnerID == 0:
+		return line + "💤 " + first + " and " + second + " abandoned"
+	case match.WinnerID == match.Players[1]:
+		first, second = second, first
+	}
+	return line + "✅ <b>" + first + "</b> beat " + second
+}
+
+// Generate the bracket of a tournament with the buttons to join it while it's still open
+func genBracket(t Tournament) (text string, kbd echotron.InlineKeyboardMarkup) {
+	var sb strings.Builder
+
+	fmt.Fprint(&sb,
+		"🏟 <b>Tournament</b> - ", tournamentFormatNames[t.Format], "\n",
+		"👑 Host: ", html.EscapeString(t.Names[t.HostID]), "\n",
+	)
+
+	if !t.Started {
+		fmt.Fprint(&sb, "\n<b>Players</b> (", len(t.Players), "/", tournamentMaxPlayers, ")\n")
+		for i, userID := range t.Players {
+			fmt.Fprint(&sb, "<code>", i+1, ".</code> ", html.EscapeString(t.Names[userID]), "\n")
+		}
+		sb.WriteString("\n<i>Tap on Join to take part, the host will start it when everyone is in. The best rated players get the best seeds</i>")
+
+		kbd.InlineKeyboard = [][]echotron.InlineKeyboardButton{
+			{{Text: "➕ Join", CallbackData: "/tournament join"}, {Text: "➖ Leave", CallbackData: "/tournament leave"}},
+			{{Text: "▶️ Start", CallbackData: "/tournament start"}, {Text: "❌ Cancel", CallbackData: "/tournament cancel"}},
+		}
+		return sb.String(), kbd
+	}
+
+	if t.Format == RoundRobin {
+		sb.WriteString("\n<b>Standings</b>\n")
+		for i, userID := range t.Standings() {
+			fmt.Fprint(&sb, "<code>", i+1, ".</code> ", html.EscapeString(t.Names[userID]), " - ", t.points(userID), " pt\n")
+		}
+	}
+
+	for round := 1; round <= t.Round; round++ {
+		var lines []string
+		for _, match := range t.Matches {
+			if match.Round == round {
+				lines = append(lines, genMatchLine(t, match))
+			}
+		}
+		if lines != nil {
+			fmt.Fprint(&sb, "\n<b>Round ", round, "</b>\n", strings.Join(lines, "\n"), "\n")
+		}
+	}
+
+	switch true {
+	case !t.Ended:
+		sb.WriteString("\n<i>The matches start by themselves as soon as both players are free</i>")
+	case t.WinnerID == 0:
+		sb.WriteString("\n💤 <b>The tournament ended without a winner</b>")
+	default:
+		sb.WriteString("\n🏆 <b>" + html.EscapeString(t.Names[t.WinnerID]) + " won the tournament</b>")
+	}
+	return sb.String(), kbd
+}
+
+// Edit the bracket of a tournament inside its group, the winner is also announced when it's over
+func (b *bot) UpdateBracket(t Tournament) {
+	text, kbd := genBracket(t)
+	b.EditMessageText(
+		text,
+		echotron.NewMessageID(t.GroupID, t.MessageID),
+		&echotron.MessageTextOptions{ParseMode: echotron.HTML, ReplyMarkup: kbd},
+	)
+
+	if t.Ended && t.WinnerID != 0 {
+		b.SendMessage(
+			fmt.Sprint("🏆 <b>", GenUserLink(t.WinnerID, html.EscapeString(t.Names[t.WinnerID])), " won the tournament</b>\nCongratulations champion!"),
+			t.GroupID,
+			&echotron.MessageOptions{ParseMode: echotron.HTML},
+		)
+	}
+}
+
+// Generate the message of the lobby of a team duel with the buttons to join the teams
+func genLobby(l Lobby) (text string, kbd echotron.InlineKeyboardMarkup) {
+	var (
+		sb   strings.Builder
+		join []echotron.InlineKeyboardButton
+	)
+
+	if l.Brawl {
+		return genBrawlLobby(l)
+	}
+
+	fmt.Fprint(&sb,
+		"👥 <b>Team duel</b> - ", teamSize, " vs ", teamSize, "\n",
+		"👑 Host: ", html.EscapeString(l.Names[l.HostID]), "\n",
+	)
+	for team, name := range teamNames {
+		fmt.Fprint(&sb, "\n<b>", name, " team</b> (", len(l.Teams[team]), "/", teamSize, ")\n")
+		for _, userID := range l.Teams[team] {
+			fmt.Fprint(&sb, "- ", html.EscapeString(l.Names[userID]), "\n")
+		}
+		join = append(join, echotron.InlineKeyboardButton{Text: "➕ " + name, CallbackData: fmt.Sprint("/teamduel join ", team)})
+	}
+	sb.WriteString("\n<i>Choose your team, the duel starts by itself when both of them are full</i>")
+
+	kbd.InlineKeyboard = [][]echotron.InlineKeyboardButton{
+		join,
+		{{Text: "➖ Leave", CallbackData: "/teamduel leave"}, {Text: "❌ Cancel", CallbackData: "/teamduel cancel"}},
+	}
+	return sb.String(), kbd
+}
+
+// Generate the message of the lobby of a brawl with the buttons to join it and for the host to start it
+func genBrawlLobby(l Lobby) (text string, kbd echotron.InlineKeyboardMarkup) {
+	var sb strings.Builder
+
+	fmt.Fprint(&sb,
+		"🥊 <b>Brawl</b> - everyone against everyone\n",
+		"👑 Host: ", html.EscapeString(l.Names[l.HostID]), "\n",
+		"\n<b>Players</b> (", len(l.Teams[0]), "/", brawlMaxPlayers, ")\n",
+	)
+	for _, userID := range l.Teams[0] {
+		fmt.Fprint(&sb, "- ", html.EscapeString(l.Names[userID]), "\n")
+	}
+	fmt.Fprint(&sb, "\n<i>The host can start the brawl when at least ", brawlMinPlayers, " players joined, it starts by itself when full</i>")
+
+	kbd.InlineKeyboard = [][]echotron.InlineKeyboardButton{
+		{{Text: "➕ Join", CallbackData: "/brawl join"}, {Text: "➖ Leave", CallbackData: "/brawl leave"}},
+		{{Text: "🏁 Start", CallbackData: "/brawl start"}, {Text: "❌ Cancel", CallbackData: "/brawl cancel"}},
+	}
+	return sb.String(), kbd
+}
+
+// Edit the message of the lobby of a team duel inside its group
+func (b *bot) UpdateLobby(l Lobby) {
+	text, kbd := genLobby(l)
+	b.EditMessageText(
+		text,
+		echotron.NewMessageID(l.GroupID, l.MessageID),
+		&echotron.MessageTextOptions{ParseMode: echotron.HTML, ReplyMarkup: kbd},
+	)
+}
+
+// Edit the message of the lobby of a team duel showing the teams fighting and a button to watch them
+func (b *bot) AnnounceTeamDuel(posted MessageRef, l Lobby) {
+	var (
+		opt   = echotron.MessageTextOptions{ParseMode: echotron.HTML}
+		teams []string
+	)
+
+	if duelID, err := duels.GetDuelID(l.Teams[0][0]); err == nil {
+		opt.ReplyMarkup = echotron.InlineKeyboardMarkup{
+			InlineKeyboard: [][]echotron.InlineKeyboardButton{
+				{{Text: "👀 Watch", CallbackData: "/watch " + duelID}},
+			},
+		}
+	}
+	title := "⚔️ <b>Team duel started</b>\n"
+	for team, members := range l.Teams {
+		teams = append(teams, "<b>"+teamNames[team]+"</b>: "+b.genUserList(members))
+	}
+	if l.Brawl {
+		title, teams = "🥊 <b>Brawl started</b>\n", []string{b.genUserList(l.Teams[0])}
+	}
+
+	b.EditMessageText(
+		title+strings.Join(teams, "\n")+"\n\n<i>The result of the duel will be shown here</i>",
+		posted.IDO(),
+		&opt,
+	)
+}
+
+// Notify every player of the team duel of a user that it's starting
+func (b *bot) NotifyTeamDuelStart(userID int64) {
+	members, err := duels.GetTeamMembers(userID)
+	if err != nil {
+		log.Println("NotifyTeamDuelStart", "GetTeamMembers", err)
+		return
+	}
+	brawl := isBrawl(userID)
+
+	for _, member := range members {
+		var allies, enemies []int64
+
+		if isAI(member.UserID) {
+			continue
+		}
+		for _, other := range members {
+			switch true {
+			case other.UserID == member.UserID:
+			case other.Team == member.Team:
+				allies = append(allies, other.UserID)
+			default:
+				enemies = append(enemies, other.UserID)
+			}
+		}
+		text := fmt.Sprint(
+			"Team duel against ", b.genUserList(enemies), " is now starting 🏁\n",
+			"You fight in the <b>", genTeamName(members, member.UserID), " team</b> with ", b.genUserList(allies), "\n",
+			"<i>Choose your target with 🎯 and the ally to protect when defending with 🔰</i>",
+		)
+		if brawl {
+			text = fmt.Sprint(
+				"Brawl against ", b.genUserList(enemies), " is now starting 🏁\n",
+				"<i>Everyone is on his own, choose your target with 🎯</i>",
+			)
+		}
+		b.SendMessage(
+			text+genWatchHint(member.UserID),
+			member.UserID,
+			&echotron.MessageOptions{ParseMode: echotron.HTML},
+		)
+		DisplayStatus(member.UserID, true)
+		UpdateReport(member.UserID, "Enemies are approching...\n<i>Here will be displayed the report of the last clash. Now is still empty</i>")
+	}
+}
+
+// Notify the players of a team duel or brawl if their team won or lost (winners is nil if draw)
+func (b *bot) NotifyTeamDuelEnd(members []TeamMember, winners []int64, brawl bool) {
+	var kbd = &echotron.MessageReplyMarkup{ReplyMarkup: echotron.InlineKeyboardMarkup{
+		InlineKeyboard: [][]echotron.InlineKeyboardButton{
+			{{Text: "📜 Battle history", CallbackData: "/history"}},
+		},
+	}}
+
+	for _, member := range members {
+		var text string
+
+		switch true {
+		case isAI(member.UserID):
+			continue
+		case brawl && winners == nil:
+			text = "⚖️ <b>The brawl is a draw</b>\n<i>Nobody is still standing</i>"
+		case brawl && containsID(winners, member.UserID):
+			text = "🥇 <b>You won</b> the brawl\n<i>You are the last one standing, the big spirit of the war is proud of you</i>"
+		case brawl:
+			text = "☠ <b>You lost</b> the brawl\n<i>I hope that the guardian spirit can assist you in the next battle</i>"
+		case winners == nil:
+			text = "⚖️ <b>The team duel is a draw</b>\n<i>Nobody of both teams is still standing</i>"
+		case containsID(winners, member.UserID):
+			text = "🥇 <b>Your team won</b> the team duel\n<i>The big spirit of the war is proud of all of you</i>"
+		default:
+			text = "☠ <b>Your team lost</b> the team duel\n<i>I hope that the guardian spirit can assist you in the next battle</i>"
+		}
+
+		res, _ := b.SendMessage(text, member.UserID, &echotron.MessageOptions{ParseMode: echotron.HTML})
+		if res.Result != nil {
+			b.EditMessageReplyMarkup(echotron.NewMessageID(member.UserID, res.Result.ID), kbd)
+		}
+	}
 }
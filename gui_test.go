@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+// Engage a team duel on the registry of the bot with the given names, it's ended when the test is over
+func engageTeamTest(t *testing.T, teams [][]int64, names map[int64]string, settings DuelSettings) {
+	var err error
+
+	if settings.Brawl {
+		_, err = duels.EngageBrawl(teams[0], names, settings)
+	} else {
+		_, err = duels.EngageTeamDuel(teams, names, settings)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { duels.EndDuel(teams[0][0]) })
+}
+
+func TestSpectatorView(t *testing.T) {
+	var (
+		b     = &bot{-100, echotron.NewAPI(TOKEN)}
+		names = map[int64]string{5001: "Anna", 5002: "Bruno", 5003: "Carla"}
+	)
+
+	engageTeamTest(t, [][]int64{{5001, 5002}, {5003}}, names, DuelSettings{})
+	duels.RetirePlayer(5002, EventFlee)
+
+	view := b.genSpectatorView(5003, "<i>footer</i>")
+	for _, want := range []string{"Live team duel", "Anna", "Bruno</a></b>: ☠ out of the duel", "Carla", genInfoBar(5001), genInfoBar(5003)} {
+		if !strings.Contains(view, want) {
+			t.Errorf("%q is missing from the view:\n%s", want, view)
+		}
+	}
+	if !strings.HasSuffix(view, "\n\n<i>footer</i>") {
+		t.Errorf("the view doesn't end with the footer:\n%s", view)
+	}
+
+	// Nobody is watching from the point of view of a player
+	if other := b.genSpectatorView(5001, "<i>footer</i>"); other != view {
+		t.Errorf("the view of another player is\n%s", other)
+	}
+}
+
+func TestBrawlView(t *testing.T) {
+	var (
+		b     = &bot{-100, echotron.NewAPI(TOKEN)}
+		names = map[int64]string{5011: "Anna", 5012: "Bruno", 5013: "Carla"}
+	)
+
+	engageTeamTest(t, [][]int64{{5011, 5012, 5013}}, names, DuelSettings{Brawl: true})
+
+	// Everyone is listed under the same header
+	view := b.genSpectatorView(5011, "")
+	if !strings.HasPrefix(view, "👀 <b>Live brawl</b>\n\n<b>🥊 Brawl</b>") || strings.Count(view, "\n👤") != 3 {
+		t.Errorf("the view of the brawl is:\n%s", view)
+	}
+	for userID, name := range names {
+		if !strings.Contains(view, name+"</a></b>: "+genInfoBar(userID)) {
+			t.Errorf("%s is missing from the view:\n%s", name, view)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+const historyPageSize = 5 // number of duels shown in every page of the history
+
+// Formats in which the history of a duel can be exported
+var historyFormats = []string{"json", "txt", "html"}
+
+// Whole history of a duel, built from its log
+type HistoryDocument struct {
+	DuelID   string          `json:"duel_id"`
+	Ranked   bool            `json:"ranked"`
+	Started  time.Time       `json:"started"`
+	Ended    *time.Time      `json:"ended,omitempty"` // nil if the duel is still going
+	Players  []HistoryPlayer `json:"players"`
+	Teams    []int           `json:"teams,omitempty"` // team of every player (nil if not a team duel)
+	Clashes  []HistoryClash  `json:"clashes"`
+	Result   string          `json:"result"`              // "win", "draw", "flee", "timeout", "abandoned" or "ongoing"
+	WinnerID *int64          `json:"winner_id,omitempty"` // nil if draw or ongoing
+}
+
+// A player of the duel
+type HistoryPlayer struct {
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// Stats of a clash taken from its BattleReport
+type HistoryClash struct {
+	Number  int                  `json:"number"`
+	Time    time.Time            `json:"time"`
+	Players []HistoryClashPlayer `json:"players"`
+}
+
+// What a player did during a clash and his stats after it
+type HistoryClashPlayer struct {
+	UserID     int64  `json:"user_id"`
+	Performed  string `json:"performed"`
+	Success    bool   `json:"success"`
+	LifeOff    int    `json:"life_offset"`
+	StaminaOff int    `json:"stamina_offset"`
+	GainEffect string `json:"gain_effect,omitempty"`
+	Life       int    `json:"life"`
+	Stamina    int    `json:"stamina"`
+}
+
+/* Get the log of the last duel of a player, the ongoing one if he's fighting.
+ * nil if he never played
+ */
+func lastDuelLog(userID int64) (*DuelLog, error) {
+	if record, err := duels.GetDuelLog(userID); err == nil {
+		return &record, nil
+	}
+
+	IDs, err := STORE.LoadPlayedDuels(userID)
+	if err != nil || len(IDs) == 0 {
+		return nil, err
+	}
+	return STORE.LoadDuelLog(IDs[len(IDs)-1])
+}
+
+// Build the history document of a duel from its log
+func (b *bot) genHistoryDocument(record DuelLog) (doc HistoryDocument) {
+	doc = HistoryDocument{
+		DuelID:  record.ID,
+		Ranked:  record.Ranked,
+		Started: record.Started,
+		Teams:   record.Teams,
+		Result:  "ongoing",
+	}
+	if !record.Ended.IsZero() {
+		doc.Ended = &record.Ended
+	}
+
+	for _, userID := range record.Participants {
+		doc.Players = append(doc.Players, HistoryPlayer{UserID: userID, Name: b.knownName(record.Names, userID)})
+	}
+
+	for _, event := range record.Events {
+		if event.Kind == EventClash && event.Report != nil {
+			clash := HistoryClash{Number: len(doc.Clashes) + 1, Time: event.Time}
+			for i, info := range event.Report.PlayersInfo {
+				current := HistoryClashPlayer{
+					UserID:     info.UserID,
+					Performed:  info.Performed,
+					Success:    info.Success,
+					LifeOff:    info.LifeOff,
+					StaminaOff: info.StaminaOff,
+				}
+				if info.GainEffect != nil {
+					current.GainEffect = *info.GainEffect
+				}
+				// The creatures are the ones before the clash, in the same order of the clash
+				j := i
+				if event.Clash != nil {
+					j = indexOfID(event.Clash.Order, info.UserID)
+				}
+				if j >= 0 && j < len(event.Creatures) {
+					life, stamina, _, _ := event.Creatures[j].GetInfo()
+					current.Life, current.Stamina = life+info.LifeOff, int(stamina)+info.StaminaOff
+				}
+				clash.Players = append(clash.Players, current)
+			}
+			doc.Clashes = append(doc.Clashes, clash)
+		}
+	}
+
+	switch winnerID, fleeingID, over := record.Outcome(); true {
+	case record.Teams != nil && over && winnerID == nil:
+		doc.Result = "draw"
+	case record.Teams != nil && over:
+		doc.Result, doc.WinnerID = "win", winnerID
+	case record.Teams != nil:
+	case record.EndedBy(EventAbandon):
+		doc.Result = "abandoned"
+	case record.EndedBy(EventTimeout):
+		doc.Result, doc.WinnerID = "timeout", winnerID
+	case fleeingID != 0:
+		doc.Result, doc.WinnerID = "flee", winnerID
+	case over && winnerID == nil:
+		doc.Result = "draw"
+	case over:
+		doc.Result, doc.WinnerID = "win", winnerID
+	}
+	return
+}
+
+// Get the name of a player of the duel
+func (doc HistoryDocument) name(userID int64) string {
+	for _, player := range doc.Players {
+		if player.UserID == userID {
+			return player.Name
+		}
+	}
+	return "Unnamed User"
+}
+
+// Get who fought in the duel: the players one against the other or, in team duels, the teams
+func (doc HistoryDocument) versus() string {
+	var (
+		sides []string
+		seen  = make(map[int]bool)
+	)
+
+	if doc.Teams == nil {
+		for _, player := range doc.Players {
+			sides = append(sides, player.Name)
+		}
+		return strings.Join(sides, " vs ")
+	}
+
+	for _, team := range doc.Teams {
+		if seen[team] {
+			continue
+		}
+		seen[team] = true
+
+		var members []string
+		for j, player := range doc.Players {
+			if doc.Teams[j] == team {
+				members = append(members, player.Name)
+			}
+		}
+		sides = append(sides, strings.Join(members, " & "))
+	}
+	return strings.Join(sides, " vs ")
+}
+
+// Get the name of the player that didn't win the duel
+func (doc HistoryDocument) loserName() string {
+	for _, player := range doc.Players {
+		if doc.WinnerID == nil || player.UserID != *doc.WinnerID {
+			return player.Name
+		}
+	}
+	return "Unnamed User"
+}
+
+// Get the result of the duel in a readable format
+func (doc HistoryDocument) resultText() string {
+	switch doc.Result {
+	case "win":
+		return doc.name(*doc.WinnerID) + " won the duel"
+	case "draw":
+		return "The duel is a draw"
+	case "flee":
+		return doc.loserName() + " fled from the duel"
+	case "timeout":
+		return doc.loserName() + " lost for inactivity"
+	case "abandoned":
+		return "The duel was abandoned by both players"
+	}
+	return "The duel is still going"
+}
+
+// Encode the document in the given format (json, txt or html)
+func (doc HistoryDocument) Encode(format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(doc, "", "  ")
+	case "txt":
+		return []byte(doc.text()), nil
+	case "html":
+		return []byte(doc.html()), nil
+	}
+	return nil, errors.New("Invalid format")
+}
+
+// Get the document as plain text
+func (doc HistoryDocument) text() string {
+	var sb strings.Builder
+
+	fmt.Fprintln(&sb, "Duel", doc.DuelID, map[bool]string{true: "(ranked)", false: "(friendly)"}[doc.Ranked])
+	fmt.Fprintln(&sb, doc.versus())
+	fmt.Fprintln(&sb, "Started:", doc.Started.Format(time.RFC1123))
+	if doc.Ended != nil {
+		fmt.Fprintln(&sb, "Ended:", doc.Ended.Format(time.RFC1123))
+	}
+
+	for _, clash := range doc.Clashes {
+		fmt.Fprintf(&sb, "\nClash %d (%s)\n", clash.Number, clash.Time.Format("15:04:05"))
+		for _, current := range clash.Players {
+			fmt.Fprintf(&sb, "  %s: %s", doc.name(current.UserID), current.Performed)
+			if current.Success {
+				fmt.Fprint(&sb, " (success)")
+			}
+			fmt.Fprintf(&sb, " - health %d (%+d), stamina %d (%+d)", current.Life, current.LifeOff, current.Stamina, current.StaminaOff)
+			if current.GainEffect != "" {
+				fmt.Fprint(&sb, " - now ", current.GainEffect)
+			}
+			fmt.Fprintln(&sb)
+		}
+	}
+
+	fmt.Fprintln(&sb, "\n"+doc.resultText())
+	return sb.String()
+}
+
+// Get the document as a standalone HTML page
+func (doc HistoryDocument) html() string {
+	var (
+		sb    strings.Builder
+		title = html.EscapeString(fmt.Sprint("Duel ", doc.DuelID, ": ", doc.versus()))
+	)
+
+	fmt.Fprint(&sb, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>", title, "</title>\n",
+		"<style>body{font-family:sans-serif} table{border-collapse:collapse} td,th{border:1px solid #999;padding:4px 8px}</style>\n",
+		"</head>\n<body>\n<h1>", title, "</h1>\n<p>")
+	fmt.Fprint(&sb, map[bool]string{true: "Ranked", false: "Friendly"}[doc.Ranked], " duel started on ", doc.Started.Format(time.RFC1123))
+	if doc.Ended != nil {
+		fmt.Fprint(&sb, ", ended on ", doc.Ended.Format(time.RFC1123))
+	}
+	fmt.Fprint(&sb, "</p>\n<table>\n<tr><th>Clash</th><th>Time</th><th>Player</th><th>Action</th>",
+		"<th>Success</th><th>Health</th><th>Stamina</th><th>Effect</th></tr>\n")
+
+	for _, clash := range doc.Clashes {
+		for i, current := range clash.Players {
+			sb.WriteString("<tr>")
+			if i == 0 {
+				fmt.Fprintf(&sb, "<td rowspan=\"%d\">%d</td><td rowspan=\"%d\">%s</td>",
+					len(clash.Players), clash.Number, len(clash.Players), clash.Time.Format("15:04:05"))
+			}
+			fmt.Fprintf(&sb, "<td>%s</td><td>%s</td><td>%s</td><td>%d (%+d)</td><td>%d (%+d)</td><td>%s</td></tr>\n",
+				html.EscapeString(doc.name(current.UserID)), current.Performed,
+				map[bool]string{true: "✅", false: "❌"}[current.Success],
+				current.Life, current.LifeOff, current.Stamina, current.StaminaOff, current.GainEffect)
+		}
+	}
+
+	fmt.Fprint(&sb, "</table>\n<p><b>", html.EscapeString(doc.resultText()), "</b></p>\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// Send the history of a duel as a file in the given format (json, txt or html)
+func (b *bot) SendHistory(record DuelLog, format string) error {
+	doc := b.genHistoryDocument(record)
+	content, err := doc.Encode(format)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.SendDocument(
+		echotron.NewInputFileBytes(fmt.Sprint("duel_", doc.DuelID, ".", format), content),
+		b.chatID,
+		&echotron.DocumentOptions{
+			Caption:   fmt.Sprint("📜 History of the duel <code>", doc.DuelID, "</code>"),
+			ParseMode: echotron.HTML,
+		},
+	)
+	if err != nil || !res.Ok {
+		log.Println("SendHistory", "SendDocument", err)
+		return errors.New("Unable to send the file")
+	}
+	return nil
+}
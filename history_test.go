@@ -0,0 +1,145 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"DuelBot/pg"
+)
+
+func TestHistoryTitle(t *testing.T) {
+	var players = []HistoryPlayer{{1, "Anna"}, {2, "Bruno"}, {3, "Carla"}, {4, "Dario"}}
+
+	tests := []struct {
+		name    string
+		players []HistoryPlayer
+		teams   []int
+		want    string
+	}{
+		{"duel", players[:2], nil, "Anna vs Bruno"},
+		{"team duel", players, []int{0, 0, 1, 1}, "Anna & Bruno vs Carla & Dario"},
+		{"mixed teams", players, []int{0, 1, 0, 1}, "Anna & Carla vs Bruno & Dario"},
+		{"brawl", players[:3], []int{0, 1, 2}, "Anna vs Bruno vs Carla"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := HistoryDocument{DuelID: "abc123", Players: test.players, Teams: test.teams, Result: "ongoing"}
+			if got := doc.versus(); got != test.want {
+				t.Errorf("got %q instead of %q", got, test.want)
+			}
+			if page := doc.html(); !strings.Contains(page, "<title>Duel abc123: "+strings.ReplaceAll(test.want, "&", "&amp;")+"</title>") {
+				t.Errorf("the title of the page is wrong:\n%s", page)
+			}
+		})
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		name        string
+		page, total int
+		first, last int
+		ok          bool
+	}{
+		{"first page", 0, 12, 0, 5, true},
+		{"middle page", 1, 12, 5, 10, true},
+		{"last page", 2, 12, 10, 12, true},
+		{"full last page", 1, 10, 5, 10, true},
+		{"after the last", 2, 10, 0, 0, false},
+		{"negative", -1, 12, 0, 0, false},
+		{"empty", 0, 0, 0, 0, true},
+		{"nothing after empty", 1, 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, last, ok := pageBounds(test.page, historyPageSize, test.total)
+			if first != test.first || last != test.last || ok != test.ok {
+				t.Errorf("got %d, %d, %v instead of %d, %d, %v", first, last, ok, test.first, test.last, test.ok)
+			}
+		})
+	}
+}
+
+func TestDuelLogEnemies(t *testing.T) {
+	tests := []struct {
+		name   string
+		record DuelLog
+		userID int64
+		want   []int64
+	}{
+		{"duel", DuelLog{Participants: []int64{1, 2}}, 2, []int64{1}},
+		{"team duel", DuelLog{Participants: []int64{1, 2, 3, 4}, Teams: []int{0, 1, 0, 1}}, 3, []int64{2, 4}},
+		{"brawl", DuelLog{Participants: []int64{1, 2, 3}, Teams: []int{0, 1, 2}}, 2, []int64{1, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.record.Enemies(test.userID); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v instead of %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHistoryResult(t *testing.T) {
+	var (
+		winnerID = int64(1)
+		ended    = time.Now()
+		clash    = func(winnerID *int64) []DuelEvent {
+			return []DuelEvent{{Kind: EventStart}, {Kind: EventClash, Report: &BattleReport{EndDuel: true, WinnerID: winnerID}}}
+		}
+	)
+
+	tests := []struct {
+		name   string
+		record DuelLog
+		userID int64
+		want   string
+	}{
+		{"not ended", DuelLog{Participants: []int64{1, 2}, Events: []DuelEvent{{Kind: EventStart}}}, 1, "⏳ Not ended"},
+		{"won", DuelLog{Participants: []int64{1, 2}, Events: clash(&winnerID)}, 1, "🥇 Won"},
+		{"lost ranked", DuelLog{Participants: []int64{1, 2}, Events: clash(&winnerID), Changes: RatingChanges{1: 16, 2: -16}}, 2, "☠ Lost <code>-16</code>"},
+		{"draw", DuelLog{Participants: []int64{1, 2}, Events: clash(nil)}, 2, "⚖️ Draw"},
+		{"fled", DuelLog{Participants: []int64{1, 2}, Events: []DuelEvent{{Kind: EventFlee, UserID: 2}}}, 2, "🏳️ Fled"},
+		{"opponent fled", DuelLog{Participants: []int64{1, 2}, Events: []DuelEvent{{Kind: EventFlee, UserID: 2}}}, 1, "🥇 Won (opponent fled)"},
+		{"inactive", DuelLog{Participants: []int64{1, 2}, Events: []DuelEvent{{Kind: EventTimeout, UserID: 1}}}, 1, "⏰ Lost for inactivity"},
+		{"opponent inactive", DuelLog{Participants: []int64{1, 2}, Events: []DuelEvent{{Kind: EventTimeout, UserID: 1}}}, 2, "🥇 Won (opponent inactive)"},
+		{"abandoned", DuelLog{Participants: []int64{1, 2}, Events: []DuelEvent{{Kind: EventAbandon}}}, 1, "💤 Abandoned"},
+		{"team won", DuelLog{Participants: []int64{1, 2, 3}, Teams: []int{0, 1, 0}, Winners: []int64{1, 3}, Ended: ended}, 3, "🥇 Won"},
+		{"team lost", DuelLog{Participants: []int64{1, 2, 3}, Teams: []int{0, 1, 0}, Winners: []int64{1, 3}, Ended: ended}, 2, "☠ Lost"},
+		{"team draw", DuelLog{Participants: []int64{1, 2, 3}, Teams: []int{0, 1, 0}, Ended: ended}, 2, "⚖️ Draw"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := genHistoryResult(test.userID, test.record); got != test.want {
+				t.Errorf("got %q instead of %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHistoryReports(t *testing.T) {
+	var r = engageTest(t, 1, 2)
+
+	// The clashes of a past duel are the same reports the players got while fighting
+	var reports []BattleReport
+	d, _ := r.lockDuel(1)
+	d.players[1].stats.SetAction(pg.ATTACK)
+	reports = append(reports, d.clash(1))
+	d.players[2].stats.SetAction(pg.ATTACK)
+	reports = append(reports, d.clash(2))
+	d.Unlock()
+
+	record, err := r.GetDuelLog(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := record.Reports(); !reflect.DeepEqual(got, reports) {
+		t.Fatalf("the logged reports are %+v instead of %+v", got, reports)
+	}
+	current, enemy := reports[0].PlayersInfo[0], reports[0].PlayersInfo[1]
+	if text := genReportText(current, enemy); current.UserID != 1 || !strings.Contains(text, "You <b>Attack⚔️ successfully</b>") || !strings.HasSuffix(text, "🗡 5") {
+		t.Errorf("the report of the first clash is:\n%s", text)
+	}
+}
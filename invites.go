@@ -1,127 +1,498 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"math/rand"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Register user chatID -> inviteID
-var invites = make(map[int64][]rune, 0)
+// SECRET is the key used to sign the invite tokens.
+var SECRET []byte
 
-// Get an inviteID in []rune
-func getInviteID(userID int64) []rune {
-	return invites[userID]
+const (
+	inviteLifetime    = 24 * time.Hour      // how long an invite can be used after it's created (by default)
+	inviteMinLifetime = time.Minute         // shortest lifetime that can be choosen
+	inviteMaxLifetime = 30 * 24 * time.Hour // longest lifetime that can be choosen
+	inviteMACSize     = 8                   // bytes of the signature kept inside the token
+	inviteEpoch       = 1704067200          // 2024-01-01, the tokens count the minutes since then to expire
+	inviteMaxUses     = 999                 // highest usage limit that can be choosen
+	inviteMaxName     = 32                  // max length of the name of an invite
+)
+
+/* Invitation to a duel. Inviter, nonce, expiration, usage limit and settings are all encoded
+ * inside the token and signed so it can't be forged, but only the ones in the register can be used
+ */
+type Invite struct {
+	Token     string      `json:"token"`
+	InviterID int64       `json:"inviter_id"`
+	Nonce     uint32      `json:"nonce"`
+	Expires   time.Time   `json:"expires"`
+	MaxUses   int         `json:"max_uses"`       // how many duels can be started with it (0 if unlimited)
+	Uses      int         `json:"uses"`           // how many duels were started with it
+	Name      string      `json:"name,omitempty"` // choosen by the inviter to recognize it
+	Revoked   bool        `json:"revoked,omitempty"`
+	Posted    *MessageRef `json:"posted,omitempty"` // message of the open challenge, known after the first claim
+	DuelSettings
+}
+
+// Options choosen when creating an invite
+type InviteOptions struct {
+	Name     string
+	MaxUses  int           // 0 if unlimited
+	Lifetime time.Duration // 0 to use the default one
+	DuelSettings
 }
 
-// Set a inviteID of a user to a new value
-func setInviteID(userID int64, newInviteID []rune) {
-	invites[userID] = newInviteID
+var (
+	// Register user chatID -> not expired invites he created, used to list and revoke them
+	invitesRegister = make(map[int64][]Invite, 0)
+	invitesMu       sync.Mutex
+
+	// Tokens are sent inside links and commands so they can't contain '_' or ' '
+	tokenEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	errInvalidInvite = errors.New("Invalid invite token")
+	errUnknownInvite = errors.New("Invite is not in the register")
+	errExpiredInvite = errors.New("Invite is expired")
+	errRevokedInvite = errors.New("Invite was revoked")
+	errUsedInvite    = errors.New("Invite reached its usage limit")
+)
+
+// Append a signed number to a buffer using the varint encoding
+func appendVarint(buf []byte, x int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutVarint(tmp[:], x)]...)
 }
 
-// Increment the passed char (must be in range: 0-9, A-Y, a-y)
-func incrChar(char *rune) {
-	switch *char {
-	case '9':
-		*char = 'A'
-	case 'Z':
-		*char = 'a'
-	default:
-		*char++
+// Read a signed number encoded as varint from a buffer and return what is left of it
+func readVarint(buf []byte) (x int64, rest []byte, err error) {
+	x, n := binary.Varint(buf)
+	if n <= 0 {
+		return 0, nil, errInvalidInvite
 	}
+	return x, buf[n:], nil
 }
 
-// Return a copy of the inviteID with 3 random chars before and after
-func addRandChars(rawInviteID []rune) (inviteID []rune) {
-	var chunk [2][3]rune
+// Generate the signature of the content of a token
+func signInvite(body []byte) []byte {
+	mac := hmac.New(sha256.New, SECRET)
+	mac.Write(body)
+	return mac.Sum(nil)[:inviteMACSize]
+}
 
-	rand.Seed(time.Now().UnixNano())
-	for j := 0; j < 2; j++ {
-		for i := 0; i < 3; i++ {
-			switch rand.Intn(3) {
-			case 0:
-				chunk[j][i] = rune(rand.Intn(10) + 48)
-			case 1:
-				chunk[j][i] = rune(rand.Intn(26) + 65)
-			case 2:
-				chunk[j][i] = rune(rand.Intn(26) + 97)
-			}
+/* Generate the token of an invite. It must fit inside the start payload of a link even with
+ * the largest IDs (52 bits), so the expiration is in minutes and the flags share a number with the uses
+ */
+func (inv Invite) encode() string {
+	var (
+		body  []byte
+		nonce [4]byte
+		flags byte
+	)
+
+	if inv.Ranked {
+		flags |= 1
+	}
+	flags |= seriesCode(inv.BestOf) << 1
+	binary.BigEndian.PutUint32(nonce[:], inv.Nonce)
+
+	body = appendVarint(body, inv.InviterID)
+	body = append(body, nonce[:]...)
+	body = appendVarint(body, (inv.Expires.Unix()-inviteEpoch)/60)
+	body = appendVarint(body, int64(inv.MaxUses)<<3|int64(flags))
+	body = appendVarint(body, inv.GroupID)
+
+	return tokenEncoding.EncodeToString(append(body, signInvite(body)...))
+}
+
+// Get the invite inside a token checking the signature (but not if it's expired)
+func decodeInvite(token string) (inv Invite, err error) {
+	var expires, packed int64
+
+	raw, err := tokenEncoding.DecodeString(token)
+	if err != nil || len(raw) <= inviteMACSize {
+		return inv, errInvalidInvite
+	}
+	body, signature := raw[:len(raw)-inviteMACSize], raw[len(raw)-inviteMACSize:]
+	if !hmac.Equal(signature, signInvite(body)) {
+		return inv, errInvalidInvite
+	}
+
+	inv.Token = token
+	if inv.InviterID, body, err = readVarint(body); err != nil {
+		return
+	}
+	if len(body) < 4 {
+		return inv, errInvalidInvite
+	}
+	inv.Nonce, body = binary.BigEndian.Uint32(body[:4]), body[4:]
+	if expires, body, err = readVarint(body); err != nil {
+		return
+	}
+	inv.Expires = time.Unix(inviteEpoch+expires*60, 0)
+	if packed, body, err = readVarint(body); err != nil {
+		return
+	}
+	if packed < 0 {
+		return inv, errInvalidInvite
+	}
+	if code := packed >> 1 & 3; code != 0 {
+		inv.BestOf = seriesLengths[code]
+	}
+	inv.Ranked, inv.MaxUses = packed&1 != 0, int(packed>>3)
+	if inv.GroupID, body, err = readVarint(body); err != nil {
+		return
+	}
+	if len(body) != 0 {
+		return inv, errInvalidInvite
+	}
+
+	return
+}
+
+/* Get the invite inside a token, error if it's not valid, expired, revoked, used too many times
+ * or missing from the register (ex. lost store), since nobody knows anymore how many times it was used
+ */
+func ParseInvite(token string) (Invite, error) {
+	inv, err := decodeInvite(token)
+	if err != nil {
+		return inv, err
+	}
+	if time.Now().After(inv.Expires) {
+		return inv, errExpiredInvite
+	}
+
+	// The register knows if it was revoked and how many times it was used
+	registered, found := findInvite(inv.InviterID, inv.Nonce)
+	if !found {
+		return inv, errUnknownInvite
+	}
+	inv.Name, inv.Uses, inv.Revoked, inv.Posted = registered.Name, registered.Uses, registered.Revoked, registered.Posted
+	return inv, inv.usable()
+}
+
+// Check if an invite can still be used to start a duel (but not if it's expired)
+func (inv Invite) usable() error {
+	switch true {
+	case inv.Revoked:
+		return errRevokedInvite
+	case inv.MaxUses != 0 && inv.Uses >= inv.MaxUses:
+		return errUsedInvite
+	}
+	return nil
+}
+
+// Remove the expired invites from a list
+func pruneInvites(invites []Invite) (alive []Invite) {
+	var now = time.Now()
+
+	for _, inv := range invites {
+		if now.Before(inv.Expires) {
+			alive = append(alive, inv)
 		}
 	}
-	inviteID = append(inviteID, chunk[0][:]...)
-	inviteID = append(inviteID, rawInviteID...)
-	inviteID = append(inviteID, chunk[1][:]...)
 	return
 }
 
-// increment the passed inviteID
-func incrInviteID(inviteID []rune) {
-	var i = len(inviteID) - 1
+// Change the invites of a user and save them (invitesMu must be locked)
+func setInvites(userID int64, invites []Invite) {
+	invites = pruneInvites(invites)
+	if len(invites) == 0 {
+		delete(invitesRegister, userID)
+	} else {
+		invitesRegister[userID] = invites
+	}
+
+	if err := STORE.SaveInvites(userID, invites); err != nil {
+		log.Println("setInvites", "SaveInvites", err)
+	}
+}
 
-	for ; i >= 0; i-- {
-		if inviteID[i] != 'z' {
-			incrChar(&inviteID[i])
-			break
+// Get the outstanding invites of a user (not expired, not revoked and with uses left)
+func getInvites(userID int64) (outstanding []Invite) {
+	invitesMu.Lock()
+	defer invitesMu.Unlock()
+
+	for _, inv := range pruneInvites(invitesRegister[userID]) {
+		if inv.usable() == nil {
+			outstanding = append(outstanding, inv)
 		}
-		inviteID[i] = '0'
 	}
-	if i == 0 && inviteID[i] == '0' {
-		inviteID = append([]rune{'1'}, inviteID...)
+	return
+}
+
+// Get an invite of a user from the register using its nonce
+func findInvite(userID int64, nonce uint32) (Invite, bool) {
+	invitesMu.Lock()
+	defer invitesMu.Unlock()
+
+	for _, inv := range invitesRegister[userID] {
+		if inv.Nonce == nonce {
+			return inv, true
+		}
 	}
+	return Invite{}, false
 }
 
-// Generate and return a new inviteID as a string
-func NewInviteID(userID int64) string {
-	var inviteID = getInviteID(userID)
-	if inviteID == nil {
-		inviteID = []rune{'0'}
+/* Parse the options of a new invite from the words of a command: "friendly", "once" or "uses=N"
+ * (0 for unlimited), "expires=D" (ex. 30m, 12h or 7d), "bo3", "bo5" or "bo7" for a series
+ * and the other words are used as its name
+ */
+func parseInviteOptions(words []string) (opt InviteOptions, err error) {
+	var name []string
+
+	opt.Ranked = true
+	for _, word := range words {
+		switch lower := strings.ToLower(word); true {
+		case lower == "friendly":
+			opt.Ranked = false
+		case lower == "once":
+			opt.MaxUses = 1
+		case strings.HasPrefix(lower, "uses="):
+			if opt.MaxUses, err = strconv.Atoi(lower[5:]); err != nil || opt.MaxUses < 0 || opt.MaxUses > inviteMaxUses {
+				return opt, fmt.Errorf("The number of uses must be between 0 (unlimited) and %d", inviteMaxUses)
+			}
+		case strings.HasPrefix(lower, "expires="):
+			if opt.Lifetime, err = parseLifetime(lower[8:]); err != nil {
+				return
+			}
+		case isBestOfFlag(lower):
+			if opt.BestOf, err = parseBestOf(lower[2:]); err != nil {
+				return
+			}
+		default:
+			name = append(name, word)
+		}
+	}
+
+	opt.Name = strings.Join(name, " ")
+	if len([]rune(opt.Name)) > inviteMaxName {
+		return opt, fmt.Errorf("The name of the invite can't be longer than %d characters", inviteMaxName)
+	}
+	return
+}
+
+// Check if a word is the length of a series (ex. bo3), the others can be part of the name
+func isBestOfFlag(word string) bool {
+	return len(word) > 2 && strings.HasPrefix(word, "bo") && strings.Trim(word[2:], "0123456789") == ""
+}
+
+// Parse the number of rounds of a series (3, 5 or 7), 1 means a single duel
+func parseBestOf(raw string) (bestOf int, err error) {
+	if bestOf, err = strconv.Atoi(raw); err != nil || !isSeriesLength(bestOf) {
+		return 0, errors.New("A series can be only best of 3, 5 or 7 (ex. bo3)")
+	}
+	if bestOf == 1 {
+		bestOf = 0
+	}
+	return
+}
+
+// Parse the lifetime of an invite, like a time.Duration but it accept also days (ex. 7d)
+func parseLifetime(raw string) (lifetime time.Duration, err error) {
+	if strings.HasSuffix(raw, "d") {
+		var days int
+		if days, err = strconv.Atoi(raw[:len(raw)-1]); err == nil {
+			lifetime = time.Duration(days) * 24 * time.Hour
+		}
 	} else {
-		inviteID = inviteID[3 : len(inviteID)-3]
+		lifetime, err = time.ParseDuration(raw)
 	}
 
-	incrInviteID(inviteID)
-	inviteID = addRandChars(inviteID)
-	setInviteID(userID, inviteID)
-	return string(inviteID)
+	if err != nil || lifetime < inviteMinLifetime || lifetime > inviteMaxLifetime {
+		return 0, fmt.Errorf("The expiration must be between %s and %s (ex. 30m, 12h or 7d)",
+			formatDuration(inviteMinLifetime), formatDuration(inviteMaxLifetime))
+	}
+	return
 }
 
-// Check the validity of an inviteID of a user
-func isValidInviteID(userID int64, inviteID string) bool {
-	return string(getInviteID(userID)) == inviteID
+// Generate the words that parsed will give back the same options (name excluded)
+func (opt InviteOptions) flags() (words []string) {
+	if !opt.Ranked {
+		words = append(words, "friendly")
+	}
+	if opt.MaxUses != 0 {
+		words = append(words, fmt.Sprint("uses=", opt.MaxUses))
+	}
+	if opt.Lifetime != 0 {
+		words = append(words, fmt.Sprint("expires=", int(opt.Lifetime.Minutes()), "m"))
+	}
+	if opt.BestOf > 1 {
+		words = append(words, fmt.Sprint("bo", opt.BestOf))
+	}
+	return
 }
 
-// Check the validity of a Invitation, errorMessage == "" if is all okay
-func (b *bot) IsInvitionValid(chatID int64, inviteID string) (errorMessage string) {
-	var botID int64
-	if res, err := b.GetMe(); err != nil {
-		botID = res.Result.ID
+// Create a new signed invite of a user with the given options
+func NewInvite(userID int64, opt InviteOptions) Invite {
+	var nonce [4]byte
+
+	if opt.Lifetime == 0 {
+		opt.Lifetime = inviteLifetime
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		log.Println("NewInvite", "Read", err)
+	}
+	inv := Invite{
+		InviterID:    userID,
+		Nonce:        binary.BigEndian.Uint32(nonce[:]),
+		Expires:      time.Now().Add(opt.Lifetime + time.Minute - 1).Truncate(time.Minute), // the token has only the minutes
+		MaxUses:      opt.MaxUses,
+		Name:         opt.Name,
+		DuelSettings: opt.DuelSettings,
 	}
+	inv.Token = inv.encode()
 
-	switch true {
-	case b.chatID == chatID:
-		errorMessage = "This link is not for you. Send it to who you want to duel"
+	invitesMu.Lock()
+	setInvites(userID, append(invitesRegister[userID], inv))
+	invitesMu.Unlock()
+
+	return inv
+}
+
+/* Get an outstanding invite of a user with the same options that will last at least half of
+ * its lifetime, or create a new one. Used where a new invite would be created too often (inline mode)
+ */
+func ReuseInvite(userID int64, opt InviteOptions) Invite {
+	var outstanding = getInvites(userID)
+
+	if opt.Lifetime == 0 {
+		opt.Lifetime = inviteLifetime
+	}
+	for i := len(outstanding) - 1; i >= 0; i-- {
+		inv := outstanding[i]
+		if inv.DuelSettings == opt.DuelSettings && inv.Name == opt.Name && inv.MaxUses == opt.MaxUses &&
+			time.Until(inv.Expires) > opt.Lifetime/2 {
+			return inv
+		}
+	}
+	return NewInvite(userID, opt)
+}
+
+/* Get the invites of the inviter and the index of the given one, found is false if it's missing
+ * from the register. It's never added back, a single use token would be usable again (invitesMu must be locked)
+ */
+func registerInvite(inv Invite) (invites []Invite, i int, found bool) {
+	invites = invitesRegister[inv.InviterID]
+	for i, registered := range invites {
+		if registered.Nonce == inv.Nonce {
+			return invites, i, true
+		}
+	}
+	return invites, -1, false
+}
+
+// Count a new duel started using an invite, error if it can't be used anymore or it's not in the register
+func UseInvite(inv Invite) error {
+	invitesMu.Lock()
+	defer invitesMu.Unlock()
+
+	invites, i, found := registerInvite(inv)
+	if !found {
+		return errUnknownInvite
+	}
+	if err := invites[i].usable(); err != nil {
+		return err
+	}
+	invites[i].Uses++
+	setInvites(inv.InviterID, invites)
+	return nil
+}
 
-	case botID == chatID:
-		errorMessage = "Thanks for the invitation but I'm quite busy now. Maybe another time"
+// Remember where an invite was posted as open challenge
+func SetInvitePosted(inv Invite, posted MessageRef) {
+	invitesMu.Lock()
+	defer invitesMu.Unlock()
+
+	invites, i, found := registerInvite(inv)
+	if !found {
+		return
+	}
+	invites[i].Posted = &posted
+	setInvites(inv.InviterID, invites)
+}
+
+// Revoke an invite of a user using its nonce, false if it's not outstanding
+func RevokeInvite(userID int64, nonce uint32) bool {
+	invitesMu.Lock()
+	defer invitesMu.Unlock()
+
+	invites := invitesRegister[userID]
+	for i, inv := range invites {
+		if inv.Nonce == nonce && !inv.Revoked {
+			invites[i].Revoked = true
+			setInvites(userID, invites)
+			return true
+		}
+	}
+	return false
+}
 
-	case !isValidInviteID(chatID, inviteID):
+// Revoke all the outstanding invites of a user and return how many they were
+func RevokeInvites(userID int64) (revoked int) {
+	invitesMu.Lock()
+	defer invitesMu.Unlock()
+
+	invites := invitesRegister[userID]
+	for i := range invites {
+		if invites[i].usable() == nil {
+			invites[i].Revoked = true
+			revoked++
+		}
+	}
+	setInvites(userID, invites)
+	return
+}
+
+// Check the validity of an invite token, errorMessage == "" if is all okay
+func (b *bot) IsInvitionValid(token string) (inv Invite, errorMessage string) {
+	inv, err := ParseInvite(token)
+
+	switch true {
+	case err == errExpiredInvite:
 		errorMessage = "This link is expired"
 
-	case IsPlayerBusy(chatID):
-		errorMessage = "Your opponent might be already engaged in another fight. Brawls are still not allowed"
+	case err == errRevokedInvite:
+		errorMessage = "This link was canceled by who created it"
 
-	case IsPlayerBusy(b.chatID):
-		errorMessage = "You are already engaged in another fight. Brawls are still not allowed"
+	case err == errUsedInvite:
+		errorMessage = "This link was already used too many times"
+
+	case err != nil:
+		errorMessage = "This link is not valid"
+
+	case b.chatID == inv.InviterID:
+		errorMessage = "This link is not for you. Send it to who you want to duel"
+
+	case duels.IsPlayerBusy(inv.InviterID):
+		errorMessage = "Your opponent might be already engaged in another fight. To fight more players at once open a /brawl in a group"
+
+	case duels.IsPlayerBusy(b.chatID):
+		errorMessage = "You are already engaged in another fight. To fight more players at once open a /brawl in a group"
 	}
 
 	return
 }
 
-// Generate a new invitiation link
-func (b *bot) GenInvitationLink() string {
+// Generate the invitiation link of an invite
+func (b *bot) GenInvitationLink(inv Invite) string {
+	return b.genStartLink("joinDuel_" + inv.Token)
+}
+
+// Generate the link that open the private chat with the bot sending /start with the payload
+func (b *bot) genStartLink(payload string) string {
 	var botUser string
-	if res, err := b.GetMe(); err == nil {
+	if res, err := b.GetMe(); err == nil && res.Result != nil {
 		botUser = res.Result.Username
 	}
-	return fmt.Sprint("https://t.me/", botUser, "?start=joinDuel_", b.chatID, "_", NewInviteID(b.chatID))
+	return fmt.Sprint("https://t.me/", botUser, "?start=", payload)
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInviteCodec(t *testing.T) {
+	var expires = time.Now().Add(inviteLifetime).Truncate(time.Minute)
+
+	tests := []struct {
+		name string
+		inv  Invite
+	}{
+		{"friendly", Invite{InviterID: 1, Nonce: 1, Expires: expires}},
+		{"ranked once", Invite{InviterID: 123456789, Nonce: 42, Expires: expires, MaxUses: 1, DuelSettings: DuelSettings{Ranked: true}}},
+		{"series", Invite{InviterID: 987654321, Nonce: 1 << 31, Expires: expires, MaxUses: inviteMaxUses, DuelSettings: DuelSettings{BestOf: 7}}},
+		{"in a group", Invite{InviterID: 5, Nonce: ^uint32(0), Expires: expires, DuelSettings: DuelSettings{Ranked: true, BestOf: 3, GroupID: -1001234567890}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := test.inv.encode()
+			inv, err := decodeInvite(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch true {
+			case inv.Token != token, inv.InviterID != test.inv.InviterID, inv.Nonce != test.inv.Nonce:
+				t.Errorf("decoded %+v instead of %+v", inv, test.inv)
+			case !inv.Expires.Equal(test.inv.Expires), inv.MaxUses != test.inv.MaxUses, inv.DuelSettings != test.inv.DuelSettings:
+				t.Errorf("decoded %+v instead of %+v", inv, test.inv)
+			}
+		})
+	}
+}
+
+func TestInviteSignature(t *testing.T) {
+	var (
+		inv   = Invite{InviterID: 1, Nonce: 7, Expires: time.Now().Add(time.Hour), MaxUses: 1}
+		token = inv.encode()
+	)
+
+	// Another secret makes another signature
+	secret := SECRET
+	SECRET = []byte("another secret")
+	forged := inv.encode()
+	SECRET = secret
+
+	// Change the inviter keeping the signature of the original token
+	inv.InviterID = 2
+	raw, _ := tokenEncoding.DecodeString(token)
+	other, _ := tokenEncoding.DecodeString(inv.encode())
+	swapped := tokenEncoding.EncodeToString(append(other[:len(other)-inviteMACSize], raw[len(raw)-inviteMACSize:]...))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base32", "not a token!"},
+		{"truncated", token[:len(token)-2]},
+		{"only the signature", tokenEncoding.EncodeToString(raw[len(raw)-inviteMACSize:])},
+		{"other secret", forged},
+		{"other body", swapped},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeInvite(test.token); err != errInvalidInvite {
+				t.Errorf("got error %v instead of %v", err, errInvalidInvite)
+			}
+		})
+	}
+
+	if _, err := decodeInvite(token); err != nil {
+		t.Error("the original token is not valid anymore", err)
+	}
+}
+
+func TestInviteExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		expires time.Time
+		want    error
+	}{
+		{"expired", time.Now().Add(-time.Hour), errExpiredInvite},
+		{"just expired", time.Now().Add(-time.Second), errExpiredInvite},
+		{"not registered", time.Now().Add(time.Hour), errUnknownInvite},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inv := Invite{InviterID: 1, Nonce: uint32(100 + i), Expires: test.expires}
+			if _, err := ParseInvite(inv.encode()); err != test.want {
+				t.Errorf("got error %v instead of %v", err, test.want)
+			}
+		})
+	}
+
+	// Even the shortest lifetime lasts until the minute after
+	inv := NewInvite(2, InviteOptions{Lifetime: inviteMinLifetime})
+	if parsed, err := ParseInvite(inv.Token); err != nil || time.Until(parsed.Expires) < inviteMinLifetime-time.Second {
+		t.Errorf("a new invite expires at %v (error %v)", parsed.Expires, err)
+	}
+}
+
+func TestUseInvite(t *testing.T) {
+	const inviterID = 3
+
+	once := NewInvite(inviterID, InviteOptions{MaxUses: 1})
+	if err := UseInvite(once); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseInvite(once.Token); err != errUsedInvite {
+		t.Errorf("a single use invite used once gives %v instead of %v", err, errUsedInvite)
+	}
+	if err := UseInvite(once); err != errUsedInvite {
+		t.Errorf("a single use invite was used twice (error %v)", err)
+	}
+
+	// Signed by the bot but lost by the register (ex. lost store), nobody knows how many times it was used
+	lost := Invite{InviterID: inviterID, Nonce: once.Nonce + 1, Expires: once.Expires, MaxUses: 1}
+	lost.Token = lost.encode()
+	if err := UseInvite(lost); err != errUnknownInvite {
+		t.Errorf("an invite missing from the register gives %v instead of %v", err, errUnknownInvite)
+	}
+	if _, err := ParseInvite(lost.Token); err != errUnknownInvite {
+		t.Errorf("an invite missing from the register is parsed with error %v", err)
+	}
+
+	if !RevokeInvite(inviterID, once.Nonce) {
+		t.Error("the single use invite could not be revoked")
+	}
+	if _, err := ParseInvite(once.Token); err != errRevokedInvite {
+		t.Errorf("a revoked invite gives %v instead of %v", err, errRevokedInvite)
+	}
+}
+
+func TestInviteLength(t *testing.T) {
+	const (
+		maxID      = 1<<52 - 1 // Telegram IDs have at most 52 significant bits
+		maxPayload = 64        // max length of the start payload of a link
+	)
+
+	tests := []struct {
+		name string
+		inv  Invite
+	}{
+		{"small", Invite{InviterID: 1, Expires: time.Now()}},
+		{"largest", Invite{
+			InviterID:    maxID,
+			Nonce:        ^uint32(0),
+			Expires:      time.Now().Add(inviteMaxLifetime),
+			MaxUses:      inviteMaxUses,
+			DuelSettings: DuelSettings{Ranked: true, BestOf: 7, GroupID: -maxID},
+		}},
+		{"far future", Invite{InviterID: maxID, Nonce: ^uint32(0), Expires: time.Unix(inviteEpoch, 0).AddDate(200, 0, 0), MaxUses: inviteMaxUses, DuelSettings: DuelSettings{GroupID: -maxID}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := test.inv.encode()
+			if payload := "joinDuel_" + token; len(payload) > maxPayload {
+				t.Errorf("start payload is %d characters long: %s", len(payload), payload)
+			}
+			if _, err := decodeInvite(token); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNamedInvites(t *testing.T) {
+	const inviterID = 4
+
+	t.Cleanup(func() { RevokeInvites(inviterID) })
+
+	// Every invite has its own name, uses and expiration, a new one doesn't replace the others
+	group := NewInvite(inviterID, InviteOptions{Name: "group link"})
+	marco := NewInvite(inviterID, InviteOptions{Name: "for Marco", MaxUses: 1, Lifetime: time.Hour})
+	three := NewInvite(inviterID, InviteOptions{Name: "three duels", MaxUses: 3, Lifetime: 7 * 24 * time.Hour})
+
+	for _, inv := range []Invite{group, marco, three} {
+		parsed, err := ParseInvite(inv.Token)
+		switch true {
+		case err != nil:
+			t.Errorf("the invite %q is not valid: %v", inv.Name, err)
+		case parsed.Name != inv.Name, parsed.MaxUses != inv.MaxUses, !parsed.Expires.Equal(inv.Expires):
+			t.Errorf("parsed %+v instead of %+v", parsed, inv)
+		}
+	}
+	if !group.Expires.After(marco.Expires) || !three.Expires.After(group.Expires) {
+		t.Errorf("the invites expire at %v, %v and %v", group.Expires, marco.Expires, three.Expires)
+	}
+
+	// Used up and revoked invites are not listed anymore, the others are still usable
+	UseInvite(marco)
+	UseInvite(three)
+	RevokeInvite(inviterID, group.Nonce)
+	if outstanding := getInvites(inviterID); len(outstanding) != 1 || outstanding[0].Nonce != three.Nonce || outstanding[0].Uses != 1 {
+		t.Errorf("the outstanding invites are %+v", outstanding)
+	}
+	if err := UseInvite(three); err != nil {
+		t.Errorf("the second use of %q gives %v", three.Name, err)
+	}
+	if RevokeInvites(inviterID) != 1 || getInvites(inviterID) != nil {
+		t.Error("the last outstanding invite was not revoked")
+	}
+}
+
+func TestReuseInvite(t *testing.T) {
+	const inviterID = 5
+
+	t.Cleanup(func() { RevokeInvites(inviterID) })
+
+	inline := ReuseInvite(inviterID, InviteOptions{})
+	if inv := ReuseInvite(inviterID, InviteOptions{}); inv.Nonce != inline.Nonce {
+		t.Error("a new invite was created with the same options")
+	}
+	if inv := ReuseInvite(inviterID, InviteOptions{DuelSettings: DuelSettings{Ranked: true}}); inv.Nonce == inline.Nonce {
+		t.Error("the same invite was reused with other settings")
+	}
+	if inv := ReuseInvite(inviterID, InviteOptions{Name: "for Marco"}); inv.Nonce == inline.Nonce {
+		t.Error("the same invite was reused with another name")
+	}
+	if len(getInvites(inviterID)) != 3 {
+		t.Errorf("the user has %d invites instead of 3", len(getInvites(inviterID)))
+	}
+}
+
+func TestParseInviteOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		words string
+		want  InviteOptions
+		fails bool
+	}{
+		{"default", "", InviteOptions{DuelSettings: DuelSettings{Ranked: true}}, false},
+		{"named", "for Marco", InviteOptions{Name: "for Marco", DuelSettings: DuelSettings{Ranked: true}}, false},
+		{"single use", "once friendly", InviteOptions{MaxUses: 1}, false},
+		{"uses and days", "group link uses=10 expires=7d", InviteOptions{Name: "group link", MaxUses: 10, Lifetime: 7 * 24 * time.Hour, DuelSettings: DuelSettings{Ranked: true}}, false},
+		{"series", "BO5 expires=30m", InviteOptions{Lifetime: 30 * time.Minute, DuelSettings: DuelSettings{Ranked: true, BestOf: 5}}, false},
+		{"too many uses", "uses=1000", InviteOptions{}, true},
+		{"negative uses", "uses=-1", InviteOptions{}, true},
+		{"too short", "expires=10s", InviteOptions{}, true},
+		{"too long", "expires=31d", InviteOptions{}, true},
+		{"even series", "bo4", InviteOptions{}, true},
+		{"long name", "a name longer than thirty two characters", InviteOptions{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opt, err := parseInviteOptions(strings.Fields(test.words))
+			switch true {
+			case test.fails && err == nil:
+				t.Errorf("parsed %+v without errors", opt)
+			case !test.fails && err != nil:
+				t.Fatal(err)
+			case !test.fails && opt != test.want:
+				t.Errorf("parsed %+v instead of %+v", opt, test.want)
+			}
+
+			// The flags of the options give back the same options
+			if !test.fails {
+				again, _ := parseInviteOptions(append(opt.flags(), strings.Fields(opt.Name)...))
+				if again != opt {
+					t.Errorf("the flags %v give %+v", opt.flags(), again)
+				}
+			}
+		})
+	}
+}
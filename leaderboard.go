@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// Number of duelists displayed in a page of the leaderboard
+const leaderboardPageSize = 10
+
+// Results of a player in the duels started from invites posted in a group
+type GroupRecord struct {
+	UserID int64 `json:"user_id"`
+	Wins   int   `json:"wins"`
+	Losses int   `json:"losses"`
+	Draws  int   `json:"draws"`
+}
+
+// Used to avoid concurrent updates of the records of the same group
+var groupsMu sync.Mutex
+
+// Global leaderboard kept sorted in memory, loaded from the store only the first time it's needed
+var (
+	globalRanking []Profile
+	rankingLoaded bool
+	rankingMu     sync.Mutex
+)
+
+// Add the result of a duel started from a group to its records (winnerID == nil if draw)
+func recordGroupResult(groupID int64, winnerID *int64, firstID, secondID int64) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	records, err := STORE.LoadGroupRecords(groupID)
+	if err != nil {
+		log.Println("recordGroupResult", "LoadGroupRecords", err)
+		return
+	}
+
+	for _, userID := range [2]int64{firstID, secondID} {
+		i := findGroupRecord(records, userID)
+		if i == -1 {
+			records = append(records, GroupRecord{UserID: userID})
+			i = len(records) - 1
+		}
+
+		switch true {
+		case winnerID == nil:
+			records[i].Draws++
+		case *winnerID == userID:
+			records[i].Wins++
+		default:
+			records[i].Losses++
+		}
+	}
+
+	if err = STORE.SaveGroupRecords(groupID, records); err != nil {
+		log.Println("recordGroupResult", "SaveGroupRecords", err)
+	}
+}
+
+// Get the index of the record of a player (-1 if missing)
+func findGroupRecord(records []GroupRecord, userID int64) int {
+	for i, record := range records {
+		if record.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+/* Get all the players that played at least a ranked duel, sorted by rating (then by more wins
+ * and less losses). The profiles are read from the store only the first time
+ */
+func GlobalLeaderboard() (ranking []Profile) {
+	rankingMu.Lock()
+	defer rankingMu.Unlock()
+
+	if !rankingLoaded {
+		profiles, err := STORE.LoadProfiles()
+		if err != nil {
+			log.Println("GlobalLeaderboard", "LoadProfiles", err)
+			return nil
+		}
+		globalRanking = nil
+		for _, profile := range profiles {
+			if profile.Ranked > 0 {
+				globalRanking = append(globalRanking, profile)
+			}
+		}
+		sortRanking(globalRanking)
+		rankingLoaded = true
+	}
+	return append(ranking, globalRanking...)
+}
+
+// Put the saved profile of a player in the global leaderboard, if it's already loaded
+func updateLeaderboard(profile Profile) {
+	rankingMu.Lock()
+	defer rankingMu.Unlock()
+
+	if !rankingLoaded || profile.Ranked == 0 {
+		return
+	}
+	for i, ranked := range globalRanking {
+		if ranked.UserID != profile.UserID {
+			continue
+		}
+		globalRanking[i] = profile
+		// Most of the updates are just statistics of the clashes, the order is the same
+		if ranked.Rating != profile.Rating || ranked.Wins != profile.Wins || ranked.Losses != profile.Losses {
+			sortRanking(globalRanking)
+		}
+		return
+	}
+	globalRanking = append(globalRanking, profile)
+	sortRanking(globalRanking)
+}
+
+// Sort the profiles by rating, then by more wins and then by less losses
+func sortRanking(ranking []Profile) {
+	sort.SliceStable(ranking, func(i, j int) bool {
+		switch true {
+		case ranking[i].Rating != ranking[j].Rating:
+			return ranking[i].Rating > ranking[j].Rating
+		case ranking[i].Wins != ranking[j].Wins:
+			return ranking[i].Wins > ranking[j].Wins
+		}
+		return ranking[i].Losses < ranking[j].Losses
+	})
+}
+
+// Get the players of the duels started from a group, sorted by wins (and then by less losses)
+func GroupLeaderboard(groupID int64) (ranking []GroupRecord) {
+	ranking, err := STORE.LoadGroupRecords(groupID)
+	if err != nil {
+		log.Println("GroupLeaderboard", "LoadGroupRecords", err)
+		return nil
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].Wins != ranking[j].Wins {
+			return ranking[i].Wins > ranking[j].Wins
+		}
+		return ranking[i].Losses < ranking[j].Losses
+	})
+	return
+}
+
+// Get the position of a player in the global leaderboard (0 if not present)
+func GlobalPosition(userID int64) int {
+	for i, profile := range GlobalLeaderboard() {
+		if profile.UserID == userID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Get the first and last index of the elements of a page (last excluded), false if page does not exist
+func pageBounds(page, size, total int) (first, last int, ok bool) {
+	first = page * size
+	if page < 0 || (first >= total && page != 0) {
+		return 0, 0, false
+	}
+
+	last = first + size
+	if last > total {
+		last = total
+	}
+	return first, last, true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Store that counts how many times all the profiles are read
+type countingStore struct {
+	Store
+	loads int
+}
+
+func (s *countingStore) LoadProfiles() ([]Profile, error) {
+	s.loads++
+	return s.Store.LoadProfiles()
+}
+
+func TestSortRanking(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []Profile
+		want     []int64
+	}{
+		{
+			"rating",
+			[]Profile{{UserID: 1, Rating: 1200, Wins: 9}, {UserID: 2, Rating: 1300}, {UserID: 3, Rating: 1250, Losses: 9}},
+			[]int64{2, 3, 1},
+		},
+		{
+			"then wins",
+			[]Profile{{UserID: 1, Rating: 1200, Wins: 1}, {UserID: 2, Rating: 1200, Wins: 3, Losses: 5}, {UserID: 3, Rating: 1200, Wins: 2}},
+			[]int64{2, 3, 1},
+		},
+		{
+			"then losses",
+			[]Profile{{UserID: 1, Rating: 1200, Wins: 2, Losses: 4}, {UserID: 2, Rating: 1200, Wins: 2, Losses: 1}, {UserID: 3, Rating: 1200, Wins: 2, Losses: 2}},
+			[]int64{2, 3, 1},
+		},
+		{
+			"then who was first",
+			[]Profile{{UserID: 3, Rating: 1200, Wins: 2, Losses: 1}, {UserID: 1, Rating: 1200, Wins: 2, Losses: 1}, {UserID: 2, Rating: 1210}},
+			[]int64{2, 3, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var order []int64
+
+			sortRanking(test.profiles)
+			for _, profile := range test.profiles {
+				order = append(order, profile.UserID)
+			}
+			if !reflect.DeepEqual(order, test.want) {
+				t.Errorf("sorted as %v instead of %v", order, test.want)
+			}
+		})
+	}
+}
+
+func TestGlobalLeaderboard(t *testing.T) {
+	const firstID, secondID, unrankedID = 3001, 3002, 3003
+
+	var store = &countingStore{Store: STORE}
+
+	STORE, rankingLoaded = store, false
+	t.Cleanup(func() { STORE, rankingLoaded = store.Store, false })
+
+	// Way above the players of the other tests, even when they run again on the same store
+	reset := func(userID int64, ranked int) {
+		updateProfile(userID, func(profile *Profile) { *profile = Profile{UserID: userID, Rating: 9000, Ranked: ranked} })
+	}
+	reset(firstID, 1)
+	reset(secondID, 1)
+	reset(unrankedID, 0)
+
+	ranking := GlobalLeaderboard()
+	if len(ranking) < 2 || ranking[0].UserID != firstID || ranking[1].UserID != secondID {
+		t.Fatalf("the leaderboard starts with %+v", ranking)
+	}
+	if GlobalPosition(unrankedID) != 0 {
+		t.Error("a player that never played a ranked duel is in the leaderboard")
+	}
+
+	// Changes are followed without reading the store again
+	updateProfile(secondID, func(profile *Profile) { profile.Wins++ })
+	if GlobalPosition(secondID) != 1 || GlobalPosition(firstID) != 2 {
+		t.Errorf("with the same rating and more wins %d is at %d", secondID, GlobalPosition(secondID))
+	}
+	updateProfile(firstID, func(profile *Profile) { profile.Rating++ })
+	if GlobalPosition(firstID) != 1 {
+		t.Errorf("with a higher rating %d is at %d", firstID, GlobalPosition(firstID))
+	}
+	updateProfile(unrankedID, func(profile *Profile) { profile.Rating, profile.Ranked = 9999, 1 })
+	if GlobalPosition(unrankedID) != 1 {
+		t.Errorf("after his first ranked duel %d is at %d", unrankedID, GlobalPosition(unrankedID))
+	}
+	if store.loads != 1 {
+		t.Errorf("the profiles were read %d times", store.loads)
+	}
+
+	// Who changes the leaderboard he got doesn't change the one of the others
+	ranking = GlobalLeaderboard()
+	ranking[0].Rating = 0
+	if GlobalLeaderboard()[0].Rating == 0 {
+		t.Error("the leaderboard was changed from outside")
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
+	"DuelBot/pg"
+
 	"github.com/NicoNex/echotron/v3"
 )
 
@@ -17,6 +22,12 @@ type bot struct {
 // TOKEN is the Telegram API bot's token.
 var TOKEN string
 
+// RULES is the ruleset used by the combat engine in every duel.
+var RULES *pg.Ruleset
+
+// AFK_TIMEOUT is how long a player can stay without moving before losing the duel.
+var AFK_TIMEOUT time.Duration
+
 // Create a new bot
 func newBot(chatID int64) echotron.Bot {
 	return &bot{chatID, echotron.NewAPI(TOKEN)}
@@ -45,6 +56,9 @@ func (b *bot) handleStart(update *echotron.Update, payload []string) {
 			InlineKeyboard: [][]echotron.InlineKeyboardButton{{
 				{Text: "❓ How to play", CallbackData: "/help"},
 				{Text: "🕹 Play with others", CallbackData: "/start invitationInfo"},
+			}, {
+				{Text: "👤 Profile", CallbackData: "/profile"},
+				{Text: "🏆 Leaderboard", CallbackData: "/top"},
 			}},
 		}
 
@@ -62,6 +76,7 @@ func (b *bot) handleStart(update *echotron.Update, payload []string) {
 					"💬 <b>Invite other users to a duel</b>",
 					"\nTap on \"Inline invitation\" or simply type <code>", username, "</code> in any chat to <i>auto",
 					"magiacally✨</i> generate an invitation message",
+					" (add <code>friendly</code> if you don't want the duel to change your rating)",
 					"\nIf you prefer to create your own instead, you can generate",
 					" a new invitation link using the button below",
 				),
@@ -71,15 +86,27 @@ func (b *bot) handleStart(update *echotron.Update, payload []string) {
 					InlineKeyboard: [][]echotron.InlineKeyboardButton{
 						{{Text: "✨ Inline invitation", SwitchInlineQuery: "DuellingRobot"}},
 						{{Text: "🔗 Invite link", CallbackData: "/invite refresh"}},
+						{{Text: "🎲 Random opponent", CallbackData: "/queue"}},
+						{{Text: "🤖 Practice against me", CallbackData: "/practice"}},
 						{{Text: "🔙 Main menu", CallbackData: "/start"}},
 					},
 				},
 			)
 			return
 		}
+	case 2:
+		switch payload[0] {
+		case "joinDuel":
+			b.handleAccept(-1, payload[1:])
+			return
+		case "watch":
+			b.handleWatch(update, payload[1:])
+			return
+		}
 	case 3:
+		// Links created before the signed tokens contained also the ID of the inviter
 		if payload[0] == "joinDuel" {
-			b.handleAccept(-1, payload[1:])
+			b.SendMessage("This link is expired", b.chatID, nil)
 			return
 		}
 	}
@@ -124,18 +151,23 @@ func (b *bot) handleHelp(update *echotron.Update, payload []string) {
 		text = fmt.Sprint(
 			"<b>How to play - Stats 🧮</b>\n",
 			"Every player have two main stats:\n",
-			"❤️ <b>health</b> - that start at 20 and it reduce every time you recive",
-			" a damage. If it reach 0 you loose. Currently there is no way to heal\n",
-			"⚡ <b>stamina bar</b> - that start at 6 and it cap at 10. It also reduce",
+			"❤️ <b>health</b> - that start at ", RULES.Stats.Health, " and it reduce every time you recive",
+			" a damage. If it reach 0 you loose. You can heal by drinking a <i>potion</i>\n",
+			"⚡ <b>stamina bar</b> - that start at ", RULES.Stats.Stamina, " and it cap at ", RULES.Stats.MaxStamina, ". It also reduce",
 			" itself when you make an action that require energy like <i>dodging</i>",
 			" or <i>attacking</i> and it influence the speed of execution of these, ",
 			"so more stamina you got, faster it is. If it reach 0 you become <i>exausted</i>",
 			" and will not be able to move untill the opponent do something.\nYou can",
 			"gain some stamina back by <i>defending</i>\n",
 			"\nThere is also <b>damage</b> (\"⚔\") that rapresent how much damage ",
-			"that you can deal to an enemy. It's value is always 5 but if the enemy ",
-			"is <i>attacking</i>, it will recive just 2. ",
-			"(half of the damage of the opponent rounded down)\n",
+			"that you can deal to an enemy. It's value is always ", RULES.Stats.Damage, " but if the enemy ",
+			"is <i>defending</i>, it will recive just ", int(float64(RULES.Stats.Damage)*RULES.Multipliers.Defend), ". ",
+			"(", RULES.Multipliers.Defend, " times the damage of the opponent rounded down)\n",
+			"\nEvery duel you bring ", RULES.Items.Loadout[pg.POTION], " 🧪 <b>potion</b> (+", RULES.Items.Potion, " health), ",
+			RULES.Items.Loadout[pg.TONIC], " 🍵 <b>tonic</b> (+", RULES.Items.Tonic, " stamina) and ",
+			RULES.Items.Loadout[pg.SMOKE], " 💨 <b>smoke bomb</b> that let you avoid every hit. Using an item takes ",
+			formatDuration(time.Duration(RULES.Durations.Item)), " and while drinking you are hit as if you were",
+			" <i>on guard</i>, if the enemy is <i>defending</i> you get <i>stunned</i> and the item is not used\n",
 			"\nEvery time you clash against the opponent you recive a report ",
 			"where is how your stats modified and the damage you dealt",
 			"\n\n⚠ <i>This bot is still on beta so things can change in future</i>",
@@ -155,9 +187,11 @@ func (b *bot) handleHelp(update *echotron.Update, payload []string) {
 			"of the damage when hit. When you clash against an enemy it allow you",
 			" to <i>stunn</i> it if it's <i>on guard</i> or if it's <i>dodging</i>",
 			" but you have more stamina\n",
-			"⚔ <b>attack</b> - you deal damage to the enemy if is not <i>defending</i> is 5\n",
+			"⚔ <b>attack</b> - you deal damage to the enemy if is not <i>defending</i> is ", RULES.Stats.Damage, "\n",
 			"➰ <b>dodge</b> - it allow you to not recive any damage if the enemy ",
-			"is <i>attacking</i> but only if you are faster",
+			"is <i>attacking</i> but only if you are faster\n",
+			"\n🎭 Using /class you can also fight as a <b>knight</b>, a <b>rogue</b> or a <b>berserker</b>,",
+			" each one changes your stats and how some of these actions work",
 			"\n\n⚠ <i>This bot is still on beta so things can change in future</i>",
 		)
 
@@ -178,26 +212,39 @@ func (b *bot) handleHelp(update *echotron.Update, payload []string) {
 
 // Handle the inline invitation
 func (b *bot) handleInviteInline(update *echotron.Update) {
+	var (
+		title   = "Engage a duel"
+		message = "Do you have the guts to face me in a duel?"
+		ranked  = true
+	)
+
 	if update.InlineQuery == nil {
 		return
 	}
 
+	// Typing "friendly" the invite will be for a duel that does not change the rating
+	if strings.Contains(strings.ToLower(update.InlineQuery.Query), "friendly") {
+		title, ranked = "Engage a friendly duel", false
+		message = "Let's have a friendly duel, no rating at stake"
+	}
+
 	b.AnswerInlineQuery(
 		update.InlineQuery.ID,
 		[]echotron.InlineQueryResult{
 			&echotron.InlineQueryResultArticle{
 				Type:        echotron.INLINE_ARTICLE,
-				ID:          string(b.chatID),
-				Title:       "Engage a duel",
+				ID:          fmt.Sprint(b.chatID),
+				Title:       title,
 				Description: "Invite this user to a duel",
 				HideURL:     false,
 				ReplyMarkup: echotron.InlineKeyboardMarkup{
-					[][]echotron.InlineKeyboardButton{
-						{{Text: "✅ Accept", URL: b.GenInvitationLink()}},
-					},
+					InlineKeyboard: [][]echotron.InlineKeyboardButton{{{
+						Text:         "⚔️ Accept",
+						CallbackData: "/claim " + ReuseInvite(b.chatID, InviteOptions{Name: "Inline challenge", MaxUses: 1, DuelSettings: DuelSettings{Ranked: ranked}}).Token,
+					}}},
 				},
 				InputMessageContent: echotron.InputTextMessageContent{
-					MessageText: "Do you have the guts to face me in a duel?",
+					MessageText: message,
 				},
 			},
 		},
@@ -211,33 +258,166 @@ func (b *bot) handleInviteInline(update *echotron.Update) {
 
 }
 
-// Handle the request of a new invite link
-func (b *bot) handleInviteLink(update *echotron.Update, payload []string) {
-	var kbd = echotron.InlineKeyboardMarkup{
-		InlineKeyboard: [][]echotron.InlineKeyboardButton{
-			{{Text: "🔂 Refresh", CallbackData: "/invite refresh"}},
-			{{Text: "🔙 Go Back", CallbackData: "/start invitationInfo"}},
+/* Handle the request of an invite inside a group: the invite is posted in the group
+ * and the duel will count for its leaderboard
+ */
+func (b *bot) handleGroupInvite(update *echotron.Update, payload []string) {
+	var (
+		userID   = extractUserID(update)
+		settings = DuelSettings{Ranked: true, GroupID: b.chatID}
+		mode     = "🏅 <i>It's a ranked duel, the rating is at stake</i>"
+	)
+
+	switch len(payload) {
+	case 0:
+	case 1:
+		if payload[0] != "friendly" {
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		}
+		settings.Ranked = false
+		mode = "🤝 <i>It's a friendly duel, the rating will not change</i>"
+	default:
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+
+	b.SendMessage(
+		fmt.Sprint(
+			"⚔️ <b>", GenUserLink(userID, extractName(update)), " is looking for an opponent</b>\n",
+			mode, "\nThe first one that taps on Accept will fight, the result will count for the leaderboard of this group",
+		),
+		b.chatID,
+		&echotron.MessageOptions{
+			ParseMode: echotron.HTML,
+			BaseOptions: echotron.BaseOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{
+				InlineKeyboard: [][]echotron.InlineKeyboardButton{
+					{{Text: "⚔️ Accept", CallbackData: "/claim " + NewInvite(userID, InviteOptions{Name: "Group challenge", MaxUses: 1, DuelSettings: settings}).Token}},
+				},
+			}},
 		},
+	)
+}
+
+/* Handle the request of a new invite link with its options (see parseInviteOptions).
+ * Using "refresh" the message is edited, using "edit <nonce>" the invite is also replaced
+ */
+func (b *bot) handleInviteLink(update *echotron.Update, payload []string) {
+	var (
+		refresh  bool
+		replaced Invite
+		found    bool
+		kbd      echotron.InlineKeyboardMarkup
+	)
+
+	switch true {
+	case len(payload) > 0 && payload[0] == "refresh":
+		refresh, payload = true, payload[1:]
+	case len(payload) > 1 && payload[0] == "edit":
+		nonce, err := strconv.ParseUint(payload[1], 10, 32)
+		if err != nil {
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		}
+		replaced, found = findInvite(b.chatID, uint32(nonce))
+		refresh, payload = true, payload[2:]
 	}
 
-	if len(payload) > 1 {
-		b.SendMessage("Wrong format", b.chatID, nil)
+	opt, err := parseInviteOptions(payload)
+	if err != nil {
+		b.SendMessage(err.Error(), b.chatID, nil)
 		return
 	}
+	// The replaced invite stops working but the new one keeps its name
+	if found {
+		opt.Name = replaced.Name
+		RevokeInvite(b.chatID, replaced.Nonce)
+	}
+	invite := NewInvite(b.chatID, opt)
 
-	text := fmt.Sprint(
-		"🔗 <b>Invitation link</b>",
+	text := "🔗 <b>Invitation link</b>"
+	if invite.Name != "" {
+		text += " \"" + html.EscapeString(invite.Name) + "\""
+	}
+	text += fmt.Sprint(
 		"\nThis is your invitation link, you can send it to your friends so when",
-		" one of them click on it a duel will start against him. If you change",
-		" your idea you can refresh for a new one so the old one will stop working.\n",
-		"\n ", b.GenInvitationLink(), "\n",
-		"\n⚠️<i>Refreshing, opening again this section or using the bot inline ",
-		"will generate a new invite that will make the previous invalid.</i> ",
+		" one of them click on it a duel will start against him.\n\n",
+	)
+	if invite.Ranked {
+		text += "🏅 This invite is for a <b>ranked</b> duel\n"
+	} else {
+		text += "🤝 This invite is for a <b>friendly</b> duel, the rating will not change\n"
+	}
+	if invite.BestOf > 1 {
+		text += fmt.Sprint("🏆 It's a <b>best of ", invite.BestOf, "</b> series, the rounds start one after the other\n")
+	}
+	switch invite.MaxUses {
+	case 0:
+		text += "🎟 It can be used <b>unlimited</b> times\n"
+	case 1:
+		text += "🎟 It can be used <b>only once</b>\n"
+	default:
+		text += fmt.Sprint("🎟 It can be used <b>", invite.MaxUses, "</b> times\n")
+	}
+	text += fmt.Sprint(
+		"⏳ It will expire in <b>", formatDuration(time.Until(invite.Expires)), "</b>\n",
+		"\n ", b.GenInvitationLink(invite), "\n",
+	)
+
+	var others []string
+	for _, other := range getInvites(b.chatID) {
+		if other.Nonce != invite.Nonce {
+			others = append(others, genInviteLine(len(others)+1, other))
+		}
+	}
+	if others != nil {
+		text += "\n📋 <b>Your other invites</b>\n" + strings.Join(others, "\n") + "\n"
+	}
+
+	text += fmt.Sprint(
+		"\n⚙️ <i>Create a custom invite using</i> ",
+		"<code>/invite [friendly] [once | uses=N] [expires=12h | 7d] [bo3 | bo5 | bo7] [name]</code>\n",
+		"\n⚠️<i>Refreshing will cancel this link and generate a new one, ",
+		"the other invites will still work untill they expire or you cancel them using /invites.</i> ",
 		"<a href=\"https://telegra.ph/DuelBot---I-care-about-Privacy-08-26\">",
 		"Because I care about privacy</a>",
 	)
 
-	if len(payload) == 1 && payload[0] == "refresh" {
+	// Every button replaces the invite with a new one with the changed options
+	edit := func(changed InviteOptions) string {
+		return strings.Join(append([]string{"/invite edit", fmt.Sprint(invite.Nonce)}, changed.flags()...), " ")
+	}
+	toggled, usesToggled := opt, opt
+	toggled.Ranked = !opt.Ranked
+	modeBtn := echotron.InlineKeyboardButton{Text: "🤝 Make it friendly", CallbackData: edit(toggled)}
+	if !opt.Ranked {
+		modeBtn.Text = "🏅 Make it ranked"
+	}
+	usesBtn := echotron.InlineKeyboardButton{Text: "1️⃣ Single use"}
+	if opt.MaxUses == 1 {
+		usesToggled.MaxUses = 0
+		usesBtn.Text = "♾ Unlimited uses"
+	} else {
+		usesToggled.MaxUses = 1
+	}
+	usesBtn.CallbackData = edit(usesToggled)
+
+	// The series button cycles through all the lengths, back to the single duel after the longest
+	seriesToggled := opt
+	seriesToggled.BestOf = seriesLengths[(int(seriesCode(opt.BestOf))+1)%len(seriesLengths)]
+	seriesBtn := echotron.InlineKeyboardButton{Text: "🏆 Single duel", CallbackData: edit(seriesToggled)}
+	if opt.BestOf > 1 {
+		seriesBtn.Text = fmt.Sprint("🏆 Best of ", opt.BestOf)
+	}
+
+	kbd.InlineKeyboard = [][]echotron.InlineKeyboardButton{
+		{{Text: "🔂 Refresh", CallbackData: edit(opt)}, modeBtn},
+		{usesBtn, seriesBtn},
+		{{Text: "📋 My invites", CallbackData: "/invites"}},
+		{{Text: "🔙 Go Back", CallbackData: "/start invitationInfo"}},
+	}
+
+	if refresh {
 		b.DisplayMessage(text, extractMessageIDOpt(update), false, &kbd)
 	} else {
 		b.SendMessage(
@@ -259,21 +439,38 @@ func (b *bot) handleInviteUserID(update *echotron.Update, payload []string) {
 		msgID    = extractMessageID(update)
 		userName = GenUserLink(b.chatID, extractName(update))
 		userID   int64
-		text     string
+		text     = "🗡 <b>" + userName + " want to challenge you in a duel</b>"
+		settings = DuelSettings{Ranked: true}
+		err      error
 	)
 
-	switch len(payload) {
-	case 1:
-		text = "🗡 <b>" + userName + " want to challenge you in a duel</b>"
-	case 2:
-		if payload[1] != "rematch" {
+	if len(payload) == 0 || len(payload) > 4 {
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+	for _, flag := range payload[1:] {
+		switch true {
+		case flag == "rematch":
+			text = "🗡 <b>" + userName + " is challenging you for a rematch</b>"
+		case flag == "friendly":
+			settings.Ranked = false
+		case isBestOfFlag(flag):
+			if settings.BestOf, err = parseBestOf(flag[2:]); err != nil {
+				b.SendMessage(err.Error(), b.chatID, nil)
+				return
+			}
+		default:
 			b.SendMessage("Wrong format", b.chatID, nil)
 			return
 		}
-		text = "🗡 <b>" + userName + " is challenging you for a rematch</b>"
-	default:
-		b.SendMessage("Wrong format", b.chatID, nil)
-		return
+	}
+	if settings.Ranked {
+		text += "\n🏅 <i>It's a ranked duel, your rating is at stake</i>"
+	} else {
+		text += "\n🤝 <i>It's a friendly duel, your rating will not change</i>"
+	}
+	if settings.BestOf > 1 {
+		text += fmt.Sprint("\n🏆 <i>It's a best of ", settings.BestOf, " series</i>")
 	}
 
 	if rawID, err := strconv.Atoi(payload[0]); err != nil {
@@ -282,8 +479,9 @@ func (b *bot) handleInviteUserID(update *echotron.Update, payload []string) {
 	} else {
 		userID = int64(rawID)
 	}
-	if res, _ := b.GetMe(); res.Result.ID == userID {
-		b.SendMessage("Sorry I'm too busy now, maybe another time", b.chatID, nil)
+	// Challenging the bot itself means practicing against the AI
+	if res, err := b.GetMe(); err == nil && res.Result != nil && res.Result.ID == userID {
+		b.DisplayPractice(extractMessageIDOpt(update))
 		return
 	}
 	if userID == b.chatID {
@@ -291,11 +489,21 @@ func (b *bot) handleInviteUserID(update *echotron.Update, payload []string) {
 		return
 	}
 
+	// The challenged player can pick his class before accepting
+	var classRow []echotron.InlineKeyboardButton
+	for _, name := range classNames[1:] {
+		classRow = append(classRow, echotron.InlineKeyboardButton{Text: Prettfy(name, false, -1), CallbackData: "/class " + name})
+	}
+	text += "\n🎭 <i>Choose your class before accepting, now you are " + Prettfy(classNames[playerClass(userID)], false, -1) + "</i>"
+
 	opt.BaseOptions.ReplyMarkup = echotron.InlineKeyboardMarkup{
-		InlineKeyboard: [][]echotron.InlineKeyboardButton{{
-			{Text: "✅ Accept", CallbackData: fmt.Sprintf("/accept %d %s", b.chatID, NewInviteID(b.chatID))},
-			{Text: "❌ Decline", CallbackData: fmt.Sprintf("/reject %d %d", b.chatID, msgID)},
-		}},
+		InlineKeyboard: [][]echotron.InlineKeyboardButton{
+			classRow,
+			{
+				{Text: "✅ Accept", CallbackData: "/accept " + NewInvite(b.chatID, InviteOptions{Name: "Challenge", MaxUses: 1, DuelSettings: settings}).Token},
+				{Text: "❌ Decline", CallbackData: fmt.Sprintf("/reject %d %d", b.chatID, msgID)},
+			},
+		},
 	}
 
 	b.EditMessageText(
@@ -309,32 +517,228 @@ func (b *bot) handleInviteUserID(update *echotron.Update, payload []string) {
 
 // Handle the accepting of an incoming match request
 func (b *bot) handleAccept(msgID int, payload []string) {
-	var userID int64
-
-	if len(payload) != 2 {
+	if len(payload) != 1 {
 		b.SendMessage("¯\\_(ツ)_/¯ Wrong format", b.chatID, nil)
 		return
 	}
-	if rawID, err := strconv.Atoi(payload[0]); err != nil {
-		b.SendMessage("Wrong format", b.chatID, nil)
+
+	if errMess := b.joinInvite(payload[0]); errMess != "" {
+		b.SendMessage(errMess, b.chatID, nil)
 		return
-	} else {
-		userID = int64(rawID)
 	}
+	b.DeleteMessage(b.chatID, msgID)
+}
 
-	if errMess := b.IsInvitionValid(userID, payload[1]); errMess != "" {
-		b.SendMessage(errMess, b.chatID, nil)
+// Start the duel of an invite against the user of the chat, errMess != "" if it's not possible
+func (b *bot) joinInvite(token string) (errMess string) {
+	invite, errMess := b.IsInvitionValid(token)
+	if errMess != "" {
 		return
 	}
 
 	// Check if player is busy in another duel or not
-	if !EngageDuel(b.chatID, userID) {
-		b.SendMessage("You or your opponent might be already engaged in another fight. Brawls are still not allowed", b.chatID, nil)
+	if _, err := duels.EngageDuel(b.chatID, invite.InviterID, invite.DuelSettings); err != nil {
+		return "You or your opponent might be already engaged in another fight. To fight more players at once open a /brawl in a group"
+	}
+
+	if err := UseInvite(invite); err != nil {
+		log.Println("joinInvite", "UseInvite", err)
+	}
+	queue.Leave(b.chatID)
+	queue.Leave(invite.InviterID)
+	b.NotifyAcceptDuel(b.chatID, invite.InviterID)
+
+	// If it was an open challenge everybody can see who accepted it
+	if invite.Posted != nil {
+		duels.SetPosted(b.chatID, *invite.Posted)
+		b.AnnounceDuel(*invite.Posted, invite.InviterID, b.chatID)
+	}
+	return ""
+}
+
+/* Handle the claim of an open challenge (posted in a group or inline) by who pressed "Accept".
+ * The duel is fought in private so if he never started the bot he is redirected there first
+ */
+func (b *bot) handleClaim(update *echotron.Update, payload []string) {
+	var (
+		userID = extractUserID(update)
+		player = &bot{userID, b.API}
+		posted = extractMessageRef(update)
+	)
+
+	if update.CallbackQuery == nil || posted == nil || len(payload) != 1 {
+		b.SendMessage("Wrong format", b.chatID, nil)
 		return
 	}
+	answer := func(opt echotron.CallbackQueryOptions) {
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, &opt)
+	}
 
-	b.DeleteMessage(b.chatID, msgID)
-	b.NotifyAcceptDuel(b.chatID, userID)
+	invite, errMess := player.IsInvitionValid(payload[0])
+	if errMess != "" {
+		if invite.MaxUses == 1 && invite.Uses == 1 {
+			errMess = "Too late, someone else already accepted this challenge"
+		}
+		answer(echotron.CallbackQueryOptions{Text: errMess, ShowAlert: true})
+		return
+	}
+	SetInvitePosted(invite, *posted)
+
+	if res, err := b.SendChatAction(echotron.Typing, userID); err != nil || !res.Ok {
+		answer(echotron.CallbackQueryOptions{URL: b.GenInvitationLink(invite)})
+		return
+	}
+
+	if errMess = player.joinInvite(payload[0]); errMess != "" {
+		answer(echotron.CallbackQueryOptions{Text: errMess, ShowAlert: true})
+		return
+	}
+	answer(echotron.CallbackQueryOptions{Text: "⚔️ The duel is starting, check our private chat"})
+}
+
+// Handle the list of the outstanding invites of a user and their cancellation
+func (b *bot) handleInvites(update *echotron.Update, payload []string) {
+	if isGroup(b.chatID) {
+		b.SendMessage("Send me this command in private to see your invites", b.chatID, nil)
+		return
+	}
+
+	switch len(payload) {
+	case 0:
+	case 2:
+		if payload[0] != "cancel" {
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		}
+		if payload[1] == "all" {
+			RevokeInvites(b.chatID)
+		} else if nonce, err := strconv.ParseUint(payload[1], 10, 32); err != nil {
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		} else if !RevokeInvite(b.chatID, uint32(nonce)) {
+			b.SendMessage("This invite is already expired or canceled", b.chatID, nil)
+		}
+	default:
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+
+	b.DisplayInvites(b.chatID, extractMessageIDOpt(update))
+}
+
+// Handle the request of a random opponent putting the player in the matchmaking queue
+func (b *bot) handleQueue(update *echotron.Update) {
+	if isGroup(b.chatID) {
+		b.SendMessage("Send me this command in private to look for an opponent", b.chatID, nil)
+		return
+	}
+	if duels.IsPlayerBusy(b.chatID) {
+		b.SendMessage("You are already engaged in another fight. To fight more players at once open a /brawl in a group", b.chatID, nil)
+		return
+	}
+	if queue.IsWaiting(b.chatID) {
+		b.SendMessage("Be patient warrior, I'm still looking for an opponent for you", b.chatID, nil)
+		return
+	}
+
+	profile := GetProfile(b.chatID)
+	res, err := b.DisplayMessage(
+		fmt.Sprint(
+			"🔎 <b>Looking for an opponent...</b>\n",
+			"I'm searching for someone with a rating close to yours (<code>", profile.Rating, "</code>),",
+			" the more you wait the more I will widen the search.\n",
+			"\n<i>The duel will start as soon as I find someone, it will be a ranked duel</i>",
+		),
+		extractMessageIDOpt(update),
+		false,
+		&echotron.InlineKeyboardMarkup{
+			InlineKeyboard: [][]echotron.InlineKeyboardButton{
+				{{Text: "❌ Leave queue", CallbackData: "/leavequeue"}},
+			},
+		},
+	)
+	if err != nil || res.Result == nil {
+		log.Println("handleQueue", "DisplayMessage", err)
+		return
+	}
+
+	queue.Join(QueueEntry{UserID: b.chatID, Rating: profile.Rating, MessageID: res.Result.ID})
+}
+
+// Handle the exit from the matchmaking queue
+func (b *bot) handleLeaveQueue(update *echotron.Update) {
+	if _, ok := queue.Leave(b.chatID); !ok {
+		b.SendMessage("You are not looking for an opponent", b.chatID, nil)
+		return
+	}
+
+	b.DisplayMessage(
+		"✖️ <i>You stopped looking for an opponent</i>",
+		extractMessageIDOpt(update),
+		false,
+		&echotron.InlineKeyboardMarkup{
+			InlineKeyboard: [][]echotron.InlineKeyboardButton{
+				{{Text: "🎲 Search again", CallbackData: "/queue"}},
+			},
+		},
+	)
+}
+
+// Handle two players paired by the matchmaking starting the duel between them
+func handleMatch(first, second QueueEntry) {
+	var b = &bot{first.UserID, echotron.NewAPI(TOKEN)}
+
+	// Meanwhile one of them might have started another duel, the other one keeps waiting
+	if _, err := duels.EngageDuel(first.UserID, second.UserID, DuelSettings{Ranked: true}); err != nil {
+		for _, entry := range [2]QueueEntry{first, second} {
+			if !duels.IsPlayerBusy(entry.UserID) {
+				queue.Join(entry)
+			}
+		}
+		return
+	}
+
+	b.DeleteMessage(first.UserID, first.MessageID)
+	b.DeleteMessage(second.UserID, second.MessageID)
+	b.NotifyAcceptDuel(first.UserID, second.UserID)
+}
+
+// Handle the request of a practice duel against the AI opponent with the choosen difficulty
+func (b *bot) handlePractice(update *echotron.Update, payload []string) {
+	var aiID int64
+
+	switch true {
+	case len(payload) == 0:
+		b.DisplayPractice(extractMessageIDOpt(update))
+		return
+	case len(payload) != 1:
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	case isGroup(b.chatID):
+		b.SendMessage("Send me this command in private to practice against me", b.chatID, nil)
+		return
+	}
+
+	level := AILevel(payload[0])
+	if _, ok := findDifficulty(level); !ok {
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+
+	aiID = newAIID()
+	if _, err := duels.EngageDuel(b.chatID, aiID, DuelSettings{AI: level}); err != nil {
+		b.SendMessage("You are already engaged in another fight. To fight more players at once open a /brawl in a group", b.chatID, nil)
+		return
+	}
+	if err := StartAI(aiID, level); err != nil {
+		log.Println("handlePractice", "StartAI", err)
+		duels.EndDuel(aiID)
+		return
+	}
+
+	queue.Leave(b.chatID)
+	b.DeleteMessage(b.chatID, extractMessageID(update))
+	b.NotifyAcceptDuel(b.chatID, aiID)
 }
 
 // Handle the rejecting of an incoming match request
@@ -380,82 +784,656 @@ func (b *bot) handleReject(update *echotron.Update, payload []string) {
 
 // Handle the changing action inside a duel
 func (b *bot) handleAction(payload []string) {
-	var (
-		duration time.Duration
-		enemyID  int64
-		err      error
-	)
-
 	if len(payload) != 1 {
 		b.SendMessage("Wrong format", b.chatID, nil)
 		return
 	}
 
-	// Grab enemyID
-	enemyID, err = GetOpponentID(b.chatID)
-	if err != nil {
+	if !duels.IsPlayerBusy(b.chatID) {
 		b.SendMessage("Calm down warrior... you are not in a fight anymore", b.chatID, nil)
 		return
 	}
 
-	// Set the player moves and update status
-	duration, err = SetPlayerMoves(b.chatID, payload[0])
+	if err := b.applyMove(b.chatID, payload[0]); err != nil {
+		log.Println("handleAction", "applyMove", err)
+	}
+}
+
+/* Set the move of a player (human or AI) and update his status, the enemies on guard
+ * will spot the action. The clash will be handled by the scheduler
+ */
+func (b *bot) applyMove(userID int64, move string) error {
+	enemyIDs, err := duels.GetEnemies(userID)
 	if err != nil {
-		log.Println("handleAction", "SetPlayerMoves", err)
-		return
+		return err
+	}
+
+	if _, err = duels.SetPlayerMoves(userID, move); err != nil {
+		return err
 	}
-	DisplayStatus(b.chatID, false)
+	DisplayStatus(userID, false)
 
 	// If enemy is on guard spy the action
-	if onGurad, _ := IsPlayerOnGuard(enemyID); onGurad {
-		b.SpyAction(enemyID, b.chatID, payload[0])
+	for _, enemyID := range enemyIDs {
+		if onGurad, _ := duels.IsPlayerOnGuard(enemyID); onGurad {
+			b.SpyAction(enemyID, userID, move)
+		}
 	}
+	return nil
+}
 
-	// If action does not require any duration (already done) just quit
-	if duration == time.Duration(0) || payload[0] == "DEFEND" {
+// Handle the choice of who the actions of the player are against during a team duel
+func (b *bot) handleTarget(update *echotron.Update, payload []string) {
+	if len(payload) != 1 {
+		b.SendMessage("Wrong format, use /target followed by the ID of the player", b.chatID, nil)
 		return
 	}
 
-	// Perform the action between players and his opponent
-	report, err := PlayersPerformMove(b.chatID, enemyID)
-	if err != nil {
-		log.Println("handleAction", "PlayersPerformMove", err)
-		return
+	targetID, err := strconv.ParseInt(payload[0], 10, 64)
+	if err == nil {
+		err = duels.SetTarget(b.chatID, targetID)
+	}
+	switch true {
+	case err != nil && update.CallbackQuery != nil:
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{Text: err.Error()})
+	case err != nil:
+		b.SendMessage(err.Error(), b.chatID, nil)
+	default:
+		DisplayStatus(b.chatID, false)
+		if update.CallbackQuery != nil {
+			b.AnswerCallbackQuery(update.CallbackQuery.ID, nil)
+		}
 	}
+}
+
+// Handle the result of a clash between two players
+func handleClash(report BattleReport) {
+	var b = &bot{report.PlayersInfo[0].UserID, echotron.NewAPI(TOKEN)}
 
 	// Notify users
+	RecordClash(report)
 	b.NotifyBattleReport(report)
+	summary := b.genClashSummary(report)
 	if !report.EndDuel {
+		b.UpdateSpectators(b.chatID, summary, false)
+		// A new round begins, the AI opponent can think again
+		for _, player := range report.PlayersInfo {
+			NotifyAI(player.UserID, "")
+		}
 		return
 	}
+	if report.Team {
+		b.EndTeamDuel(b.chatID, report.Winners, summary)
+		return
+	}
+	// The end of the duel was already claimed by the clash, nobody else can record it
+	settings, _ := duels.GetSettings(b.chatID)
+	changes := RecordEndDuel(report.WinnerID, report.PlayersInfo[0].UserID, report.PlayersInfo[1].UserID, settings)
+	duels.SetRatingChanges(b.chatID, changes)
+	settings, _ = duels.UpdateSeries(b.chatID, func(series *SeriesScore) { series.AddRound(report.WinnerID) })
 	if report.WinnerID == nil {
-		b.NotifyDraw(report.PlayersInfo[0].UserID, report.PlayersInfo[1].UserID)
+		b.UpdateSpectators(b.chatID, summary+"\n\n⚖️ <b>The duel is a draw</b>", true)
+		b.NotifyDraw(report.PlayersInfo[0].UserID, report.PlayersInfo[1].UserID, changes)
 	} else {
-		b.NotifyEndDuel(*report.WinnerID)
+		winner := GenUserLink(*report.WinnerID, b.GetUserName(*report.WinnerID))
+		b.UpdateSpectators(b.chatID, summary+"\n\n🏆 <b>"+winner+" won the duel</b>", true)
+		b.NotifyEndDuel(*report.WinnerID, changes)
 	}
 
+	b.ContinueSeries(settings)
+
 	// End duel (if duel ended)
-	EndDuel(b.chatID)
+	duels.EndDuel(b.chatID)
+	for _, player := range report.PlayersInfo {
+		StopAI(player.UserID)
+	}
 }
 
-// Handle the exit from a duel
+// Handle the exit from a duel, in a team duel the player is just knocked out
 func (b *bot) handleFlee() {
-	if !IsPlayerBusy(b.chatID) {
+	opponentID, err := duels.GetOpponentID(b.chatID)
+	if err != nil {
 		b.SendMessage("What are you running away from? There is no battle", b.chatID, nil)
 		return
 	}
-	b.NotifyCancel()
-	EndDuel(b.chatID)
+	if duels.IsTeamDuel(b.chatID) {
+		if err = b.RetireTeamPlayer(b.chatID, EventFlee); err != nil {
+			b.SendMessage(err.Error(), b.chatID, nil)
+		}
+		return
+	}
+	// The last clash or a timeout might have ended the duel in the meantime
+	if !duels.ClaimEnd(b.chatID) {
+		b.SendMessage("The duel is already over", b.chatID, nil)
+		return
+	}
+	settings, _ := duels.GetSettings(b.chatID)
+	duels.LogEnd(b.chatID, EventFlee)
+	b.UpdateSpectators(b.chatID, "🏳️ <b>"+GenUserLink(b.chatID, b.GetUserName(b.chatID))+" fled from the duel</b>", true)
+	changes := RecordFlee(b.chatID, opponentID, settings)
+	duels.SetRatingChanges(b.chatID, changes)
+	settings, _ = duels.UpdateSeries(b.chatID, func(series *SeriesScore) { series.Leave(b.chatID) })
+	b.NotifyCancel(changes)
+	b.ContinueSeries(settings)
+	duels.EndDuel(b.chatID)
+	StopAI(opponentID)
 }
 
-// Handle the sending of the entire last battle history
-func (b *bot) handleBattleHistory() {
-	var history = GenPlayerHistory(b.chatID)
+/* Handle the request of watching a duel using its ID.
+ * Using the button of an open challenge the live view is sent in private
+ */
+func (b *bot) handleWatch(update *echotron.Update, payload []string) {
+	var chatID = b.chatID
+
+	if len(payload) != 1 {
+		b.SendMessage("Wrong format, use /watch followed by the ID of the duel", b.chatID, nil)
+		return
+	}
+	userID, found := duels.FindDuel(payload[0])
+
+	reply := func(text string) {
+		if update.CallbackQuery != nil {
+			b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{Text: text, ShowAlert: true})
+		} else {
+			b.SendMessage(text, b.chatID, nil)
+		}
+	}
+
+	if update.CallbackQuery != nil {
+		chatID = extractUserID(update)
+	}
+	switch true {
+	case !found:
+		reply("There is no ongoing duel with this ID, maybe it's already over")
+		return
+	case chatID == userID || duels.IsPlayerBusy(chatID) && isOpponent(userID, chatID):
+		reply("You can't watch a duel you are fighting in")
+		return
+	}
 
-	if history == "" {
-		history = "<i>There is nothing to see here</i>"
+	// The button can be pressed by who never started the bot, he needs to do it first
+	if update.CallbackQuery != nil {
+		if res, err := b.SendChatAction(echotron.Typing, chatID); err != nil || !res.Ok {
+			b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{URL: b.genStartLink("watch_" + payload[0])})
+			return
+		}
+	}
+
+	if err := b.DisplaySpectatorView(userID, chatID); err != nil {
+		reply("I'm unable to show you this duel right now")
+		return
+	}
+	if update.CallbackQuery != nil {
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{Text: "👀 Check our private chat"})
 	}
-	b.SendMessage(history, b.chatID, &echotron.MessageOptions{ParseMode: echotron.HTML})
+}
+
+// Check if two users are fighting against each other
+func isOpponent(userID, otherID int64) bool {
+	enemyIDs, err := duels.GetEnemies(userID)
+	return err == nil && containsID(enemyIDs, otherID)
+}
+
+// Handle the request of not watching a duel anymore
+func (b *bot) handleUnwatch(update *echotron.Update, payload []string) {
+	if len(payload) != 1 {
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+
+	userID, found := duels.FindDuel(payload[0])
+	if found {
+		found, _ = duels.RemoveSpectator(userID, b.chatID)
+	}
+	if !found {
+		b.SendMessage("You are not watching this duel", b.chatID, nil)
+		return
+	}
+
+	b.DisplayMessage("👀 <i>You stopped watching the duel</i>", extractMessageIDOpt(update), false, nil)
+}
+
+/* Handle the request of the past duels: the list of them (using page to navigate it),
+ * the clash-by-clash report of one of them or the export as a file in one of the historyFormats
+ */
+func (b *bot) handleBattleHistory(update *echotron.Update, payload []string) {
+	var (
+		IDO    = extractMessageIDOpt(update)
+		page   int
+		clash  int
+		record *DuelLog
+		err    error
+	)
+
+	if len(payload) == 0 {
+		b.DisplayHistory(b.chatID, 0, IDO)
+		return
+	}
+
+	switch format := strings.ToLower(payload[0]); true {
+	case format == "close" && len(payload) == 1:
+		b.DeleteMessage(b.chatID, extractMessageID(update))
+		return
+
+	case format == "page" && len(payload) == 2:
+		if page, err = strconv.Atoi(payload[1]); err != nil {
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		}
+		b.DisplayHistory(b.chatID, page, IDO)
+		return
+
+	case isHistoryFormat(format) && len(payload) <= 2:
+		if len(payload) == 1 {
+			record, err = lastDuelLog(b.chatID)
+		} else {
+			record, err = b.loadPlayedDuel(payload[1])
+		}
+		if err != nil {
+			log.Println("handleBattleHistory", "LoadDuelLog", err)
+		}
+		if record == nil || b.SendHistory(*record, format) != nil {
+			b.SendMessage("<i>There is nothing to see here</i>", b.chatID, &echotron.MessageOptions{ParseMode: echotron.HTML})
+		}
+		return
+
+	case len(payload) == 1 || len(payload) == 3:
+		if len(payload) == 3 {
+			clash, err = strconv.Atoi(payload[1])
+			if err == nil {
+				page, err = strconv.Atoi(payload[2])
+			}
+			if err != nil {
+				b.SendMessage("Wrong format", b.chatID, nil)
+				return
+			}
+		}
+		if record, err = b.loadPlayedDuel(payload[0]); record == nil {
+			if err != nil {
+				log.Println("handleBattleHistory", "LoadDuelLog", err)
+			}
+			b.SendMessage("There is no duel of yours with this ID", b.chatID, nil)
+			return
+		}
+		b.DisplayDuelHistory(b.chatID, *record, clash, page, IDO)
+		return
+	}
+
+	b.SendMessage("Wrong format", b.chatID, nil)
+}
+
+// Load the log of an ended duel, nil if the user didn't take part in it
+func (b *bot) loadPlayedDuel(duelID string) (*DuelLog, error) {
+	record, err := STORE.LoadDuelLog(strings.ToLower(duelID))
+	if err != nil || record == nil {
+		return nil, err
+	}
+	if !containsID(record.Participants, b.chatID) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// Check if the history can be exported in the given format
+func isHistoryFormat(format string) bool {
+	for _, current := range historyFormats {
+		if current == format {
+			return true
+		}
+	}
+	return false
+}
+
+/* Handle the tournament of a group: "create [single | double | roundrobin]" opens a new one,
+ * "join" and "leave" can be used untill the host uses "start" (or "cancel" to delete it).
+ * Without payload the bracket is sent again
+ */
+func (b *bot) handleTournament(update *echotron.Update, payload []string) {
+	var (
+		userID = extractUserID(update)
+		t      Tournament
+		err    error
+	)
+
+	reply := func(text string) {
+		if update.CallbackQuery != nil {
+			b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{Text: text, ShowAlert: true})
+		} else {
+			b.SendMessage(text, b.chatID, nil)
+		}
+	}
+
+	if !isGroup(b.chatID) {
+		b.SendMessage("Tournaments can be organized only inside groups", b.chatID, nil)
+		return
+	}
+	if len(payload) == 0 {
+		payload = []string{"show"}
+	}
+
+	switch action := strings.ToLower(payload[0]); true {
+	case action == "create" && len(payload) <= 2:
+		format := SingleElimination
+		if len(payload) == 2 {
+			format = strings.ToLower(payload[1])
+		}
+		if !isTournamentFormat(format) {
+			reply("Wrong format, choose between: " + strings.Join(tournamentFormats, ", "))
+			return
+		}
+		if err = tournaments.Create(b.chatID, userID, extractName(update), format); err != nil {
+			reply(err.Error())
+			return
+		}
+		fallthrough
+
+	case action == "show" && len(payload) == 1:
+		if t, err = tournaments.Get(b.chatID); err != nil {
+			reply(err.Error())
+			return
+		}
+		text, kbd := genBracket(t)
+		res, err := b.DisplayMessage(text, nil, false, &kbd)
+		if err != nil || res.Result == nil {
+			log.Println("handleTournament", "DisplayMessage", err)
+			return
+		}
+		// The old bracket is not updated anymore
+		tournaments.SetMessage(b.chatID, res.Result.ID)
+		return
+
+	case action == "join" && len(payload) == 1:
+		// Matches are fought in private so the bot needs to be started first
+		if res, err := b.SendChatAction(echotron.Typing, userID); err != nil || !res.Ok {
+			if update.CallbackQuery != nil {
+				b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{URL: b.genStartLink("")})
+			} else {
+				reply("Start me in private before joining the tournament")
+			}
+			return
+		}
+		t, err = tournaments.Join(b.chatID, userID, extractName(update))
+
+	case action == "leave" && len(payload) == 1:
+		t, err = tournaments.Leave(b.chatID, userID)
+
+	case action == "start" && len(payload) == 1:
+		t, err = tournaments.Start(b.chatID, userID)
+
+	case action == "cancel" && len(payload) == 1:
+		if t, err = tournaments.Cancel(b.chatID, userID); err == nil {
+			b.EditMessageText(
+				"🏟 <i>The tournament was canceled by the host</i>",
+				echotron.NewMessageID(t.GroupID, t.MessageID),
+				&echotron.MessageTextOptions{ParseMode: echotron.HTML},
+			)
+			reply("The tournament was canceled")
+			return
+		}
+
+	default:
+		reply("Wrong format")
+		return
+	}
+
+	if err != nil {
+		reply(err.Error())
+		return
+	}
+	b.UpdateBracket(t)
+	if update.CallbackQuery != nil {
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, nil)
+	}
+}
+
+/* Handle the lobby of the team duel of a group: it's opened (or shown again) without payload,
+ * then the players join a team, leave it or the host cancel it using the buttons
+ */
+func (b *bot) handleTeamDuel(update *echotron.Update, payload []string) {
+	b.handleLobby(update, payload, false)
+}
+
+// Handle the lobby of a brawl inside a group: show (or open), join, leave, start or cancel
+func (b *bot) handleBrawl(update *echotron.Update, payload []string) {
+	b.handleLobby(update, payload, true)
+}
+
+// Handle the lobby of a team duel or of a brawl inside a group
+func (b *bot) handleLobby(update *echotron.Update, payload []string, brawl bool) {
+	var (
+		userID = extractUserID(update)
+		kind   = "team duel"
+		l      Lobby
+		err    error
+	)
+	if brawl {
+		kind = "brawl"
+	}
+
+	reply := func(text string) {
+		if update.CallbackQuery != nil {
+			b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{Text: text, ShowAlert: true})
+		} else {
+			b.SendMessage(text, b.chatID, nil)
+		}
+	}
+
+	if !isGroup(b.chatID) {
+		b.SendMessage("Team duels and brawls can be organized only inside groups", b.chatID, nil)
+		return
+	}
+	if len(payload) == 0 {
+		payload = []string{"show"}
+	}
+
+	switch action := strings.ToLower(payload[0]); true {
+	case action == "show" && len(payload) == 1:
+		if l, err = lobbies.Get(b.chatID); err == errNoLobby {
+			if duels.IsPlayerBusy(userID) {
+				reply("You are already in a duel")
+				return
+			}
+			l, err = lobbies.Open(b.chatID, userID, extractName(update), brawl)
+		}
+		if err != nil {
+			reply(err.Error())
+			return
+		}
+		text, kbd := genLobby(l)
+		res, err := b.DisplayMessage(text, nil, false, &kbd)
+		if err != nil || res.Result == nil {
+			log.Println("handleLobby", "DisplayMessage", err)
+			return
+		}
+		// The old message is not updated anymore
+		lobbies.SetMessage(b.chatID, res.Result.ID)
+		return
+
+	case action == "join" && len(payload) <= 2:
+		// Inside a brawl there is just one team to join
+		team, convErr := 0, error(nil)
+		if len(payload) == 2 {
+			team, convErr = strconv.Atoi(payload[1])
+		}
+		if convErr != nil || (len(payload) == 1 && !brawl) {
+			reply("Wrong format")
+			return
+		}
+		if duels.IsPlayerBusy(userID) {
+			reply("You are already in a duel")
+			return
+		}
+		// Team duels are fought in private so the bot needs to be started first
+		if res, err := b.SendChatAction(echotron.Typing, userID); err != nil || !res.Ok {
+			if update.CallbackQuery != nil {
+				b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{URL: b.genStartLink("")})
+			} else {
+				reply("Start me in private before joining the " + kind)
+			}
+			return
+		}
+		l, err = lobbies.Join(b.chatID, userID, extractName(update), team)
+
+	case action == "leave" && len(payload) == 1:
+		l, err = lobbies.Leave(b.chatID, userID)
+
+	case action == "start" && len(payload) == 1:
+		if l, err = lobbies.Ready(b.chatID, userID); err == nil {
+			err = b.StartLobby(l)
+		}
+		if err != nil {
+			reply(err.Error())
+		} else if update.CallbackQuery != nil {
+			b.AnswerCallbackQuery(update.CallbackQuery.ID, nil)
+		}
+		return
+
+	case action == "cancel" && len(payload) == 1:
+		l, err = lobbies.Close(b.chatID, userID)
+		if err == nil {
+			b.EditMessageText(
+				"👥 <i>The "+kind+" was canceled</i>",
+				echotron.NewMessageID(l.GroupID, l.MessageID),
+				&echotron.MessageTextOptions{ParseMode: echotron.HTML},
+			)
+			reply("The " + kind + " was canceled")
+			return
+		}
+
+	default:
+		reply("Wrong format")
+		return
+	}
+
+	switch true {
+	case err != nil:
+		reply(err.Error())
+		return
+	case len(l.Names) == 0:
+		b.EditMessageText(
+			"👥 <i>Everybody left the "+kind+"</i>",
+			echotron.NewMessageID(l.GroupID, l.MessageID),
+			&echotron.MessageTextOptions{ParseMode: echotron.HTML},
+		)
+	case l.full():
+		if err = b.StartLobby(l); err != nil {
+			b.UpdateLobby(l)
+			reply(err.Error())
+			return
+		}
+	default:
+		b.UpdateLobby(l)
+	}
+	if update.CallbackQuery != nil {
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, nil)
+	}
+}
+
+// Handle the start of a match of a tournament
+func handleTournamentMatch(t Tournament, match TournamentMatch) {
+	var b = &bot{t.GroupID, echotron.NewAPI(TOKEN)}
+
+	b.UpdateBracket(t)
+	for i, userID := range match.Players {
+		b.SendMessage(
+			fmt.Sprint("🏟 <b>Your tournament match is starting</b>, round ", match.Round, " against ", html.EscapeString(t.Names[match.Players[1-i]])),
+			userID,
+			&echotron.MessageOptions{ParseMode: echotron.HTML},
+		)
+	}
+	b.NotifyAcceptDuel(match.Players[0], match.Players[1])
+}
+
+// Handle the request of the lifetime statistics of the player
+func (b *bot) handleProfile(update *echotron.Update, payload []string) {
+	if len(payload) != 0 {
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+	b.DisplayProfile(b.chatID, extractMessageIDOpt(update))
+}
+
+/* Handle the choice of the class of the player: without payload the classes are shown, otherwise
+ * the named one is chosen. Using "menu" after the name the list of the classes is updated
+ */
+func (b *bot) handleClass(update *echotron.Update, payload []string) {
+	if len(payload) == 0 {
+		b.DisplayClasses(b.chatID, extractMessageIDOpt(update))
+		return
+	}
+
+	name := strings.ToUpper(payload[0])
+	if _, exists := toClass[name]; !exists || len(payload) > 2 {
+		b.SendMessage("Wrong format, use /class followed by knight, rogue, berserker or noclass", b.chatID, nil)
+		return
+	}
+	SetClass(b.chatID, name)
+
+	text := "You will fight as " + Prettfy(name, false, -1)
+	if duels.IsPlayerBusy(b.chatID) {
+		text += " starting from the next duel"
+	}
+	switch true {
+	case len(payload) == 2 && payload[1] == "menu":
+		b.DisplayClasses(b.chatID, extractMessageIDOpt(update))
+		if update.CallbackQuery != nil {
+			b.AnswerCallbackQuery(update.CallbackQuery.ID, nil)
+		}
+	case update.CallbackQuery != nil:
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{Text: text})
+	default:
+		b.SendMessage(text, b.chatID, nil)
+	}
+}
+
+// Handle the request of the leaderboard (the one of the group by default if used in a group)
+func (b *bot) handleTop(update *echotron.Update, payload []string) {
+	var (
+		groupID int64
+		page    int
+		err     error
+	)
+
+	if isGroup(b.chatID) {
+		groupID = b.chatID
+	}
+
+	switch len(payload) {
+	case 0:
+	case 1:
+		if payload[0] != "close" {
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		}
+		b.DeleteMessage(b.chatID, extractMessageID(update))
+		return
+	case 2:
+		switch true {
+		case payload[0] == "global":
+			groupID = 0
+		case payload[0] != "group" || !isGroup(b.chatID):
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		}
+		if page, err = strconv.Atoi(payload[1]); err != nil {
+			b.SendMessage("Wrong format", b.chatID, nil)
+			return
+		}
+	default:
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+
+	b.DisplayLeaderboard(groupID, page, extractMessageIDOpt(update))
+}
+
+// Handle the request of the rating of the player
+func (b *bot) handleRank(update *echotron.Update, payload []string) {
+	if len(payload) != 0 {
+		b.SendMessage("Wrong format", b.chatID, nil)
+		return
+	}
+	b.DisplayRank(b.chatID, extractMessageIDOpt(update))
 }
 
 // Manage the incoming inputs (uptate) from Telegram
@@ -477,7 +1455,11 @@ func (b *bot) Update(update *echotron.Update) {
 		b.handleHelp(update, payload)
 
 	case "/invite":
-		b.handleInviteLink(update, payload)
+		if isGroup(b.chatID) {
+			b.handleGroupInvite(update, payload)
+		} else {
+			b.handleInviteLink(update, payload)
+		}
 
 	case "/inviteid":
 		b.handleInviteUserID(update, payload)
@@ -487,28 +1469,163 @@ func (b *bot) Update(update *echotron.Update) {
 	case "/reject":
 		b.handleReject(update, payload)
 
+	case "/claim":
+		b.handleClaim(update, payload)
+
+	case "/watch":
+		b.handleWatch(update, payload)
+
+	case "/unwatch":
+		b.handleUnwatch(update, payload)
+
+	case "/invites":
+		b.handleInvites(update, payload)
+
 	case "/id":
 		b.SendMessage(fmt.Sprint(b.chatID), b.chatID, nil)
 
 	case "/history":
-		b.handleBattleHistory()
+		b.handleBattleHistory(update, payload)
+
+	case "/profile":
+		b.handleProfile(update, payload)
+
+	case "/class":
+		b.handleClass(update, payload)
+
+	case "/rank":
+		b.handleRank(update, payload)
+
+	case "/top":
+		b.handleTop(update, payload)
+
+	case "/queue":
+		b.handleQueue(update)
+
+	case "/leavequeue":
+		b.handleLeaveQueue(update)
+
+	case "/practice":
+		b.handlePractice(update, payload)
+
+	case "/tournament":
+		b.handleTournament(update, payload)
+
+	case "/teamduel":
+		b.handleTeamDuel(update, payload)
+
+	case "/brawl":
+		b.handleBrawl(update, payload)
 
 	// Inside a duel
 	case "/action":
 		b.handleAction(payload)
 
+	case "/target":
+		b.handleTarget(update, payload)
+
 	case "/end", "/flee":
 		b.handleFlee()
 	}
 }
 
 func main() {
+	if duelID, err := LoadReplayID(); err != nil {
+		fmt.Println(err)
+		return
+	} else if duelID != "" {
+		runReplay(duelID)
+		return
+	}
 	if rawToken, err := LoadToken(); err != nil {
 		fmt.Println(err)
 		return
 	} else {
 		TOKEN = rawToken
 	}
-	dsp := echotron.NewDispatcher(TOKEN, newBot)
-	log.Println(dsp.Poll())
+	if secret, err := LoadSecret(); err != nil {
+		fmt.Println(err)
+		return
+	} else {
+		SECRET = secret
+	}
+	if rules, err := LoadRuleset(); err != nil {
+		fmt.Println(err)
+		return
+	} else {
+		RULES = rules
+	}
+	if timeout, err := LoadAFKTimeout(); err != nil {
+		fmt.Println(err)
+		return
+	} else {
+		AFK_TIMEOUT = timeout
+	}
+	duels.OnClash = handleClash
+	queue.OnMatch = handleMatch
+	tournaments.OnMatch = handleTournamentMatch
+	if store, err := LoadStore(); err != nil {
+		fmt.Println(err)
+		return
+	} else {
+		STORE = store
+	}
+	defer STORE.Close()
+	if resumed, err := restoreState(); err != nil {
+		fmt.Println(err)
+		return
+	} else {
+		go resumeDuels(resumed)
+	}
+
+	go queue.Run()
+	go tournaments.Run()
+	go sweepIdlePlayers()
+	log.Println(poll())
+}
+
+// Open the store and replay an ended duel, without starting the bot
+func runReplay(duelID string) {
+	store, err := LoadStore()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	STORE = store
+	defer STORE.Close()
+
+	if err = replayDuel(duelID); err != nil {
+		fmt.Println("Replay failed:", err)
+	}
+}
+
+/* Keep receiving the updates from Telegram and pass each one of them to a new bot of its chat.
+ * The echotron dispatcher is not used because it can't handle the callbacks of inline messages
+ */
+func poll() error {
+	var (
+		api  = echotron.NewAPI(TOKEN)
+		opts = echotron.UpdateOptions{Timeout: 120}
+	)
+
+	// Old updates are dropped like the dispatcher does
+	if res, err := api.DeleteWebhook(true); err != nil {
+		return err
+	} else if !res.Ok {
+		return errors.New("could not disable webhook, running in long polling mode is not possible")
+	}
+
+	for {
+		res, err := api.GetUpdates(&opts)
+		if err != nil {
+			return err
+		}
+
+		for _, update := range res.Result {
+			opts.Offset = update.ID + 1
+			if chatID, ok := extractChatID(update); ok {
+				go newBot(chatID).Update(update)
+			}
+		}
+	}
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"DuelBot/pg"
+)
+
+// Every test uses the default ruleset and a store on a temporary file
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "duelbot")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	RULES = pg.DefaultRuleset()
+	AFK_TIMEOUT = defAFKTimeout
+	SECRET = []byte("DuelBot test secret")
+	if STORE, err = NewBoltStore(filepath.Join(dir, "test.db")); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	STORE.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
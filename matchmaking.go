@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	queueBaseWindow  = 50               // max rating difference when a player join the queue
+	queueWindowStep  = 50               // rating difference added to the window every queueWindowEvery
+	queueWindowEvery = 10 * time.Second // how often the search window widens
+	queueCheckEvery  = 1 * time.Second  // how often the queue looks for new pairs
+)
+
+// MatchQueue is the matchmaking pool where players wait to be paired with a random opponent
+type MatchQueue struct {
+	mu      sync.Mutex
+	waiting map[int64]QueueEntry
+	joined  chan struct{}                  // signal that a new player joined the queue
+	OnMatch func(first, second QueueEntry) // called when two players are paired
+}
+
+// A player waiting in the matchmaking queue
+type QueueEntry struct {
+	UserID    int64
+	Rating    int
+	Since     time.Time
+	MessageID int // message that tells the player he is in queue
+}
+
+var queue = NewMatchQueue()
+
+// Create a new empty matchmaking queue
+func NewMatchQueue() *MatchQueue {
+	return &MatchQueue{
+		waiting: make(map[int64]QueueEntry),
+		joined:  make(chan struct{}, 1),
+	}
+}
+
+// Put a player in the queue, false if he was already in it
+func (q *MatchQueue) Join(entry QueueEntry) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, isIn := q.waiting[entry.UserID]; isIn {
+		return false
+	}
+	if entry.Since.IsZero() {
+		entry.Since = time.Now()
+	}
+	q.waiting[entry.UserID] = entry
+
+	select {
+	case q.joined <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Check if a player is waiting in the queue
+func (q *MatchQueue) IsWaiting(userID int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, isIn := q.waiting[userID]
+	return isIn
+}
+
+// Remove a player from the queue, false if he was not in it
+func (q *MatchQueue) Leave(userID int64) (entry QueueEntry, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if entry, ok = q.waiting[userID]; ok {
+		delete(q.waiting, userID)
+	}
+	return
+}
+
+// Get the max rating difference accepted by a player, it widens while he's waiting
+func (e QueueEntry) window(now time.Time) int {
+	return queueBaseWindow + queueWindowStep*int(now.Sub(e.Since)/queueWindowEvery)
+}
+
+// Pair the waiting players whose ratings are inside the search window of both
+func (q *MatchQueue) match() (pairs [][2]QueueEntry) {
+	var (
+		now     = time.Now()
+		entries []QueueEntry
+	)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The players waiting for more time have the precedence
+	for _, entry := range q.waiting {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Since.Before(entries[j].Since) })
+
+	for i, current := range entries {
+		var (
+			best     = -1
+			bestDiff int
+		)
+
+		if _, isIn := q.waiting[current.UserID]; !isIn {
+			continue
+		}
+
+		for j := i + 1; j < len(entries); j++ {
+			candidate := entries[j]
+			if _, isIn := q.waiting[candidate.UserID]; !isIn {
+				continue
+			}
+
+			diff := current.Rating - candidate.Rating
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > current.window(now) || diff > candidate.window(now) {
+				continue
+			}
+			if best == -1 || diff < bestDiff {
+				best, bestDiff = j, diff
+			}
+		}
+
+		if best != -1 {
+			delete(q.waiting, current.UserID)
+			delete(q.waiting, entries[best].UserID)
+			pairs = append(pairs, [2]QueueEntry{current, entries[best]})
+		}
+	}
+
+	return
+}
+
+// Keep pairing the players in the queue, it should run on its own goroutine
+func (q *MatchQueue) Run() {
+	var ticker = time.NewTicker(queueCheckEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.joined:
+		}
+
+		for _, pair := range q.match() {
+			if q.OnMatch != nil {
+				go q.OnMatch(pair[0], pair[1])
+			}
+		}
+	}
+}
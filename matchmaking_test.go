@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueWindow(t *testing.T) {
+	var now = time.Now()
+
+	tests := []struct {
+		waiting time.Duration
+		want    int
+	}{
+		{0, queueBaseWindow},
+		{queueWindowEvery - time.Millisecond, queueBaseWindow},
+		{queueWindowEvery, queueBaseWindow + queueWindowStep},
+		{5*queueWindowEvery + time.Second, queueBaseWindow + 5*queueWindowStep},
+	}
+	for _, test := range tests {
+		entry := QueueEntry{Since: now.Add(-test.waiting)}
+		if got := entry.window(now); got != test.want {
+			t.Errorf("window after waiting %v is %d, want %d", test.waiting, got, test.want)
+		}
+	}
+}
+
+func TestQueueMatch(t *testing.T) {
+	var now = time.Now()
+
+	// Waiting since n windows ago, the window of the player is widened n times
+	waited := func(n int) time.Time {
+		return now.Add(-time.Duration(n) * queueWindowEvery)
+	}
+
+	tests := []struct {
+		name    string
+		entries []QueueEntry
+		want    [][2]int64
+	}{
+		{
+			"alone",
+			[]QueueEntry{{UserID: 1, Rating: 1200, Since: now}},
+			nil,
+		},
+		{
+			"inside the window",
+			[]QueueEntry{{UserID: 1, Rating: 1200, Since: now.Add(-time.Second)}, {UserID: 2, Rating: 1200 + queueBaseWindow, Since: now}},
+			[][2]int64{{1, 2}},
+		},
+		{
+			"outside the window",
+			[]QueueEntry{{UserID: 1, Rating: 1200, Since: now.Add(-time.Second)}, {UserID: 2, Rating: 1201 + queueBaseWindow, Since: now}},
+			nil,
+		},
+		{
+			"window of only one widened",
+			[]QueueEntry{{UserID: 1, Rating: 1200, Since: waited(3)}, {UserID: 2, Rating: 1300, Since: now}},
+			nil,
+		},
+		{
+			"both windows widened",
+			[]QueueEntry{{UserID: 1, Rating: 1200, Since: waited(3)}, {UserID: 2, Rating: 1300, Since: waited(1)}},
+			[][2]int64{{1, 2}},
+		},
+		{
+			"closest rating",
+			[]QueueEntry{
+				{UserID: 1, Rating: 1200, Since: waited(2)},
+				{UserID: 2, Rating: 1240, Since: waited(1)},
+				{UserID: 3, Rating: 1210, Since: now},
+			},
+			[][2]int64{{1, 3}},
+		},
+		{
+			"longest waiting first",
+			[]QueueEntry{
+				{UserID: 1, Rating: 1200, Since: now},
+				{UserID: 2, Rating: 1230, Since: waited(2)},
+				{UserID: 3, Rating: 1260, Since: waited(1)},
+				{UserID: 4, Rating: 1160, Since: now.Add(-time.Second)},
+			},
+			[][2]int64{{2, 3}, {4, 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := NewMatchQueue()
+			for _, entry := range test.entries {
+				q.Join(entry)
+			}
+
+			pairs := q.match()
+			if len(pairs) != len(test.want) {
+				t.Fatalf("got %d pairs instead of %d", len(pairs), len(test.want))
+			}
+			for i, pair := range pairs {
+				if pair[0].UserID != test.want[i][0] || pair[1].UserID != test.want[i][1] {
+					t.Errorf("pair %d is %d vs %d, want %d vs %d", i, pair[0].UserID, pair[1].UserID, test.want[i][0], test.want[i][1])
+				}
+				if q.IsWaiting(pair[0].UserID) || q.IsWaiting(pair[1].UserID) {
+					t.Errorf("the players of pair %d are still in queue", i)
+				}
+			}
+		})
+	}
+}
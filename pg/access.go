@@ -5,23 +5,51 @@ import (
 	"time"
 )
 
-// Create a new creature
-func NewCreature(power, stamina, maxStamina, health uint) Creature {
+// Create a new creature with the starting stats of the ruleset (default one if nil)
+func NewCreature(rules *Ruleset) Creature {
+	return NewClassCreature(rules, NOCLASS)
+}
+
+// Create a new creature of a class, its starting stats are the ones of the ruleset (default one if nil) changed by the class
+func NewClassCreature(rules *Ruleset, class Class) Creature {
+	if rules == nil {
+		rules = DefaultRuleset()
+	}
+	profile := rules.ClassProfile(class)
 	c := Creature{
-		damage:     power,
-		stamina:    stamina,
-		maxStamina: maxStamina,
-		hp:         int(health),
+		damage:     applyOffset(rules.Stats.Damage, profile.Damage),
+		stamina:    applyOffset(rules.Stats.Stamina, profile.Stamina),
+		maxStamina: applyOffset(rules.Stats.MaxStamina, profile.Stamina),
+		hp:         int(applyOffset(rules.Stats.Health, profile.Health)),
+		items:      rules.Items.Loadout,
+		class:      class,
+		rules:      rules,
+	}
+	c.maxHp = c.hp
+	if c.stamina > c.maxStamina {
+		c.stamina = c.maxStamina
 	}
 	c.resetAction()
 	return c
 }
 
+// Get an independent copy of the creature, useful to simulate a fight without changing it
+func (c Creature) Clone() Creature {
+	c.effects = append([]effect(nil), c.effects...)
+	return c
+}
+
 // Chek if a creature is dead
 func (c Creature) IsDead() bool {
 	return c.hp <= 0
 }
 
+// Knock out the creature, used when it leaves a fight that goes on without it
+func (c *Creature) Retire() {
+	c.hp = 0
+	c.resetAction()
+}
+
 /* Two creature perform their actions aginst each other and it returns:
  * winner - a flag who indicates the winner creature (0 -> none, -1 -> draw, 1 -> c1, 2 -> c2)
  * responses - the responses of the actions performed (c1 -> responses[0], c2 -> responses[1])
@@ -57,9 +85,9 @@ func (c *Creature) SetAction(action Status) (time.Duration, error) {
 	case GUARD:
 		c.duration = time.Duration(0)
 	case ATTACK, DODGE:
-		c.duration = calcSpeed(c.stamina, c.maxStamina)
+		c.duration = calcSpeed(c.rules, c.stamina, c.maxStamina)
 	case DEFEND:
-		c.duration = 1 * time.Second
+		c.duration = time.Duration(c.rules.Durations.Defend)
 	default:
 		return time.Duration(0), errors.New("Invalid action")
 	}
@@ -68,6 +96,36 @@ func (c *Creature) SetAction(action Status) (time.Duration, error) {
 	return c.duration, nil
 }
 
+/* Creature will try to use one of the items it carries, the item is consumed only when the
+ * action is performed. Error if there are no more items of that kind
+ */
+func (c *Creature) UseItem(item Item) (time.Duration, error) {
+	if c.IsOnStatus(STUNNED) || c.IsOnStatus(EXAUSTED) {
+		return c.duration, nil
+	}
+
+	switch true {
+	case item < POTION || item > SMOKE:
+		return time.Duration(0), errors.New("Invalid item")
+	case c.items[item] == 0:
+		return time.Duration(0), errors.New("No more items of this kind")
+	}
+
+	c.action, c.item = ITEM, item
+	c.duration = time.Duration(c.rules.Durations.Item)
+	return c.duration, nil
+}
+
+// Get the class of the creature
+func (c Creature) GetClass() Class {
+	return c.class
+}
+
+// Get the items that the creature still carries and the one it is using (valid only if the action is ITEM)
+func (c Creature) GetItems() (items Loadout, using Item) {
+	return c.items, c.item
+}
+
 // Get the stats of a creature
 func (c Creature) GetInfo() (life int, agility, maxStamina, damage uint) {
 	life = c.hp
@@ -0,0 +1,115 @@
+package pg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUseItem(t *testing.T) {
+	var (
+		rules = DefaultRuleset()
+		c     = NewCreature(rules)
+	)
+
+	if items, _ := c.GetItems(); items != rules.Items.Loadout {
+		t.Fatalf("carries %v instead of %v", items, rules.Items.Loadout)
+	}
+	if duration, err := c.UseItem(TONIC); err != nil || duration != time.Duration(rules.Durations.Item) {
+		t.Errorf("using a tonic takes %v (error %v)", duration, err)
+	}
+	if _, using := c.GetItems(); !c.IsOnStatus(ITEM) || using != TONIC {
+		t.Errorf("is on %v using %v instead of using a tonic", c.action, using)
+	}
+	if _, err := c.UseItem(Item(len(c.items))); err == nil {
+		t.Error("used an item that doesn't exist")
+	}
+
+	c.items[SMOKE] = 0
+	if _, err := c.UseItem(SMOKE); err == nil {
+		t.Error("used a smoke bomb without carrying one")
+	}
+
+	// Who can't fight keeps doing nothing
+	stun(&c)
+	if c.UseItem(POTION); c.IsOnStatus(ITEM) {
+		t.Error("a stunned creature is using an item")
+	}
+}
+
+func TestPerformItem(t *testing.T) {
+	var rules = DefaultRuleset()
+
+	tests := []struct {
+		name    string
+		item    Item
+		hp      int    // health before the clash
+		stamina uint   // stamina before the clash
+		enemy   Status // action of the enemy
+		life    int    // life gained (or lost)
+		damage  int    // life lost to the hits of the enemy
+		energy  int    // stamina gained
+		used    bool   // if the item was consumed
+		effect  Status
+	}{
+		{"potion", POTION, 10, 6, GUARD, int(rules.Items.Potion), 0, 0, true, HELPLESS},
+		{"potion almost healed", POTION, 18, 6, GUARD, 2, 0, 0, true, HELPLESS},
+		{"potion while hit", POTION, 10, 6, ATTACK, int(rules.Items.Potion - rules.Stats.Damage), int(rules.Stats.Damage), 0, true, HELPLESS},
+		{"potion too late", POTION, 5, 6, ATTACK, -5, 5, 0, false, HELPLESS},
+		{"potion interrupted", POTION, 10, 6, DEFEND, 0, 0, 0, false, STUNNED},
+		{"tonic", TONIC, 20, 5, GUARD, 0, 0, int(rules.Items.Tonic), true, HELPLESS},
+		{"tonic almost full", TONIC, 20, 9, GUARD, 0, 0, 1, true, HELPLESS},
+		{"smoke against an attack", SMOKE, 20, 6, ATTACK, 0, 0, 0, true, HELPLESS},
+		{"smoke against a defense", SMOKE, 20, 6, DEFEND, 0, 0, 0, true, HELPLESS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, enemy := NewCreature(rules), NewCreature(rules)
+			c.hp, c.stamina = test.hp, test.stamina
+			c.UseItem(test.item)
+			enemy.SetAction(test.enemy)
+
+			_, responses := PerformAction(&c, &enemy)
+			res := responses[0]
+			switch true {
+			case res.Performed != ITEM && test.effect == HELPLESS, res.Item != test.item && test.used:
+				t.Errorf("performed %v with %v instead of using %v", res.Performed, res.Item, test.item)
+			case res.LifeOffset != test.life, res.StaminaOffset != test.energy:
+				t.Errorf("gained %d life and %d stamina instead of %d and %d", res.LifeOffset, res.StaminaOffset, test.life, test.energy)
+			case res.Damage != test.damage:
+				t.Errorf("took %d damage instead of %d", res.Damage, test.damage)
+			case res.GainEffect != test.effect:
+				t.Errorf("got %v instead of %v", res.GainEffect, test.effect)
+			}
+			if items, _ := c.GetItems(); (items[test.item] < rules.Items.Loadout[test.item]) != test.used {
+				t.Errorf("carries %v after the clash, consumed is %v", items, test.used)
+			}
+		})
+	}
+}
+
+func TestNewClassCreature(t *testing.T) {
+	var rules = DefaultRuleset()
+
+	tests := []struct {
+		class   Class
+		life    int
+		stamina uint
+		max     uint
+		damage  uint
+	}{
+		{NOCLASS, 20, 6, 10, 5},
+		{KNIGHT, 30, 5, 9, 5},
+		{ROGUE, 16, 8, 12, 5},
+		{BERSERKER, 20, 6, 10, 8},
+	}
+	for _, test := range tests {
+		c := NewClassCreature(rules, test.class)
+		if life, stamina, max, damage := c.GetInfo(); life != test.life || stamina != test.stamina || max != test.max || damage != test.damage {
+			t.Errorf("class %d starts with life %d, stamina %d/%d and damage %d instead of %d, %d/%d and %d",
+				test.class, life, stamina, max, damage, test.life, test.stamina, test.max, test.damage)
+		}
+		if c.GetClass() != test.class {
+			t.Errorf("class %d became %d", test.class, c.GetClass())
+		}
+	}
+}
@@ -13,6 +13,7 @@ const (
 	DEFEND
 	ATTACK
 	DODGE
+	ITEM // using one of the items carried
 )
 
 // Symptoms - Effects
@@ -21,12 +22,37 @@ const (
 	EXAUSTED
 )
 
+// Class
+type Class int8
+
+const (
+	NOCLASS   Class = iota // just the stats of the ruleset
+	KNIGHT                 // high health and strong DEFEND
+	ROGUE                  // high stamina and cheaper DODGE
+	BERSERKER              // high damage but ATTACK costs health
+)
+
+// Items
+type Item int8
+
+const (
+	POTION Item = iota // restores health points
+	TONIC              // restores stamina points
+	SMOKE              // guarantees to dodge every hit
+)
+
+// Number of items carried for every kind (indexed by Item)
+type Loadout [3]uint
+
 // Response after two creature fight each other
 type InvokeRes struct {
 	LifeOffset    int    // Difference of health points
+	Damage        int    // Health points lost to the hits of the enemies (part of LifeOffset)
+	AttackCost    int    // Health points paid by the class to attack (part of LifeOffset)
 	StaminaOffset int    // Difference of stamina points
 	GainEffect    Status // If creature got a new effect (default: HELPLESS)
 	Performed     Status // Performed action (default: HELPLESS)
+	Item          Item   // Item used (only if performed ITEM)
 }
 
 // Effect
@@ -38,10 +64,15 @@ type effect struct {
 // Creature
 type Creature struct {
 	hp         int           // health points
+	maxHp      int           // max level of health points
 	damage     uint          // (constant) max damage that is capable of dealing
 	stamina    uint          // how fast it is. It fill influence the duration
 	maxStamina uint          // max level of stamina
 	action     Status        // action he is doing
 	duration   time.Duration // duration of the action
 	effects    []effect      // list of effects
+	item       Item          // item it is using (if the action is ITEM)
+	items      Loadout       // items it still carries
+	class      Class         // class that modifies stats and actions
+	rules      *Ruleset      // values used when fighting
 }
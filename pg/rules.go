@@ -0,0 +1,257 @@
+package pg
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Ruleset collects all the values used by the combat engine
+type Ruleset struct {
+	Stats       BaseStats   `json:"stats"`       // starting stats of a creature
+	Durations   Durations   `json:"durations"`   // how long the actions take
+	Stamina     StaminaCost `json:"stamina"`     // stamina used or gained by the actions
+	Multipliers Multipliers `json:"multipliers"` // damage recived while performing an action
+	Effects     EffectTurns `json:"effects"`     // how many "turns" the effects will last
+	Items       ItemRules   `json:"items"`       // items carried and what they do
+	Classes     ClassRules  `json:"classes"`     // how every class changes stats and actions
+}
+
+// Starting stats of a creature
+type BaseStats struct {
+	Damage     uint `json:"damage"`
+	Stamina    uint `json:"stamina"`
+	MaxStamina uint `json:"max_stamina"`
+	Health     uint `json:"health"`
+}
+
+/* Duration of the actions. ATTACK and DODGE take:
+ * SpeedBase + SpeedStep * (max stamina + 1 - stamina)
+ */
+type Durations struct {
+	Defend    Duration `json:"defend"`
+	Item      Duration `json:"item"`
+	SpeedBase Duration `json:"speed_base"`
+	SpeedStep Duration `json:"speed_step"`
+}
+
+// Stamina points used or recovered when clashing
+type StaminaCost struct {
+	Attack  uint `json:"attack"`  // used when attacking
+	Dodge   uint `json:"dodge"`   // used when dodging
+	Defend  uint `json:"defend"`  // recovered when defending (if not hit)
+	Recover uint `json:"recover"` // recovered when on guard or unable to fight
+}
+
+// Multiplier applied to the enemy damage, depending on the action performed
+type Multipliers struct {
+	Attack float64 `json:"attack"` // both creatures are attacking
+	Defend float64 `json:"defend"` // defending from an attack
+	Dodge  float64 `json:"dodge"`  // dodging but slower than the attacker
+	Guard  float64 `json:"guard"`  // on guard or unable to fight
+}
+
+// Number of turns of the effects
+type EffectTurns struct {
+	Stunned  int8 `json:"stunned"`
+	Exausted int8 `json:"exausted"`
+}
+
+// Items carried by every creature and the points they restore
+type ItemRules struct {
+	Loadout Loadout `json:"loadout"` // potions, tonics and smoke bombs carried at the start
+	Potion  uint    `json:"potion"`  // health restored by a potion
+	Tonic   uint    `json:"tonic"`   // stamina restored by a tonic
+}
+
+// Stats and actions modifiers of a class, added to the values of the ruleset
+type ClassProfile struct {
+	Health     int     `json:"health"`      // added to the starting health
+	Damage     int     `json:"damage"`      // added to the damage
+	Stamina    int     `json:"stamina"`     // added to the starting and max stamina
+	Defend     float64 `json:"defend"`      // added to the damage multiplier when defending
+	DodgeCost  int     `json:"dodge_cost"`  // added to the stamina used when dodging
+	AttackCost uint    `json:"attack_cost"` // health lost every time it attacks
+}
+
+// Modifiers of every class
+type ClassRules struct {
+	Knight    ClassProfile `json:"knight"`
+	Rogue     ClassProfile `json:"rogue"`
+	Berserker ClassProfile `json:"berserker"`
+}
+
+// A time.Duration that can be written as a string (ex. "1s", "500ms") in the ruleset file
+type Duration time.Duration
+
+// Decode a duration from a string (ex. "1.5s") or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch value := raw.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return errors.New("Invalid duration")
+	}
+	return nil
+}
+
+// Encode a duration as a string (ex. "1.5s")
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Get the default ruleset, the one used when there is no ruleset file
+func DefaultRuleset() *Ruleset {
+	return &Ruleset{
+		Stats: BaseStats{
+			Damage:     5,
+			Stamina:    6,
+			MaxStamina: 10,
+			Health:     20,
+		},
+		Durations: Durations{
+			Defend:    Duration(1 * time.Second),
+			Item:      Duration(3 * time.Second),
+			SpeedBase: Duration(0),
+			SpeedStep: Duration(1 * time.Second),
+		},
+		Stamina: StaminaCost{
+			Attack:  1,
+			Dodge:   1,
+			Defend:  1,
+			Recover: 1,
+		},
+		Multipliers: Multipliers{
+			Attack: 1,
+			Defend: 0.5,
+			Dodge:  1,
+			Guard:  1,
+		},
+		Effects: EffectTurns{
+			Stunned:  1,
+			Exausted: 1,
+		},
+		Items: ItemRules{
+			Loadout: Loadout{1, 1, 1},
+			Potion:  8,
+			Tonic:   4,
+		},
+		Classes: ClassRules{
+			Knight:    ClassProfile{Health: 10, Stamina: -1, Defend: -0.25},
+			Rogue:     ClassProfile{Health: -4, Stamina: 2, DodgeCost: -1},
+			Berserker: ClassProfile{Damage: 3, AttackCost: 1},
+		},
+	}
+}
+
+/* Load a ruleset from a JSON file, or a YAML one if its extension is .yaml or .yml.
+ * Missing values are taken from the default ruleset so the file can contain only the changes
+ */
+func LoadRuleset(path string) (*Ruleset, error) {
+	var rules = DefaultRuleset()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if content, err = yamlToJSON(content); err != nil {
+			return nil, err
+		}
+	}
+	if err = json.Unmarshal(content, rules); err != nil {
+		return nil, err
+	}
+	if err = rules.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Convert a YAML document to JSON, so both formats use the same names and the same durations
+func yamlToJSON(content []byte) ([]byte, error) {
+	var raw interface{}
+
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(raw)
+}
+
+// Check if the values of a ruleset can be used by the engine
+func (r Ruleset) Validate() error {
+	switch true {
+	case r.Stats.Health == 0:
+		return errors.New("Starting health must be greater than 0")
+	case r.Stats.MaxStamina == 0:
+		return errors.New("Max stamina must be greater than 0")
+	case r.Stats.Stamina > r.Stats.MaxStamina:
+		return errors.New("Starting stamina cannot be greater than max stamina")
+	case r.Durations.Defend < 0, r.Durations.Item < 0, r.Durations.SpeedBase < 0, r.Durations.SpeedStep < 0:
+		return errors.New("Durations cannot be negative")
+	case r.Multipliers.Attack < 0, r.Multipliers.Defend < 0, r.Multipliers.Dodge < 0, r.Multipliers.Guard < 0:
+		return errors.New("Damage multipliers cannot be negative")
+	case r.Effects.Stunned < 0, r.Effects.Exausted < 0:
+		return errors.New("Effects cannot last a negative number of turns")
+	}
+
+	for _, class := range []Class{KNIGHT, ROGUE, BERSERKER} {
+		profile := r.ClassProfile(class)
+		switch true {
+		case int(r.Stats.Health)+profile.Health <= 0:
+			return errors.New("Starting health of every class must be greater than 0")
+		case int(r.Stats.MaxStamina)+profile.Stamina <= 0:
+			return errors.New("Max stamina of every class must be greater than 0")
+		case r.Multipliers.Defend+profile.Defend < 0:
+			return errors.New("Damage multipliers of every class cannot be negative")
+		}
+	}
+	return nil
+}
+
+// Get the modifiers of a class, NOCLASS (or an unknown one) has none
+func (r Ruleset) ClassProfile(class Class) ClassProfile {
+	switch class {
+	case KNIGHT:
+		return r.Classes.Knight
+	case ROGUE:
+		return r.Classes.Rogue
+	case BERSERKER:
+		return r.Classes.Berserker
+	}
+	return ClassProfile{}
+}
+
+// Add a modifier to a stat, it never goes below 0
+func applyOffset(value uint, offset int) uint {
+	if offset < 0 && uint(-offset) > value {
+		return 0
+	}
+	return uint(int(value) + offset)
+}
+
+// Calculate the damage recived using the multiplier (rounded down)
+func applyMultiplier(damage uint, multiplier float64) int {
+	return int(float64(damage) * multiplier)
+}
@@ -0,0 +1,127 @@
+package pg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadRuleset(t *testing.T) {
+	// The same changes in every format, everything else is taken from the default ruleset
+	var want = DefaultRuleset()
+	want.Stats.Health = 30
+	want.Durations.Item = Duration(1500 * time.Millisecond)
+	want.Multipliers.Defend = 0.25
+	want.Items.Loadout = Loadout{2, 0, 1}
+	want.Classes.Berserker.AttackCost = 2
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    *Ruleset
+	}{
+		{"empty json", "rules.json", "{}", DefaultRuleset()},
+		{"empty yaml", "rules.yaml", "", DefaultRuleset()},
+		{
+			"json",
+			"rules.json",
+			`{"stats": {"health": 30}, "durations": {"item": "1.5s"}, "multipliers": {"defend": 0.25},
+			"items": {"loadout": [2, 0, 1]}, "classes": {"berserker": {"attack_cost": 2}}}`,
+			want,
+		},
+		{
+			"yaml",
+			"rules.yaml",
+			"stats:\n  health: 30\ndurations:\n  item: 1.5s\nmultipliers:\n  defend: 0.25\n" +
+				"items:\n  loadout: [2, 0, 1]\nclasses:\n  berserker:\n    attack_cost: 2\n",
+			want,
+		},
+		{
+			"yml in flow style",
+			"RULES.YML",
+			`{stats: {health: 30}, durations: {item: 1500000000}, multipliers: {defend: 0.25},
+			items: {loadout: [2, 0, 1]}, classes: {berserker: {attack_cost: 2}}}`,
+			want,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			rules, err := LoadRuleset(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rules, test.want) {
+				t.Errorf("loaded %+v instead of %+v", *rules, *test.want)
+			}
+		})
+	}
+}
+
+func TestLoadRulesetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"broken json", "rules.json", `{"stats": {`},
+		{"broken yaml", "rules.yaml", "stats:\n  health: [30\n"},
+		{"yaml read as json", "rules.txt", "stats:\n  health: 30\n"},
+		{"wrong type", "rules.yml", "stats:\n  health: lots\n"},
+		{"wrong duration", "rules.json", `{"durations": {"defend": "soon"}}`},
+		{"invalid values", "rules.yaml", "stats:\n  health: 0\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if rules, err := LoadRuleset(path); err == nil {
+				t.Errorf("loaded %+v without errors", *rules)
+			}
+		})
+	}
+
+	if _, err := LoadRuleset(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a file that doesn't exist")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(r *Ruleset)
+		valid  bool
+	}{
+		{"default", func(r *Ruleset) {}, true},
+		{"no health", func(r *Ruleset) { r.Stats.Health = 0 }, false},
+		{"no max stamina", func(r *Ruleset) { r.Stats.MaxStamina = 0 }, false},
+		{"stamina over the max", func(r *Ruleset) { r.Stats.Stamina = r.Stats.MaxStamina + 1 }, false},
+		{"stamina at the max", func(r *Ruleset) { r.Stats.Stamina = r.Stats.MaxStamina }, true},
+		{"negative duration", func(r *Ruleset) { r.Durations.Item = -1 }, false},
+		{"negative multiplier", func(r *Ruleset) { r.Multipliers.Dodge = -0.5 }, false},
+		{"knight below no damage while defending", func(r *Ruleset) { r.Multipliers.Defend = 0 }, false},
+		{"negative effect", func(r *Ruleset) { r.Effects.Stunned = -1 }, false},
+		{"class without health", func(r *Ruleset) { r.Classes.Rogue.Health = -int(r.Stats.Health) }, false},
+		{"class without stamina", func(r *Ruleset) { r.Classes.Knight.Stamina = -int(r.Stats.MaxStamina) }, false},
+		{"class with a negative multiplier", func(r *Ruleset) { r.Classes.Knight.Defend = -1 }, false},
+		{"class that never gets hit defending", func(r *Ruleset) { r.Classes.Knight.Defend = -r.Multipliers.Defend }, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := DefaultRuleset()
+			test.change(rules)
+			if err := rules.Validate(); (err == nil) != test.valid {
+				t.Errorf("valid is %v instead of %v (error %v)", err == nil, test.valid, err)
+			}
+		})
+	}
+}
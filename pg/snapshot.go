@@ -0,0 +1,89 @@
+package pg
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Exported copy of a creature, used to save it and load it back
+type creatureJSON struct {
+	HP         int           `json:"hp"`
+	MaxHP      int           `json:"max_hp,omitempty"`
+	Damage     uint          `json:"damage"`
+	Stamina    uint          `json:"stamina"`
+	MaxStamina uint          `json:"max_stamina"`
+	Action     Status        `json:"action"`
+	Duration   time.Duration `json:"duration"`
+	Effects    []effectJSON  `json:"effects,omitempty"`
+	Item       Item          `json:"item,omitempty"`
+	Items      Loadout       `json:"items"`
+	Class      Class         `json:"class,omitempty"`
+}
+
+// Exported copy of an effect
+type effectJSON struct {
+	Symptom Status `json:"symptom"`
+	Turns   int8   `json:"turns"`
+}
+
+// Encode the creature (the ruleset is not included)
+func (c Creature) MarshalJSON() ([]byte, error) {
+	var raw = creatureJSON{
+		HP:         c.hp,
+		MaxHP:      c.maxHp,
+		Damage:     c.damage,
+		Stamina:    c.stamina,
+		MaxStamina: c.maxStamina,
+		Action:     c.action,
+		Duration:   c.duration,
+		Item:       c.item,
+		Items:      c.items,
+		Class:      c.class,
+	}
+
+	for _, eff := range c.effects {
+		raw.Effects = append(raw.Effects, effectJSON{Symptom: eff.symptom, Turns: eff.turns})
+	}
+	return json.Marshal(raw)
+}
+
+// Decode a creature, it will use the default ruleset untill UseRuleset is called
+func (c *Creature) UnmarshalJSON(data []byte) error {
+	var raw creatureJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*c = Creature{
+		hp:         raw.HP,
+		maxHp:      raw.MaxHP,
+		damage:     raw.Damage,
+		stamina:    raw.Stamina,
+		maxStamina: raw.MaxStamina,
+		action:     raw.Action,
+		duration:   raw.Duration,
+		item:       raw.Item,
+		items:      raw.Items,
+		class:      raw.Class,
+		rules:      DefaultRuleset(),
+	}
+	// Saved before creatures had a max health
+	if c.maxHp == 0 {
+		c.maxHp = raw.HP
+		if health := int(c.rules.Stats.Health); health > c.maxHp {
+			c.maxHp = health
+		}
+	}
+	for _, eff := range raw.Effects {
+		c.effects = append(c.effects, effect{symptom: eff.Symptom, turns: eff.Turns})
+	}
+	return nil
+}
+
+// Change the ruleset used by the creature when fighting
+func (c *Creature) UseRuleset(rules *Ruleset) {
+	if rules == nil {
+		rules = DefaultRuleset()
+	}
+	c.rules = rules
+}
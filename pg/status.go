@@ -4,29 +4,70 @@ import (
 	"time"
 )
 
-func (c *Creature) useEnergy(response *InvokeRes) (isExausted bool) {
+func (c *Creature) useEnergy(amount uint, response *InvokeRes) (isExausted bool) {
 	if c.stamina <= 0 {
 		response.GainEffect = fatigue(c)
 		return true
 	}
-	c.stamina -= 1
-	response.StaminaOffset -= 1
+	if amount > c.stamina {
+		amount = c.stamina
+	}
+	c.stamina -= amount
+	response.StaminaOffset -= int(amount)
 	return
 }
 
-func (c *Creature) gainEnergy(response *InvokeRes) {
-	if c.stamina < c.maxStamina {
-		c.stamina += 1
-		response.StaminaOffset += 1
+func (c *Creature) gainEnergy(amount uint, response *InvokeRes) {
+	if c.stamina+amount > c.maxStamina {
+		amount = c.maxStamina - c.stamina
+	}
+	c.stamina += amount
+	response.StaminaOffset += int(amount)
+}
+
+// Get the damage multiplier when defending, changed by the class
+func (c Creature) defendMultiplier() float64 {
+	if multiplier := c.rules.Multipliers.Defend + c.rules.ClassProfile(c.class).Defend; multiplier > 0 {
+		return multiplier
 	}
+	return 0
 }
 
-func calcSpeed(stamina, max uint) (speed time.Duration) {
-	seconds := max + 1 - stamina
-	speed = time.Duration(seconds) * time.Second
+// Get the stamina used when dodging, changed by the class
+func (c Creature) dodgeCost() uint {
+	return applyOffset(c.rules.Stamina.Dodge, c.rules.ClassProfile(c.class).DodgeCost)
+}
+
+// Pay the health that the class loses when attacking
+func (c *Creature) payAttack(response *InvokeRes) {
+	if cost := int(c.rules.ClassProfile(c.class).AttackCost); cost > 0 {
+		response.LifeOffset -= cost
+		response.AttackCost += cost
+		c.hp -= cost
+	}
+}
+
+func calcSpeed(rules *Ruleset, stamina, max uint) (speed time.Duration) {
+	steps := time.Duration(max + 1 - stamina)
+	speed = time.Duration(rules.Durations.SpeedBase) + steps*time.Duration(rules.Durations.SpeedStep)
 	return
 }
 
+func (c *Creature) takeDamage(damage uint, multiplier float64, response *InvokeRes) {
+	offset := -applyMultiplier(damage, multiplier)
+	response.LifeOffset += offset
+	response.Damage -= offset
+	c.hp += offset
+}
+
+func (c *Creature) heal(amount uint, response *InvokeRes) {
+	if c.hp+int(amount) > c.maxHp {
+		amount = uint(c.maxHp - c.hp)
+	}
+	c.hp += int(amount)
+	response.LifeOffset += int(amount)
+}
+
 func (c *Creature) resetAction() {
 	c.action = HELPLESS
 }
@@ -36,11 +77,11 @@ func isEffect(actionOrEffect Status) bool {
 }
 
 func isEnergyIntensive(action Status) bool {
-	return action > DEFEND
+	return action == ATTACK || action == DODGE
 }
 
 func stun(c *Creature) Status {
-	c.effects = append(c.effects, effect{symptom: STUNNED, turns: 1})
+	c.effects = append(c.effects, effect{symptom: STUNNED, turns: c.rules.Effects.Stunned})
 	c.action = HELPLESS
 	c.duration = 0 * time.Second
 
@@ -48,7 +89,7 @@ func stun(c *Creature) Status {
 }
 
 func fatigue(c *Creature) Status {
-	c.effects = append(c.effects, effect{symptom: EXAUSTED, turns: 1})
+	c.effects = append(c.effects, effect{symptom: EXAUSTED, turns: c.rules.Effects.Exausted})
 	c.action = HELPLESS
 	c.duration = 0 * time.Second
 
@@ -80,6 +121,8 @@ func (c *Creature) perform(enemy Creature) (response InvokeRes) {
 		response = c.defend(enemy)
 	case DODGE:
 		response = c.dodge(enemy)
+	case ITEM:
+		response = c.useItem(enemy)
 	case GUARD, HELPLESS:
 		response = c.sleep(enemy)
 		// I'm not sure if I should delete this or not...
@@ -100,11 +143,11 @@ func (c *Creature) perform(enemy Creature) (response InvokeRes) {
 }
 
 func (attacking *Creature) attack(enemy Creature) (response InvokeRes) {
-	attacking.useEnergy(&response)
+	attacking.useEnergy(attacking.rules.Stamina.Attack, &response)
+	attacking.payAttack(&response)
 
 	if enemy.action == ATTACK {
-		attacking.hp -= int(enemy.damage)
-		response.LifeOffset = -int(enemy.damage)
+		attacking.takeDamage(enemy.damage, attacking.rules.Multipliers.Attack, &response)
 	}
 
 	return
@@ -112,11 +155,10 @@ func (attacking *Creature) attack(enemy Creature) (response InvokeRes) {
 
 func (defending *Creature) defend(enemy Creature) (response InvokeRes) {
 	if enemy.action == ATTACK {
-		response.LifeOffset = -int(enemy.damage) / 2
-		defending.hp += response.LifeOffset
+		defending.takeDamage(enemy.damage, defending.defendMultiplier(), &response)
 		return
 	}
-	defending.gainEnergy(&response)
+	defending.gainEnergy(defending.rules.Stamina.Defend, &response)
 
 	return
 }
@@ -125,29 +167,60 @@ func (dodging *Creature) dodge(enemy Creature) (response InvokeRes) {
 	if dodging.stamina < enemy.stamina {
 		switch enemy.action {
 		case ATTACK:
-			dodging.hp -= int(enemy.damage)
-			response.LifeOffset = -int(enemy.damage)
+			dodging.takeDamage(enemy.damage, dodging.rules.Multipliers.Dodge, &response)
 		case DEFEND:
 			response.GainEffect = stun(dodging)
 		}
 	}
 
-	dodging.useEnergy(&response)
+	dodging.useEnergy(dodging.dodgeCost(), &response)
+
+	return
+}
+
+// Who's using a potion or a tonic is hit as if on guard, a smoke bomb avoids everything
+func (using *Creature) useItem(enemy Creature) (response InvokeRes) {
+	if using.item != SMOKE {
+		switch enemy.action {
+		case ATTACK:
+			using.takeDamage(enemy.damage, using.rules.Multipliers.Guard, &response)
+		case DEFEND:
+			// Being stunned interrupts it, the item is not consumed
+			response.GainEffect = stun(using)
+			return
+		}
+	}
+	using.consumeItem(&response)
 
 	return
 }
 
+// Consume the item in use, it does nothing if the creature is already dead
+func (c *Creature) consumeItem(response *InvokeRes) {
+	response.Item = c.item
+	if c.IsDead() {
+		return
+	}
+
+	c.items[c.item]--
+	switch c.item {
+	case POTION:
+		c.heal(c.rules.Items.Potion, response)
+	case TONIC:
+		c.gainEnergy(c.rules.Items.Tonic, response)
+	}
+}
+
 func (sleeping *Creature) sleep(enemy Creature) (response InvokeRes) {
 	switch enemy.action {
 	case ATTACK:
-		sleeping.hp -= int(enemy.damage)
-		response.LifeOffset = -int(enemy.damage)
+		sleeping.takeDamage(enemy.damage, sleeping.rules.Multipliers.Guard, &response)
 	case DEFEND:
 		if !sleeping.IsOnStatus(STUNNED) {
 			response.GainEffect = stun(sleeping)
 		}
 	}
-	sleeping.gainEnergy(&response)
+	sleeping.gainEnergy(sleeping.rules.Stamina.Recover, &response)
 
 	return
 }
@@ -0,0 +1,51 @@
+package pg
+
+import "testing"
+
+func TestClassActions(t *testing.T) {
+	tests := []struct {
+		name          string
+		class         Class
+		action, enemy Status
+		life          int // life lost (or gained) by the creature of the class
+		damage        int // life lost to the hits of the enemy
+		cost          int // life paid to attack
+		stamina       int // stamina used (or gained)
+		enemyDamage   int // life lost by the enemy to the hits of the class
+	}{
+		{"attack", NOCLASS, ATTACK, GUARD, 0, 0, 0, -1, 5},
+		{"berserker attack", BERSERKER, ATTACK, GUARD, -1, 0, 1, -1, 8},
+		{"berserker attack on attack", BERSERKER, ATTACK, ATTACK, -6, 5, 1, -1, 8},
+		{"berserker attack dodged", BERSERKER, ATTACK, DODGE, -1, 0, 1, -1, 0},
+		{"berserker defend", BERSERKER, DEFEND, ATTACK, -2, 2, 0, 0, 0},
+		{"defend", NOCLASS, DEFEND, ATTACK, -2, 2, 0, 0, 0},
+		{"knight defend", KNIGHT, DEFEND, ATTACK, -1, 1, 0, 0, 0},
+		{"knight defend in peace", KNIGHT, DEFEND, GUARD, 0, 0, 0, 1, 0},
+		{"dodge", NOCLASS, DODGE, GUARD, 0, 0, 0, -1, 0},
+		{"rogue dodge", ROGUE, DODGE, GUARD, 0, 0, 0, 0, 0},
+		{"rogue dodge an attack", ROGUE, DODGE, ATTACK, 0, 0, 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, enemy := NewClassCreature(nil, test.class), NewCreature(nil)
+			c.SetAction(test.action)
+			enemy.SetAction(test.enemy)
+
+			_, responses := PerformAction(&c, &enemy)
+			res := responses[0]
+			switch true {
+			case res.Performed != test.action:
+				t.Errorf("performed %v instead of %v", res.Performed, test.action)
+			case res.LifeOffset != test.life, res.Damage != test.damage, res.AttackCost != test.cost:
+				t.Errorf("life changed by %d (damage %d, cost %d) instead of %d (damage %d, cost %d)",
+					res.LifeOffset, res.Damage, res.AttackCost, test.life, test.damage, test.cost)
+			case res.StaminaOffset != test.stamina:
+				t.Errorf("stamina changed by %d instead of %d", res.StaminaOffset, test.stamina)
+			}
+			// The enemy pays nothing for the class of the creature
+			if responses[1].Damage != test.enemyDamage || responses[1].AttackCost != 0 {
+				t.Errorf("the enemy took %d damage and paid %d instead of %d", responses[1].Damage, responses[1].AttackCost, test.enemyDamage)
+			}
+		})
+	}
+}
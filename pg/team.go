@@ -0,0 +1,180 @@
+package pg
+
+// A creature taking part in a clash between teams
+type Fighter struct {
+	*Creature
+	Team   int // creatures of the same team don't hurt each other
+	Target int // index of the creature it acts against, DEFEND on an ally protects it (-1 if none)
+}
+
+/* All the creatures perform their actions in the same clash and it returns the responses of the
+ * actions performed (in the same order of the fighters). Every action is resolved looking at the
+ * creatures as they were before the clash:
+ * ATTACK - hits the target, if it is protected by an ally who's defending the ally is hit instead
+ * DEFEND - stuns the target (enemy) as in a duel or protects it (ally) taking its hits
+ * DODGE - avoids the hits of the slower attackers
+ * ITEM - hit as if on guard while using a potion or a tonic, a smoke bomb avoids everything
+ * Dead creatures don't act and can't be hit
+ */
+func PerformClash(fighters []Fighter) (responses []InvokeRes) {
+	var (
+		before    = make([]Creature, len(fighters))
+		protector = make(map[int]int)   // index of the protected creature -> index of the protector
+		hitBy     = make(map[int][]int) // index of the hit creature -> indexes of the attackers
+		stunBy    = make(map[int][]int) // index of the creature -> indexes of the enemies defending against it
+	)
+
+	for i, f := range fighters {
+		before[i] = f.Clone()
+	}
+	isEnemy := func(i, j int) bool {
+		return j >= 0 && j < len(fighters) && fighters[i].Team != fighters[j].Team && !before[j].IsDead()
+	}
+
+	// Who protects who is known before the attacks are directed
+	for i, f := range fighters {
+		j := f.Target
+		if before[i].IsDead() || before[i].action != DEFEND || j < 0 || j >= len(fighters) || j == i {
+			continue
+		}
+		if _, isProtected := protector[j]; !isProtected && fighters[j].Team == f.Team && !before[j].IsDead() {
+			protector[j] = i
+		}
+	}
+
+	for i, f := range fighters {
+		if before[i].IsDead() || !isEnemy(i, f.Target) {
+			continue
+		}
+		switch before[i].action {
+		case ATTACK:
+			target := f.Target
+			if guard, isProtected := protector[target]; isProtected {
+				target = guard
+			}
+			hitBy[target] = append(hitBy[target], i)
+		case DEFEND:
+			stunBy[f.Target] = append(stunBy[f.Target], i)
+		}
+	}
+
+	responses = make([]InvokeRes, len(fighters))
+	for i, f := range fighters {
+		if !before[i].IsDead() {
+			responses[i] = f.performInTeam(before, hitBy[i], stunBy[i])
+		}
+	}
+	for _, f := range fighters {
+		f.resetAction()
+	}
+
+	return
+}
+
+/* Get the only team with creatures still alive. over is false if more teams are still
+ * fighting, team is -1 if every creature is dead
+ */
+func TeamWinner(fighters []Fighter) (team int, over bool) {
+	team = -1
+	for _, f := range fighters {
+		if f.IsDead() {
+			continue
+		}
+		if team != -1 && team != f.Team {
+			return -1, false
+		}
+		team = f.Team
+	}
+	return team, true
+}
+
+// Perform the action of the creature against the creatures (as they were before the clash) that hit or defended against it
+func (c *Creature) performInTeam(before []Creature, hitBy, stunBy []int) (response InvokeRes) {
+	var (
+		self   = c.Clone()
+		slower = func(enemy int) bool { return self.stamina < before[enemy].stamina }
+	)
+
+	switch self.action {
+	case ATTACK:
+		c.useEnergy(c.rules.Stamina.Attack, &response)
+		c.payAttack(&response)
+		// Like in a duel an attacker is hit by whoever attacks it
+		for _, enemy := range hitBy {
+			c.takeDamage(before[enemy].damage, c.rules.Multipliers.Attack, &response)
+		}
+
+	case DEFEND:
+		for _, enemy := range hitBy {
+			c.takeDamage(before[enemy].damage, c.defendMultiplier(), &response)
+		}
+		if len(hitBy) == 0 {
+			c.gainEnergy(c.rules.Stamina.Defend, &response)
+		}
+
+	case DODGE:
+		for _, enemy := range hitBy {
+			if slower(enemy) {
+				c.takeDamage(before[enemy].damage, c.rules.Multipliers.Dodge, &response)
+			}
+		}
+		for _, enemy := range stunBy {
+			if slower(enemy) && response.GainEffect == HELPLESS {
+				response.GainEffect = stun(c)
+			}
+		}
+		c.useEnergy(c.dodgeCost(), &response)
+
+	case ITEM:
+		if self.item == SMOKE {
+			c.consumeItem(&response)
+			break
+		}
+		for _, enemy := range hitBy {
+			c.takeDamage(before[enemy].damage, c.rules.Multipliers.Guard, &response)
+		}
+		if len(stunBy) > 0 {
+			response.GainEffect = stun(c)
+		} else {
+			c.consumeItem(&response)
+		}
+
+	case GUARD, HELPLESS:
+		for _, enemy := range hitBy {
+			c.takeDamage(before[enemy].damage, c.rules.Multipliers.Guard, &response)
+		}
+		if len(stunBy) > 0 && !c.IsOnStatus(STUNNED) {
+			response.GainEffect = stun(c)
+		}
+		c.gainEnergy(c.rules.Stamina.Recover, &response)
+
+		// Same as in a duel, an helpless creature tells why it didn't act
+		if len(self.effects) > 0 {
+			if self.IsOnStatus(STUNNED) {
+				response.Performed = STUNNED
+			} else if self.IsOnStatus(EXAUSTED) {
+				response.Performed = EXAUSTED
+			}
+			c.reduceEffects()
+			return
+		}
+	}
+	c.reduceEffects()
+
+	response.Performed = self.action
+	return
+}
+
+/* Free-for-all clash where every creature is on its own: each one acts against its target
+ * (index inside creatures, -1 if none) and every pair of creature and enemy acting against
+ * it is resolved like in a duel, looking at the creatures as they were before the clash.
+ * It returns the responses of the actions performed (in the same order of the creatures)
+ */
+func PerformBrawl(creatures []*Creature, targets []int) []InvokeRes {
+	var fighters = make([]Fighter, len(creatures))
+
+	for i, c := range creatures {
+		fighters[i] = Fighter{Creature: c, Team: i, Target: targets[i]}
+	}
+	return PerformClash(fighters)
+}
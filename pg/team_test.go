@@ -0,0 +1,276 @@
+package pg
+
+import "testing"
+
+// Prepare the fighters of a clash: teams, actions and targets are in the same order
+func newFighters(teams []int, actions []Status, targets []int) []Fighter {
+	var fighters = make([]Fighter, len(teams))
+
+	for i := range fighters {
+		c := NewCreature(nil)
+		c.SetAction(actions[i])
+		fighters[i] = Fighter{Creature: &c, Team: teams[i], Target: targets[i]}
+	}
+	return fighters
+}
+
+func TestPerformClashProtectors(t *testing.T) {
+	var (
+		rules  = DefaultRuleset()
+		hit    = -int(rules.Stats.Damage)
+		shield = -applyMultiplier(rules.Stats.Damage, rules.Multipliers.Defend)
+		rested = int(rules.Stamina.Defend)
+	)
+
+	tests := []struct {
+		name    string
+		teams   []int
+		actions []Status
+		targets []int
+		dead    []int // knocked out before the clash
+		life    []int // life lost (or gained) by every fighter
+		stunned []int // fighters stunned by the clash
+	}{
+		{
+			"protector takes the hit",
+			[]int{0, 0, 1, 1},
+			[]Status{DEFEND, GUARD, ATTACK, GUARD},
+			[]int{1, 2, 1, 0},
+			nil,
+			[]int{shield, 0, 0, 0},
+			nil,
+		},
+		{
+			"unprotected ally is hit",
+			[]int{0, 0, 1, 1},
+			[]Status{GUARD, GUARD, ATTACK, GUARD},
+			[]int{1, 2, 1, 0},
+			nil,
+			[]int{0, hit, 0, 0},
+			nil,
+		},
+		{
+			"knocked out protector",
+			[]int{0, 0, 1, 1},
+			[]Status{DEFEND, GUARD, ATTACK, GUARD},
+			[]int{1, 2, 1, 0},
+			[]int{0},
+			[]int{0, hit, 0, 0},
+			nil,
+		},
+		{
+			"defending against an enemy stuns it",
+			[]int{0, 0, 1, 1},
+			[]Status{DEFEND, GUARD, GUARD, ATTACK},
+			[]int{2, 2, 0, 1},
+			nil,
+			[]int{0, hit, 0, 0},
+			[]int{2},
+		},
+		{
+			"protector hit by everyone",
+			[]int{0, 0, 1, 1},
+			[]Status{DEFEND, GUARD, ATTACK, ATTACK},
+			[]int{1, 2, 1, 0},
+			nil,
+			[]int{2 * shield, 0, 0, 0},
+			nil,
+		},
+		{
+			"only the first protector",
+			[]int{0, 0, 0, 1},
+			[]Status{DEFEND, DEFEND, GUARD, ATTACK},
+			[]int{2, 2, 3, 2},
+			nil,
+			[]int{shield, 0, 0, 0},
+			nil,
+		},
+		{
+			"protecting himself",
+			[]int{0, 0, 1, 1},
+			[]Status{DEFEND, GUARD, ATTACK, GUARD},
+			[]int{0, 2, 0, 0},
+			nil,
+			[]int{shield, 0, 0, 0},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fighters := newFighters(test.teams, test.actions, test.targets)
+			for _, i := range test.dead {
+				fighters[i].Retire()
+			}
+
+			responses := PerformClash(fighters)
+			for i, res := range responses {
+				if res.LifeOffset != test.life[i] {
+					t.Errorf("fighter %d lost %d life instead of %d", i, -res.LifeOffset, -test.life[i])
+				}
+				if stunned := res.GainEffect == STUNNED; stunned != containsIndex(test.stunned, i) {
+					t.Errorf("fighter %d got %v", i, res.GainEffect)
+				}
+			}
+
+			// A protector not hit rests like when defending in a duel
+			for i, f := range fighters {
+				if test.actions[i] == DEFEND && responses[i].LifeOffset == 0 && !containsIndex(test.dead, i) &&
+					responses[i].StaminaOffset != rested {
+					t.Errorf("protector %d recovered %d stamina instead of %d", i, responses[i].StaminaOffset, rested)
+				}
+				if f.action != HELPLESS {
+					t.Errorf("fighter %d is still on %v after the clash", i, f.action)
+				}
+			}
+		})
+	}
+}
+
+func TestTeamWinner(t *testing.T) {
+	tests := []struct {
+		name string
+		dead []int
+		team int
+		over bool
+	}{
+		{"everyone alive", nil, -1, false},
+		{"one left for each team", []int{0, 2}, -1, false},
+		{"first team won", []int{2, 3}, 0, true},
+		{"second team won", []int{0, 1}, 1, true},
+		{"draw", []int{0, 1, 2, 3}, -1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fighters := newFighters([]int{0, 0, 1, 1}, make([]Status, 4), make([]int, 4))
+			for _, i := range test.dead {
+				fighters[i].Retire()
+			}
+			if team, over := TeamWinner(fighters); team != test.team || over != test.over {
+				t.Errorf("got team %d (over %v) instead of %d (over %v)", team, over, test.team, test.over)
+			}
+		})
+	}
+}
+
+func TestPerformBrawl(t *testing.T) {
+	var (
+		rules  = DefaultRuleset()
+		hit    = -int(rules.Stats.Damage)
+		rested = int(rules.Stamina.Defend)
+	)
+
+	tests := []struct {
+		name    string
+		actions []Status
+		targets []int
+		dead    []int // knocked out before the clash
+		life    []int // life lost by every fighter
+		stunned []int // fighters stunned by the clash
+	}{
+		{
+			"attacks in a circle",
+			[]Status{ATTACK, ATTACK, ATTACK},
+			[]int{1, 2, 0},
+			nil,
+			[]int{hit, hit, hit},
+			nil,
+		},
+		{
+			"attacker not attacked",
+			[]Status{ATTACK, ATTACK, GUARD},
+			[]int{1, 2, 0},
+			nil,
+			[]int{0, hit, hit},
+			nil,
+		},
+		{
+			"everyone against one",
+			[]Status{ATTACK, ATTACK, GUARD, ATTACK},
+			[]int{2, 2, 0, 2},
+			nil,
+			[]int{0, 0, 3 * hit, 0},
+			nil,
+		},
+		{
+			"nobody protects the others",
+			[]Status{DEFEND, GUARD, ATTACK},
+			[]int{1, 0, 1},
+			nil,
+			[]int{0, hit, 0},
+			[]int{1},
+		},
+		{
+			"dead fighters don't act and can't be hit",
+			[]Status{ATTACK, ATTACK, ATTACK},
+			[]int{1, 2, 1},
+			[]int{1},
+			[]int{0, 0, 0},
+			nil,
+		},
+		{
+			"no target",
+			[]Status{ATTACK, DEFEND, GUARD},
+			[]int{-1, -1, 0},
+			nil,
+			[]int{0, 0, 0},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				teams     = make([]int, len(test.actions))
+				creatures = make([]*Creature, len(test.actions))
+			)
+
+			for i := range teams {
+				teams[i] = i
+			}
+			for i, f := range newFighters(teams, test.actions, test.targets) {
+				creatures[i] = f.Creature
+			}
+			for _, i := range test.dead {
+				creatures[i].Retire()
+			}
+
+			responses := PerformBrawl(creatures, test.targets)
+			for i, res := range responses {
+				if res.LifeOffset != test.life[i] || res.Damage != -test.life[i] {
+					t.Errorf("fighter %d lost %d life (%d damage) instead of %d", i, -res.LifeOffset, res.Damage, -test.life[i])
+				}
+				if stunned := res.GainEffect == STUNNED; stunned != containsIndex(test.stunned, i) {
+					t.Errorf("fighter %d got %v", i, res.GainEffect)
+				}
+				if containsIndex(test.dead, i) && res != (InvokeRes{}) {
+					t.Errorf("the dead fighter %d performed %+v", i, res)
+				}
+				if test.actions[i] == DEFEND && res.LifeOffset == 0 && res.StaminaOffset != rested {
+					t.Errorf("fighter %d defended recovering %d stamina instead of %d", i, res.StaminaOffset, rested)
+				}
+			}
+			if _, over := TeamWinner(newBrawlFighters(creatures)); over {
+				t.Error("the brawl is over with more creatures alive")
+			}
+		})
+	}
+}
+
+// Put every creature of a brawl on its own team
+func newBrawlFighters(creatures []*Creature) []Fighter {
+	var fighters = make([]Fighter, len(creatures))
+
+	for i, c := range creatures {
+		fighters[i] = Fighter{Creature: c, Team: i, Target: -1}
+	}
+	return fighters
+}
+
+// Check if an index is in a list
+func containsIndex(indexes []int, i int) bool {
+	for _, current := range indexes {
+		if current == i {
+			return true
+		}
+	}
+	return false
+}
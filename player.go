@@ -2,37 +2,122 @@ package main
 
 import (
 	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"DuelBot/pg"
 )
 
+// DuelRegistry keeps track of every ongoing duel and the players inside them
+type DuelRegistry struct {
+	mu      sync.RWMutex
+	duels   map[int64]*Duel    // userID -> duel he's engaged in
+	byID    map[string]*Duel   // duel ID -> duel
+	OnClash func(BattleReport) // called every time the players clash
+}
+
+// A duel between two players or between teams, every access to it is protected by its own lock
+type Duel struct {
+	sync.Mutex
+	ID           string      // unique identifier used to refer to the duel
+	Participants []int64     // userID of who started the duel and of his opponent, or of every player of a team duel
+	Teams        []int       // team of every participant, in the same order (nil if it's not a team duel, in a brawl everyone has his own)
+	Rules        *pg.Ruleset // rules used by the creatures of the players
+	Started      time.Time
+	Clashes      int           // how many clashes happened so far
+	Events       []DuelEvent   // what happened during the duel, from the oldest
+	AFKTimeout   time.Duration // how long a player can stay without moving before losing
+
+	players    map[int64]*Player
+	settings   DuelSettings
+	posted     *MessageRef      // message of the open challenge that started the duel (nil if none)
+	changes    RatingChanges    // rating changes of the players when the duel ended (nil if none)
+	winners    []int64          // players of the team that won a team duel (nil if draw or not ended)
+	spectators map[int64]int    // chatID -> message ID of the live view of who is watching
+	names      map[int64]string // userID -> name of the players of a team duel, known when it starts (nil if unknown)
+	ended      bool
+	finished   bool          // someone claimed the end and is recording the result, no more clashes
+	dirty      bool          // changed since the last time it was saved
+	saveMu     sync.Mutex    // held while saving the duel, so an old snapshot never overwrites a newer one
+	moves      chan int64    // userID of the players that changed action
+	stop       chan struct{} // closed when the duel ends
+}
+
+// Settings of a duel, choosen when inviting
+type DuelSettings struct {
+	Ranked  bool    `json:"ranked"`             // if the result will change the rating of the players
+	GroupID int64   `json:"group_id,omitempty"` // group where the invite was posted (0 if none)
+	AI      AILevel `json:"ai,omitempty"`       // difficulty of the AI opponent ("" if none)
+	BestOf  int     `json:"best_of,omitempty"`  // number of rounds of the series (0 or 1 if single duel)
+
+	Tournament bool `json:"tournament,omitempty"` // if it's a match of the tournament of the group
+	Brawl      bool `json:"brawl,omitempty"`      // if it's a free-for-all between more players
+
+	Series *SeriesScore `json:"series,omitempty"` // score of the series, nil if single duel
+}
+
 type Player struct {
 	stats    pg.Creature
 	menuID   int
 	reportID int
-	enemyID  int64
+	lastMove time.Time // last time the player tried to change action
+	warned   bool      // if he was warned that he's going to lose for inactivity
+	target   int64     // enemy his actions are against (only in team duels)
+	protect  int64     // ally protected when defending, 0 if none (only in team duels)
+}
+
+// A player that is not moving since a while
+type IdlePlayer struct {
+	UserID     int64
+	OpponentID int64
+	Idle       time.Duration // time passed since his last move
+	Timeout    time.Duration // AFK timeout of the duel
+	Warned     bool
+	Team       bool // if he's in a team duel, there he's just knocked out
+}
+
+// A participant of a team duel as seen by the other players
+type TeamMember struct {
+	UserID  int64
+	Name    string // "" if it was not known when the duel started
+	Team    int
+	Target  int64 // enemy his actions are against
+	Protect int64 // ally protected when defending (0 if none)
+	Out     bool  // knocked out or left the duel
 }
 
 type BattleReport struct {
 	EndDuel     bool
 	WinnerID    *int64
 	PlayersInfo []PlayerReport
+	Team        bool    // if it's the report of a team duel, only who was still fighting is in PlayersInfo
+	Winners     []int64 // players of the team that won a team duel (nil if draw)
 }
 
 type PlayerReport struct {
 	UserID     int64
 	LifeOff    int
+	Damage     int // life lost to the hits of the enemies, healing excluded
 	StaminaOff int
 	GainEffect *string
 	Performed  string
 	Success    bool
+	Target     int64 // who the action was against (only in team duels)
 }
 
-const defAction = pg.GUARD
+const (
+	defAction = pg.GUARD
+
+	duelIDMin   int64 = 36 * 36 * 36 * 36 * 36 // IDs of the duels are 6 chars long in base 36,
+	duelIDRange int64 = 35 * duelIDMin         // from 100000 to zzzzzz
+)
 
 var (
-	players = make(map[int64]*Player, 0)
+	duels = NewDuelRegistry()
 
 	toString = map[pg.Status]string{
 		pg.GUARD:    "GUARD",
@@ -51,203 +136,1031 @@ var (
 		"DODGE":    pg.DODGE,
 		"STUNNED":  pg.STUNNED,
 		"EXAUSTED": pg.EXAUSTED,
+		"POTION":   pg.ITEM,
+		"TONIC":    pg.ITEM,
+		"SMOKE":    pg.ITEM,
+	}
+
+	// Moves used to choose an item, in the same order of pg.Item
+	itemMoves = []string{"POTION", "TONIC", "SMOKE"}
+
+	toItem = map[string]pg.Item{
+		"POTION": pg.POTION,
+		"TONIC":  pg.TONIC,
+		"SMOKE":  pg.SMOKE,
+	}
+
+	// Names of the classes, in the same order of pg.Class
+	classNames = []string{"NOCLASS", "KNIGHT", "ROGUE", "BERSERKER"}
+
+	toClass = map[string]pg.Class{
+		"NOCLASS":   pg.NOCLASS,
+		"KNIGHT":    pg.KNIGHT,
+		"ROGUE":     pg.ROGUE,
+		"BERSERKER": pg.BERSERKER,
 	}
 )
 
-// It adds a player to the register
-func AddNewPlayer(ownerID, enemyID int64) {
-	const (
-		defAttack     = 5
-		defStamina    = 6
-		defMaxStamina = 10
-		defHealth     = 20
-	)
+// Seed the random numbers once for the whole bot, reseeding on every ID made them predictable
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
 
-	players[ownerID] = &Player{
-		stats:    pg.NewCreature(defAttack, defStamina, defMaxStamina, defHealth),
+// Create a new empty registry
+func NewDuelRegistry() *DuelRegistry {
+	return &DuelRegistry{
+		duels: make(map[int64]*Duel),
+		byID:  make(map[string]*Duel),
+	}
+}
+
+// Generate an ID that is not used by any ongoing duel (registry must be locked)
+func (r *DuelRegistry) newDuelID() (ID string) {
+	for {
+		ID = strconv.FormatInt(duelIDMin+rand.Int63n(duelIDRange), 36)
+		if r.byID[ID] == nil {
+			return
+		}
+	}
+}
+
+// It adds a player to the duel
+func (d *Duel) AddNewPlayer(ownerID int64) {
+	d.players[ownerID] = &Player{
+		stats:    pg.NewClassCreature(d.Rules, playerClass(ownerID)),
 		menuID:   -1,
 		reportID: -1,
-		enemyID:  enemyID,
+		lastMove: time.Now(),
+	}
+}
+
+// Get the opponent of a player of the duel, in team duels it's the enemy he's targeting
+func (d *Duel) Opponent(userID int64) int64 {
+	if d.isTeamDuel() {
+		return d.players[userID].target
+	}
+	if d.Participants[0] == userID {
+		return d.Participants[1]
+	}
+	return d.Participants[0]
+}
+
+// Check if the duel is between teams instead of two players
+func (d *Duel) isTeamDuel() bool {
+	return d.Teams != nil
+}
+
+// Get the team of a participant, in a duel between two players everyone is a team on his own
+func (d *Duel) team(userID int64) int {
+	for i, id := range d.Participants {
+		if id == userID && d.isTeamDuel() {
+			return d.Teams[i]
+		} else if id == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get the other players still fighting in the team of a player (allies) or against him (duel must be locked)
+func (d *Duel) others(userID int64, allies bool) (IDs []int64) {
+	var team = d.team(userID)
+
+	for _, id := range d.Participants {
+		if id != userID && !d.players[id].stats.IsDead() && (d.team(id) == team) == allies {
+			IDs = append(IDs, id)
+		}
 	}
 	return
 }
 
-// Get the message ID of the last menu
-func GetPlayerMenuID(ownerID int64) (menuID int, err error) {
-	if isPlayerExistent(ownerID) {
-		return players[ownerID].menuID, nil
+// Give a new target to the players whose target is not fighting anymore (duel must be locked)
+func (d *Duel) retarget() {
+	for _, userID := range d.Participants {
+		p := d.players[userID]
+		if enemies := d.others(userID, false); len(enemies) > 0 && !containsID(enemies, p.target) {
+			p.target = enemies[0]
+		}
+		if p.protect != 0 && !containsID(d.others(userID, true), p.protect) {
+			p.protect = 0
+		}
+	}
+}
+
+/* Get every player of the team that won a team duel, even the knocked out ones. over is
+ * false if more teams are still fighting and winners is nil if it's a draw (duel must be locked)
+ */
+func (d *Duel) teamWinners() (winners []int64, over bool) {
+	var fighters = make([]pg.Fighter, len(d.Participants))
+
+	for i, userID := range d.Participants {
+		fighters[i] = pg.Fighter{Creature: &d.players[userID].stats, Team: d.Teams[i]}
+	}
+	team, over := pg.TeamWinner(fighters)
+	for i, userID := range d.Participants {
+		if over && d.Teams[i] == team {
+			winners = append(winners, userID)
+		}
 	}
-	err = errors.New("Player does not exist")
 	return
 }
 
-// Get the message ID of the last battle report
-func GetPlayerReportID(ownerID int64) (reportID int, err error) {
-	if isPlayerExistent(ownerID) {
-		return players[ownerID].reportID, nil
+// Add an event to the log of the duel (duel must be locked)
+func (d *Duel) log(event DuelEvent) {
+	event.Time = time.Now()
+	d.Events = append(d.Events, event)
+}
+
+// Get the creatures of the participants, in the same order (duel must be locked)
+func (d *Duel) creatures() (creatures []pg.Creature) {
+	for _, userID := range d.Participants {
+		creatures = append(creatures, d.players[userID].stats.Clone())
 	}
-	err = errors.New("Player does not exist")
 	return
 }
 
-// Get the stats of a player
-func GetPlayerInfo(ownerID int64) (life int, agility, maxStamina, damage uint, err error) {
-	if isPlayerExistent(ownerID) {
-		life, agility, maxStamina, damage = players[ownerID].stats.GetInfo()
+// Get the log of the duel, used to replay it (duel must be locked)
+func (d *Duel) record() DuelLog {
+	return DuelLog{
+		ID:           d.ID,
+		Participants: d.Participants,
+		Teams:        d.Teams,
+		Rules:        d.Rules,
+		Started:      d.Started,
+		Ended:        time.Now(),
+		DuelSettings: d.settings,
+		Names:        d.names,
+		Changes:      d.changes,
+		Winners:      d.winners,
+		Events:       d.Events,
+	}
+}
+
+// Take a snapshot of the duel that can be read after unlocking it (duel must be locked)
+func (d *Duel) snapshot() DuelSnapshot {
+	var snapshot = DuelSnapshot{
+		ID:           d.ID,
+		Participants: d.Participants,
+		Teams:        d.Teams,
+		Started:      d.Started,
+		Clashes:      d.Clashes,
+		Events:       d.Events[:len(d.Events):len(d.Events)],
+		AFKTimeout:   d.AFKTimeout,
+		DuelSettings: d.settings,
+		Posted:       d.posted,
+		Names:        d.names,
+		Spectators:   make(map[int64]int, len(d.spectators)),
+	}
+
+	for chatID, messageID := range d.spectators {
+		snapshot.Spectators[chatID] = messageID
+	}
+	for _, userID := range d.Participants {
+		p := d.players[userID]
+		snapshot.Players = append(snapshot.Players, PlayerSnapshot{
+			UserID:   userID,
+			MenuID:   p.menuID,
+			ReportID: p.reportID,
+			Stats:    p.stats.Clone(),
+			Target:   p.target,
+			Protect:  p.protect,
+		})
+	}
+	return snapshot
+}
+
+/* Mark the duel as changed, it's saved on the store as soon as it's unlocked so the lock is
+ * never held while writing. The changes made meanwhile are saved together (duel must be locked)
+ */
+func (d *Duel) save() {
+	if !d.dirty {
+		d.dirty = true
+		go d.persist()
+	}
+}
+
+// Save the last snapshot of the duel on the store, an ended duel is not saved anymore
+func (d *Duel) persist() {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+
+	d.Lock()
+	if !d.dirty || d.ended {
+		d.Unlock()
 		return
 	}
-	err = errors.New("Player does not exist")
+	snapshot := d.snapshot()
+	d.dirty = false
+	d.Unlock()
+
+	if err := STORE.SaveDuel(snapshot); err != nil {
+		log.Println("persist", "SaveDuel", err)
+	}
+}
+
+// Grab the duel of a player and lock it, remember to unlock it after use
+func (r *DuelRegistry) lockDuel(userID int64) (d *Duel, err error) {
+	r.mu.RLock()
+	d = r.duels[userID]
+	r.mu.RUnlock()
+
+	if d == nil {
+		return nil, errors.New("Player does not exist or is not in a duel")
+	}
+
+	d.Lock()
+	if d.ended {
+		d.Unlock()
+		return nil, errors.New("Player does not exist or is not in a duel")
+	}
+	return d, nil
+}
+
+// Run a function with the player, holding the lock of his duel
+func (r *DuelRegistry) withPlayer(userID int64, fn func(player *Player)) error {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return err
+	}
+	defer d.Unlock()
+
+	fn(d.players[userID])
+	return nil
+}
+
+// Get the message ID of the last menu
+func (r *DuelRegistry) GetPlayerMenuID(ownerID int64) (menuID int, err error) {
+	err = r.withPlayer(ownerID, func(p *Player) {
+		menuID = p.menuID
+	})
+	return
+}
+
+// Get the message ID of the last battle report
+func (r *DuelRegistry) GetPlayerReportID(ownerID int64) (reportID int, err error) {
+	err = r.withPlayer(ownerID, func(p *Player) {
+		reportID = p.reportID
+	})
+	return
+}
+
+// Get the stats of a player
+func (r *DuelRegistry) GetPlayerInfo(ownerID int64) (life int, agility, maxStamina, damage uint, err error) {
+	err = r.withPlayer(ownerID, func(p *Player) {
+		life, agility, maxStamina, damage = p.stats.GetInfo()
+	})
+	return
+}
+
+// Get the class of the creature of a player
+func (r *DuelRegistry) GetPlayerClass(ownerID int64) (class pg.Class, err error) {
+	err = r.withPlayer(ownerID, func(p *Player) {
+		class = p.stats.GetClass()
+	})
+	return
+}
+
+// Get the items that a player still carries
+func (r *DuelRegistry) GetPlayerItems(ownerID int64) (items pg.Loadout, err error) {
+	err = r.withPlayer(ownerID, func(p *Player) {
+		items, _ = p.stats.GetItems()
+	})
 	return
 }
 
 // Get the move that a player is going to execute / has already executed
-func GetPlayerAction(ownerID int64) (move string, err error) {
-	if !isPlayerExistent(ownerID) {
-		err = errors.New("Player does not exist")
-		return
-	}
-	current, _, _ := players[ownerID].stats.GetStatus()
+func (r *DuelRegistry) GetPlayerAction(ownerID int64) (move string, err error) {
+	err = r.withPlayer(ownerID, func(p *Player) {
+		move = p.action()
+	})
+	return
+}
+
+// Get the move that the player is going to execute / has already executed
+func (p Player) action() string {
+	current, _, _ := p.stats.GetStatus()
 
 	if current == pg.HELPLESS {
-		if players[ownerID].stats.IsOnStatus(pg.STUNNED) {
+		if p.stats.IsOnStatus(pg.STUNNED) {
 			current = pg.STUNNED
-		} else if players[ownerID].stats.IsOnStatus(pg.EXAUSTED) {
+		} else if p.stats.IsOnStatus(pg.EXAUSTED) {
 			current = pg.EXAUSTED
 		}
 	}
 
-	return toString[current], nil
+	if current == pg.ITEM {
+		_, item := p.stats.GetItems()
+		return itemMoves[item]
+	}
+	return toString[current]
 }
 
-// Get the enemy chatID of a player
-func GetOpponentID(userID int64) (opponentID int64, err error) {
-	if IsPlayerBusy(userID) {
-		return players[userID].enemyID, nil
+// Get the name of the move performed in a clash, for items it's the one of the item used
+func performedMove(res pg.InvokeRes) string {
+	if res.Performed == pg.ITEM {
+		return itemMoves[res.Item]
+	}
+	return toString[res.Performed]
+}
+
+// Prepare the move on the creature, if it's the name of an item the creature uses it
+func setMove(c *pg.Creature, move string) (time.Duration, error) {
+	if item, isItem := toItem[move]; isItem {
+		return c.UseItem(item)
+	}
+	return c.SetAction(toStatus[move])
+}
+
+// Get a copy of the creature of a player and of the one of his opponent
+func (r *DuelRegistry) GetCreatures(ownerID int64) (own, enemy pg.Creature, err error) {
+	d, err := r.lockDuel(ownerID)
+	if err != nil {
+		return own, enemy, err
+	}
+	defer d.Unlock()
+
+	return d.players[ownerID].stats.Clone(), d.players[d.Opponent(ownerID)].stats.Clone(), nil
+}
+
+// Get the players still fighting against a player, in a duel between two players it's just his opponent
+func (r *DuelRegistry) GetEnemies(userID int64) (enemyIDs []int64, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Unlock()
+
+	if !d.isTeamDuel() {
+		return []int64{d.Opponent(userID)}, nil
+	}
+	return d.others(userID, false), nil
+}
+
+// Check if a player is fighting in a team duel
+func (r *DuelRegistry) IsTeamDuel(userID int64) bool {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return false
+	}
+	defer d.Unlock()
+
+	return d.isTeamDuel()
+}
+
+// Get every participant of the team duel of a player, error if it's not a team duel
+func (r *DuelRegistry) GetTeamMembers(userID int64) (members []TeamMember, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Unlock()
+
+	if !d.isTeamDuel() {
+		return nil, errors.New("Player is not in a team duel")
+	}
+	for i, id := range d.Participants {
+		p := d.players[id]
+		members = append(members, TeamMember{
+			UserID:  id,
+			Name:    d.names[id],
+			Team:    d.Teams[i],
+			Target:  p.target,
+			Protect: p.protect,
+			Out:     p.stats.IsDead(),
+		})
 	}
-	err = errors.New("Original player is not in duel")
 	return
 }
 
-// Check if a player exist and is busy on a duel or not
-func IsPlayerBusy(ownerID int64) bool {
-	if isPlayerExistent(ownerID) {
-		return players[ownerID].enemyID != 0
+/* Choose who the actions of a player of a team duel are against: an enemy becomes the target
+ * of ATTACK and DEFEND, an ally is protected when defending (choosing him again stops protecting him)
+ */
+func (r *DuelRegistry) SetTarget(userID, targetID int64) error {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return err
 	}
-	return false
+	defer d.Unlock()
+
+	if !d.isTeamDuel() {
+		return errors.New("The target can be choosen only in team duels")
+	}
+	p := d.players[userID]
+	switch true {
+	case p.stats.IsDead():
+		return errors.New("You are out of the duel")
+	case containsID(d.others(userID, false), targetID):
+		p.target = targetID
+	case containsID(d.others(userID, true), targetID) && p.protect == targetID:
+		p.protect = 0
+	case containsID(d.others(userID, true), targetID):
+		p.protect = targetID
+	default:
+		return errors.New("The player is not fighting in your duel")
+	}
+	d.save()
+	return nil
 }
 
-// Check if a player is on guard (return err if does not exist)
-func IsPlayerOnGuard(ownerID int64) (onGurad bool, err error) {
-	if IsPlayerBusy(ownerID) {
-		return players[ownerID].stats.IsOnStatus(pg.GUARD), nil
+/* Knock out a player of a team duel that fled or was inactive (EventFlee or EventTimeout),
+ * the others keep fighting without him. over is true if it was the last one of his team,
+ * in that case winners are the players of the team that won
+ */
+func (r *DuelRegistry) RetirePlayer(userID int64, kind string) (winners []int64, over bool, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer d.Unlock()
+
+	p := d.players[userID]
+	switch true {
+	case !d.isTeamDuel():
+		return nil, false, errors.New("Player is not in a team duel")
+	case d.finished:
+		return nil, false, errors.New("The duel is already over")
+	case p.stats.IsDead():
+		return nil, false, errors.New("You are already out of the duel")
+	case kind == EventTimeout && time.Since(p.lastMove) < d.AFKTimeout:
+		// He moved again after the idle players were checked
+		return nil, false, errors.New("Player is not idle anymore")
+	}
+
+	p.stats.Retire()
+	d.log(DuelEvent{Kind: kind, UserID: userID})
+	d.retarget()
+	if winners, over = d.teamWinners(); over {
+		d.winners, d.finished = winners, true
+	} else {
+		// The others might be all ready now
+		go d.notifyMove(userID)
 	}
-	err = errors.New("Player does not exist")
+	d.save()
 	return
 }
 
-// Check if a player setted his moves
-func IsPlayerReady(ownerID int64) (onGurad bool, err error) {
-	if IsPlayerBusy(ownerID) {
-		return !players[ownerID].stats.IsOnStatus(defAction), nil
+// Get the enemy chatID of a player
+func (r *DuelRegistry) GetOpponentID(userID int64) (opponentID int64, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return 0, errors.New("Original player is not in duel")
+	}
+	defer d.Unlock()
+
+	return d.Opponent(userID), nil
+}
+
+// Get the settings of the duel of a player
+func (r *DuelRegistry) GetSettings(userID int64) (settings DuelSettings, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return settings, err
+	}
+	defer d.Unlock()
+
+	return d.settings, nil
+}
+
+// Get the names of the players of the team duel of a player, the ones known when it started (nil if none)
+func (r *DuelRegistry) GetNames(userID int64) (names map[int64]string, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Unlock()
+
+	return d.names, nil
+}
+
+// Get the message of the open challenge that started the duel of a player (nil if none)
+func (r *DuelRegistry) GetPosted(userID int64) (posted *MessageRef, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Unlock()
+
+	return d.posted, nil
+}
+
+// Set the message of the open challenge that started the duel of a player
+func (r *DuelRegistry) SetPosted(userID int64, posted MessageRef) error {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return err
+	}
+	defer d.Unlock()
+
+	d.posted = &posted
+	d.save()
+	return nil
+}
+
+// Find an ongoing duel using its ID and return the first of its players, false if there is no duel
+func (r *DuelRegistry) FindDuel(ID string) (userID int64, found bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if d := r.byID[strings.ToLower(ID)]; d != nil {
+		return d.Participants[0], true
+	}
+	return 0, false
+}
+
+// Get the ID of the duel of a player, used to refer to it
+func (r *DuelRegistry) GetDuelID(userID int64) (ID string, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return "", err
+	}
+	defer d.Unlock()
+
+	return d.ID, nil
+}
+
+// Add a chat to the spectators of the duel of a player with the message of its live view
+func (r *DuelRegistry) AddSpectator(userID, chatID int64, messageID int) error {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return err
+	}
+	defer d.Unlock()
+
+	d.spectators[chatID] = messageID
+	d.save()
+	return nil
+}
+
+// Remove a chat from the spectators of the duel of a player, false if it was not watching
+func (r *DuelRegistry) RemoveSpectator(userID, chatID int64) (removed bool, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return false, err
+	}
+	defer d.Unlock()
+
+	if _, removed = d.spectators[chatID]; removed {
+		delete(d.spectators, chatID)
+		d.save()
 	}
-	err = errors.New("Player does not exist")
 	return
 }
 
-// Check if player actually exist
-func isPlayerExistent(ownerID int64) bool {
-	return players[ownerID] != nil
+// Get the spectators of the duel of a player (chatID -> message ID of the live view)
+func (r *DuelRegistry) GetSpectators(userID int64) (spectators map[int64]int, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Unlock()
+
+	spectators = make(map[int64]int, len(d.spectators))
+	for chatID, messageID := range d.spectators {
+		spectators[chatID] = messageID
+	}
+	return
+}
+
+// Check if a player exist and is busy on a duel or not
+func (r *DuelRegistry) IsPlayerBusy(ownerID int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.duels[ownerID] != nil
+}
+
+// Check if a player is on guard (return err if does not exist)
+func (r *DuelRegistry) IsPlayerOnGuard(ownerID int64) (onGurad bool, err error) {
+	err = r.withPlayer(ownerID, func(p *Player) {
+		onGurad = p.stats.IsOnStatus(pg.GUARD)
+	})
+	return
+}
+
+// Check if the player setted his moves
+func (p Player) isReady() bool {
+	return !p.stats.IsOnStatus(defAction)
 }
 
 // Check if an action was executed successfully
 func isSuccessfull(response, enemyRessponse pg.InvokeRes) bool {
 	switch response.Performed {
 	case pg.ATTACK:
-		return enemyRessponse.LifeOffset < 0
+		return enemyRessponse.Damage > 0
 	case pg.DEFEND:
-		return enemyRessponse.GainEffect != pg.HELPLESS || response.LifeOffset < 0
+		return enemyRessponse.GainEffect != pg.HELPLESS || response.Damage > 0
 	case pg.DODGE:
-		return response.LifeOffset == 0
+		return response.Damage == 0
+	case pg.ITEM:
+		// When interrupted the item is not used at all
+		return true
 	}
 
 	// in case of pg.GUARD, pg.HELPLESS, pg.STUNNED, pg.EXAUSTED:
 	return false
 }
 
+// Get the position of a player inside the report, -1 if he's not there
+func (report BattleReport) indexOf(userID int64) int {
+	for i, info := range report.PlayersInfo {
+		if info.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+/* Get the damage dealt by the i-th player of the report: the damage taken by his opponent or,
+ * in team duels, the one taken by the target of his attack
+ */
+func (report BattleReport) damageDealt(i int) int {
+	var current = report.PlayersInfo[i]
+
+	if !report.Team {
+		return report.PlayersInfo[1-i].Damage
+	}
+	if j := report.indexOf(current.Target); j != -1 && current.Performed == "ATTACK" {
+		return report.PlayersInfo[j].Damage
+	}
+	return 0
+}
+
 // Set a new value for the message ID of the menu in use
-func SetPlayerMenuID(ownerID int64, newMenuID int) (err error) {
-	if !IsPlayerBusy(ownerID) {
-		return errors.New("Player does not exist or is not in a duel")
+func (r *DuelRegistry) SetPlayerMenuID(ownerID int64, newMenuID int) error {
+	d, err := r.lockDuel(ownerID)
+	if err != nil {
+		return err
 	}
-	players[ownerID].menuID = newMenuID
-	return
+	defer d.Unlock()
+
+	d.players[ownerID].menuID = newMenuID
+	d.save()
+	return nil
 }
 
 // Set a new value for the message ID of the report in use
-func SetPlayerReportID(ownerID int64, newReportID int) (err error) {
-	if !IsPlayerBusy(ownerID) {
-		return errors.New("Player does not exist or is not in a duel")
+func (r *DuelRegistry) SetPlayerReportID(ownerID int64, newReportID int) error {
+	d, err := r.lockDuel(ownerID)
+	if err != nil {
+		return err
 	}
-	players[ownerID].reportID = newReportID
-	return
+	defer d.Unlock()
+
+	d.players[ownerID].reportID = newReportID
+	d.save()
+	return nil
 }
 
-/* Set the player moves (GUARD, ATTACK, DEFEND or DODGE) and return it's duration.
-* Error if unable to perform (STUNNED or EXAUSTED) or if the move is invalid
+/* Set the player moves (GUARD, ATTACK, DEFEND, DODGE or the name of an item) and return it's
+* duration. Error if unable to perform (STUNNED or EXAUSTED) or if the move is invalid
  */
-func SetPlayerMoves(ownerID int64, move string) (duration time.Duration, err error) {
+func (r *DuelRegistry) SetPlayerMoves(ownerID int64, move string) (duration time.Duration, err error) {
 	var action = toStatus[move]
 
 	// Chek if player is on a fight
-	if !IsPlayerBusy(ownerID) {
-		return time.Duration(0), errors.New("Player does not exist or is not in a duel")
+	d, err := r.lockDuel(ownerID)
+	if err != nil {
+		return time.Duration(0), err
+	}
+	defer d.Unlock()
+	if d.finished {
+		return time.Duration(0), errors.New("Unable to set moves, the duel is over")
+	}
+	player := d.players[ownerID]
+	player.lastMove, player.warned = time.Now(), false
+
+	// In team duels the knocked out players are still there but can't fight
+	if player.stats.IsDead() {
+		return time.Duration(0), errors.New("Unable to set moves, player is out of the duel")
 	}
 
-	// Don't do anything if is the same action
-	if players[ownerID].stats.IsOnStatus(action) {
+	// Don't do anything if is the same action (or the same item)
+	if player.stats.IsOnStatus(action) && player.action() == move {
 		return
 	}
 
 	// If player is STUNNED or EXAUSTED or some type of effects that put the pg KO
-	if players[ownerID].stats.IsOnStatus(pg.HELPLESS) {
+	if player.stats.IsOnStatus(pg.HELPLESS) {
 		return time.Duration(0), errors.New("Unable to set moves, player cannot fight")
 	}
 
 	// Setting player action
-	duration, err = players[ownerID].stats.SetAction(action)
+	duration, err = setMove(&player.stats, move)
 	if err != nil {
 		return time.Duration(0), err
 	}
+	d.log(DuelEvent{Kind: EventMove, UserID: ownerID, Move: move, Duration: duration})
 
+	// Let the scheduler know that the action is changed
+	go d.notifyMove(ownerID)
 	return
 }
 
-// It ends the duel and clean the values from the register
-func EndDuel(userID int64) error {
-	if !IsPlayerBusy(userID) {
-		return errors.New("Player is not in a duel")
+/* Get the players that are not moving since the warning time of their duel (the AI opponents
+ * are always considered active). Look at afkWarningAfter to know when they are warned
+ */
+func (r *DuelRegistry) IdlePlayers() (idle []IdlePlayer) {
+	var (
+		now     = time.Now()
+		checked = make(map[*Duel]bool)
+	)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, d := range r.duels {
+		if checked[d] {
+			continue
+		}
+		checked[d] = true
+
+		d.Lock()
+		for _, userID := range d.Participants {
+			p := d.players[userID]
+			if p.stats.IsDead() || d.finished {
+				continue
+			}
+			if elapsed := now.Sub(p.lastMove); !isAI(userID) && elapsed >= afkWarningAfter(d.AFKTimeout) {
+				idle = append(idle, IdlePlayer{
+					UserID:     userID,
+					OpponentID: d.Opponent(userID),
+					Idle:       elapsed,
+					Timeout:    d.AFKTimeout,
+					Warned:     p.warned,
+					Team:       d.isTeamDuel(),
+				})
+			}
+		}
+		d.Unlock()
 	}
-	oppID, err := GetOpponentID(userID)
+	return
+}
+
+// Remember that a player was warned that he's going to lose for inactivity
+func (r *DuelRegistry) SetWarned(userID int64) error {
+	return r.withPlayer(userID, func(p *Player) {
+		p.warned = true
+	})
+}
+
+/* Claim the end of the duel of a player, only who gets it records the result and then ends the
+ * duel. False if someone else already claimed it (even the last clash) or if one of the idle
+ * players moved again since they were checked
+ */
+func (r *DuelRegistry) ClaimEnd(userID int64, idleIDs ...int64) bool {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return false
+	}
+	defer d.Unlock()
+
+	if d.finished {
+		return false
+	}
+	for _, idleID := range idleIDs {
+		if p := d.players[idleID]; p == nil || time.Since(p.lastMove) < d.AFKTimeout {
+			return false
+		}
+	}
+	d.finished = true
+	return true
+}
+
+// Add to the log of the duel why it ended without a clash (EventFlee, EventTimeout or EventAbandon)
+func (r *DuelRegistry) LogEnd(userID int64, kind string) error {
+	d, err := r.lockDuel(userID)
 	if err != nil {
 		return err
 	}
+	defer d.Unlock()
 
-	delete(players, oppID)
-	delete(players, userID)
+	d.log(DuelEvent{Kind: kind, UserID: userID})
 	return nil
 }
 
-// Engage a duel between two players saving them on the register
-func EngageDuel(firstOwnerID, secondOwnerID int64) bool {
-	if IsPlayerBusy(firstOwnerID) || IsPlayerBusy(secondOwnerID) {
-		return false
+// Set the rating changes of the players, saved on the log when the duel ends
+func (r *DuelRegistry) SetRatingChanges(userID int64, changes RatingChanges) error {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return err
 	}
+	defer d.Unlock()
 
-	AddNewPlayer(firstOwnerID, secondOwnerID)
-	players[firstOwnerID].stats.SetAction(defAction)
-	AddNewPlayer(secondOwnerID, firstOwnerID)
-	players[secondOwnerID].stats.SetAction(defAction)
-	return true
+	d.changes = changes
+	return nil
+}
+
+/* Apply the result of the last round to the series of the duel of a player and
+ * return the updated settings. The series is copied so the settings already read elsewhere are not changed
+ */
+func (r *DuelRegistry) UpdateSeries(userID int64, update func(series *SeriesScore)) (settings DuelSettings, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return settings, err
+	}
+	defer d.Unlock()
+
+	if d.settings.Series == nil {
+		return d.settings, errors.New("Duel is not part of a series")
+	}
+	series := *d.settings.Series
+	update(&series)
+	d.settings.Series = &series
+	d.save()
+	return d.settings, nil
+}
+
+// Get the log of the ongoing duel of a player
+func (r *DuelRegistry) GetDuelLog(userID int64) (record DuelLog, err error) {
+	d, err := r.lockDuel(userID)
+	if err != nil {
+		return record, err
+	}
+	defer d.Unlock()
+
+	record = d.record()
+	record.Ended = time.Time{}
+	record.Events = append([]DuelEvent(nil), d.Events...)
+	return record, nil
+}
+
+// It ends the duel and clean the values from the register
+func (r *DuelRegistry) EndDuel(userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := r.duels[userID]
+	if d == nil {
+		return errors.New("Player is not in a duel")
+	}
+
+	d.Lock()
+	d.ended = true
+	close(d.stop)
+	for _, ownerID := range d.Participants {
+		delete(r.duels, ownerID)
+	}
+	delete(r.byID, d.ID)
+	record := d.record()
+	d.Unlock()
+
+	// The log is kept to replay the duel
+	if err := STORE.SaveDuelLog(record); err != nil {
+		log.Println("EndDuel", "SaveDuelLog", err)
+	}
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+	return STORE.DeleteDuel(d.ID)
+}
+
+/* Engage a duel with the given settings between two players saving it on the register.
+ * Error if one of them is already in a duel
+ */
+func (r *DuelRegistry) EngageDuel(firstOwnerID, secondOwnerID int64, settings DuelSettings) (*Duel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if settings.BestOf > 1 && settings.Series == nil {
+		settings.Series = newSeries(firstOwnerID, secondOwnerID)
+	}
+	return r.engage([]int64{firstOwnerID, secondOwnerID}, nil, nil, settings)
+}
+
+/* Engage a duel with the given settings between teams of players, everyone fights for the team
+ * where he's listed. names are the ones shown during the duel, so nobody has to ask Telegram for
+ * them at every update. Team duels can't be ranked. Error if one of them is already in a duel
+ */
+func (r *DuelRegistry) EngageTeamDuel(teams [][]int64, names map[int64]string, settings DuelSettings) (*Duel, error) {
+	var (
+		participants []int64
+		order        []int
+	)
+
+	for team, members := range teams {
+		for _, userID := range members {
+			participants = append(participants, userID)
+			order = append(order, team)
+		}
+	}
+	settings.Ranked, settings.BestOf, settings.Series = false, 0, nil
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.engage(participants, order, names, settings)
+}
+
+/* Engage a free-for-all brawl with the given settings between the players, everyone fights on his
+ * own and the last one standing wins. Error if one of them is already in a duel
+ */
+func (r *DuelRegistry) EngageBrawl(players []int64, names map[int64]string, settings DuelSettings) (*Duel, error) {
+	var teams = make([][]int64, len(players))
+
+	for i, userID := range players {
+		teams[i] = []int64{userID}
+	}
+	settings.Brawl = true
+	return r.EngageTeamDuel(teams, names, settings)
+}
+
+// Engage a duel between the participants saving it on the register (registry must be locked)
+func (r *DuelRegistry) engage(participants []int64, teams []int, names map[int64]string, settings DuelSettings) (*Duel, error) {
+	for _, ownerID := range participants {
+		if r.duels[ownerID] != nil {
+			return nil, errors.New("Player is already in a duel")
+		}
+	}
+
+	d := newDuel(r.newDuelID(), participants...)
+	d.Teams = teams
+	d.names = names
+	d.settings = settings
+	for _, ownerID := range d.Participants {
+		d.AddNewPlayer(ownerID)
+		d.players[ownerID].stats.SetAction(defAction)
+	}
+	if d.isTeamDuel() {
+		d.retarget()
+	}
+	d.log(DuelEvent{Kind: EventStart, Creatures: d.creatures()})
+	d.save()
+
+	for _, ownerID := range d.Participants {
+		r.duels[ownerID] = d
+	}
+	r.byID[d.ID] = d
+	go d.schedule(r.OnClash)
+	return d, nil
+}
+
+/* Put back on the register the duels saved on the snapshots and return the restored players.
+ * Every creature is put back on the default action because the pending ones are lost
+ */
+func (r *DuelRegistry) Restore(snapshots []DuelSnapshot) (userIDs []int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, snapshot := range snapshots {
+		if len(snapshot.Players) < 2 {
+			continue
+		}
+
+		// Duels saved by the old versions have no ID, they get a new one
+		if snapshot.ID == "" {
+			STORE.DeleteDuel(snapshot.Key)
+			snapshot.ID = r.newDuelID()
+			snapshot.Participants = []int64{snapshot.Players[0].UserID, snapshot.Players[1].UserID}
+			snapshot.Started = time.Now()
+		}
+
+		d := newDuel(snapshot.ID, snapshot.Participants...)
+		d.Teams = snapshot.Teams
+		d.Started = snapshot.Started
+		d.Clashes = snapshot.Clashes
+		if snapshot.AFKTimeout != 0 {
+			d.AFKTimeout = snapshot.AFKTimeout
+		}
+		d.Events = snapshot.Events
+		d.settings = snapshot.DuelSettings
+		d.posted = snapshot.Posted
+		d.names = snapshot.Names
+		for chatID, messageID := range snapshot.Spectators {
+			d.spectators[chatID] = messageID
+		}
+		for _, p := range snapshot.Players {
+			p.Stats.UseRuleset(d.Rules)
+			p.Stats.SetAction(defAction)
+			userIDs = append(userIDs, p.UserID)
+			d.players[p.UserID] = &Player{
+				stats:    p.Stats,
+				menuID:   p.MenuID,
+				reportID: p.ReportID,
+				lastMove: time.Now(),
+				target:   p.Target,
+				protect:  p.Protect,
+			}
+			r.duels[p.UserID] = d
+		}
+		d.log(DuelEvent{Kind: EventRestore})
+		r.byID[d.ID] = d
+		d.save()
+		go d.schedule(r.OnClash)
+	}
+
+	return
+}
+
+// Create a new duel without players that starts now
+func newDuel(ID string, participants ...int64) *Duel {
+	return &Duel{
+		ID:           ID,
+		Participants: participants,
+		Rules:        RULES,
+		Started:      time.Now(),
+		AFKTimeout:   AFK_TIMEOUT,
+		players:      make(map[int64]*Player, len(participants)),
+		spectators:   make(map[int64]int),
+		moves:        make(chan int64),
+		stop:         make(chan struct{}),
+	}
 }
 
 // Generating the report
@@ -258,8 +1171,9 @@ func genReport(firstOwnerID, secondOwnerID int64, winFlag int8, responses [2]pg.
 	for i, res := range responses {
 		report.PlayersInfo = append(report.PlayersInfo, PlayerReport{
 			LifeOff:    res.LifeOffset,
+			Damage:     res.Damage,
 			StaminaOff: res.StaminaOffset,
-			Performed:  toString[res.Performed],
+			Performed:  performedMove(res),
 			Success:    isSuccessfull(res, responses[1-i]),
 		})
 		// Check if during the battle a creature got a new effect
@@ -286,55 +1200,54 @@ func genReport(firstOwnerID, secondOwnerID int64, winFlag int8, responses [2]pg.
 	return report
 }
 
-// Execute the action of a player against his opponent and vice versa
-func PlayersPerformMove(ownerID, opponentID int64) (report BattleReport, err error) {
-	var (
-		ready bool
-		// Grab the action of the player and it's duration
-		action, duration, _ = players[ownerID].stats.GetStatus()
-	)
+/* Generating the report of a clash between teams, the players that were already out
+ * of the duel (dead in the input) are left out. winFlag is 0 if the duel is still going,
+ * -1 if every creature died or the team that won plus one
+ */
+func genTeamReport(order []int64, teams []int, targets []int64, input []pg.Creature, winFlag int8, responses []pg.InvokeRes) BattleReport {
+	var report = BattleReport{Team: true}
 
-	switch action {
-	case pg.DEFEND:
-		// If enemy is ready players will perform the action or else
-		ready, err = IsPlayerReady(opponentID)
-		if err != nil {
-			return
-		}
-		if !ready {
-			return
+	for i, res := range responses {
+		if input[i].IsDead() {
+			continue
 		}
-	case pg.ATTACK, pg.DODGE:
-		// Loop looking for a change in the enemy status
-		start := time.Now()
-		for time.Since(start).Milliseconds() < duration.Milliseconds() {
-			// if enemy is ready stop the loop
-			ready, err = IsPlayerReady(opponentID)
-			if err != nil {
-				return
-			}
-			if ready {
-				break
-			}
 
-			time.Sleep(time.Duration(500) * time.Millisecond)
-			// if the user change action quit this process
-			if current, _, _ := players[ownerID].stats.GetStatus(); action != current {
-				return
+		// The action was against nobody if the target is not a participant
+		var targetRes pg.InvokeRes
+		for j, userID := range order {
+			if userID == targets[i] {
+				targetRes = responses[j]
 			}
 		}
-	default:
-		return
+
+		info := PlayerReport{
+			UserID:     order[i],
+			LifeOff:    res.LifeOffset,
+			Damage:     res.Damage,
+			StaminaOff: res.StaminaOffset,
+			Performed:  performedMove(res),
+			Success:    isSuccessfull(res, targetRes),
+			Target:     targets[i],
+		}
+		if res.GainEffect != pg.HELPLESS {
+			val := toString[res.GainEffect]
+			info.GainEffect = &val
+		}
+		report.PlayersInfo = append(report.PlayersInfo, info)
 	}
 
-	// Perform the action between players and generate the BattleReport
-	winFlag, responses := pg.PerformAction(&players[ownerID].stats, &players[opponentID].stats)
-	report = genReport(ownerID, opponentID, winFlag, responses)
-	if winFlag == 0 {
-		// Set players on default action
-		players[ownerID].stats.SetAction(defAction)
-		players[opponentID].stats.SetAction(defAction)
+	switch winFlag {
+	case 0: // Match is still going...
+		return report
+	//case -1: Everyone died match is a draw
+	default:
+		for i, team := range teams {
+			if int8(team+1) == winFlag {
+				report.Winners = append(report.Winners, order[i])
+			}
+		}
 	}
+	report.EndDuel = true
 
-	return
+	return report
 }
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"DuelBot/pg"
+)
+
+// Engage a new duel between two players on a new registry, it's ended when the test is over
+func engageTest(t *testing.T, firstID, secondID int64) *DuelRegistry {
+	r := NewDuelRegistry()
+	if _, err := r.EngageDuel(firstID, secondID, DuelSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.EndDuel(firstID) })
+	return r
+}
+
+func TestClaimEnd(t *testing.T) {
+	r := engageTest(t, 1, 2)
+
+	if r.ClaimEnd(1, 1) {
+		t.Error("claimed the timeout of a player that just joined")
+	}
+	if !r.ClaimEnd(1) {
+		t.Fatal("first claim failed")
+	}
+	if r.ClaimEnd(2) {
+		t.Error("the end was claimed twice")
+	}
+	if _, err := r.SetPlayerMoves(2, "ATTACK"); err == nil {
+		t.Error("moves are accepted after the end was claimed")
+	}
+	if r.ClaimEnd(3) {
+		t.Error("claimed the end of a player that is not in a duel")
+	}
+}
+
+func TestClaimEndConcurrent(t *testing.T) {
+	var (
+		r      = engageTest(t, 1, 2)
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		claims int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			if r.ClaimEnd(userID) {
+				mu.Lock()
+				claims++
+				mu.Unlock()
+			}
+		}(int64(1 + i%2))
+	}
+	wg.Wait()
+
+	if claims != 1 {
+		t.Errorf("the end was claimed %d times", claims)
+	}
+}
+
+func TestClaimEndIdle(t *testing.T) {
+	r := engageTest(t, 1, 2)
+
+	// Going back in time is the same as not moving since then
+	r.withPlayer(1, func(p *Player) { p.lastMove = time.Now().Add(-2 * AFK_TIMEOUT) })
+	if r.ClaimEnd(1, 1, 2) {
+		t.Error("abandoned a duel where one of the players is still moving")
+	}
+	if !r.ClaimEnd(1, 1) {
+		t.Error("the timeout of an idle player was not claimed")
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	var (
+		r     = engageTest(t, 1, 2)
+		wg    sync.WaitGroup
+		moves = []string{"ATTACK", "DEFEND", "DODGE", "GUARD", "POTION"}
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				r.SetPlayerMoves(userID, moves[j%len(moves)])
+				if opponentID, err := r.GetOpponentID(userID); err == nil && opponentID != 3-userID {
+					t.Errorf("the opponent of %d is %d", userID, opponentID)
+				}
+			}
+		}(int64(1 + i%2))
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond)
+		if err := r.EndDuel(2); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	if _, err := r.GetOpponentID(1); err == nil {
+		t.Error("the duel is still going after it ended")
+	}
+	if _, err := r.SetPlayerMoves(2, "ATTACK"); err == nil {
+		t.Error("moves are accepted after the duel ended")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	var (
+		r       = engageTest(t, 1, 2)
+		reports = make(chan BattleReport, 1)
+	)
+
+	// The first player was hit and has a pending attack when the bot stops
+	d, _ := r.lockDuel(1)
+	d.players[2].stats.SetAction(pg.ATTACK)
+	d.clash(2)
+	d.Unlock()
+	r.SetPlayerMoves(1, "ATTACK")
+	r.SetPlayerMenuID(1, 10)
+	r.SetPlayerReportID(2, 20)
+	r.AddSpectator(1, -100, 30)
+
+	life, _, _, _, _ := r.GetPlayerInfo(1)
+	d, _ = r.lockDuel(1)
+	snapshot := d.snapshot()
+	d.Unlock()
+
+	// After the restart
+	restored := NewDuelRegistry()
+	restored.OnClash = func(report BattleReport) { reports <- report }
+	userIDs := restored.Restore([]DuelSnapshot{snapshot, {ID: "broken", Players: []PlayerSnapshot{{UserID: 3}}}})
+	t.Cleanup(func() { restored.EndDuel(1) })
+
+	if !reflect.DeepEqual(userIDs, []int64{1, 2}) {
+		t.Fatalf("restored the players %v", userIDs)
+	}
+	if ID, _ := restored.GetDuelID(2); ID != snapshot.ID {
+		t.Errorf("the duel is %s instead of %s", ID, snapshot.ID)
+	}
+	if move, _ := restored.GetPlayerAction(1); move != toString[defAction] {
+		t.Errorf("the pending action %s was kept", move)
+	}
+	if restoredLife, _, _, _, _ := restored.GetPlayerInfo(1); restoredLife != life {
+		t.Errorf("the first player has %d life instead of %d", restoredLife, life)
+	}
+	menuID, _ := restored.GetPlayerMenuID(1)
+	reportID, _ := restored.GetPlayerReportID(2)
+	spectators, _ := restored.GetSpectators(1)
+	if menuID != 10 || reportID != 20 || spectators[-100] != 30 {
+		t.Errorf("the messages are the menu %d, the report %d and the spectators %v", menuID, reportID, spectators)
+	}
+	if record, _ := restored.GetDuelLog(1); record.Events[len(record.Events)-1].Kind != EventRestore {
+		t.Errorf("the last event is %+v", record.Events[len(record.Events)-1])
+	}
+
+	// The duel goes on from where it was
+	restored.SetPlayerMoves(2, "DEFEND")
+	restored.SetPlayerMoves(1, "ATTACK")
+	select {
+	case report := <-reports:
+		for _, info := range report.PlayersInfo {
+			if info.UserID == 1 && info.Performed != "ATTACK" {
+				t.Errorf("the first player performed %s", info.Performed)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Error("the restored duel never clashed")
+	}
+}
+
+func TestRestoreOldDuel(t *testing.T) {
+	var r = NewDuelRegistry()
+
+	// Duels saved before the IDs had only the key and the players
+	userIDs := r.Restore([]DuelSnapshot{{Key: "1", Players: []PlayerSnapshot{{UserID: 5, Stats: pg.NewCreature(nil)}, {UserID: 6, Stats: pg.NewCreature(nil)}}}})
+	t.Cleanup(func() { r.EndDuel(5) })
+
+	if !reflect.DeepEqual(userIDs, []int64{5, 6}) {
+		t.Fatalf("restored the players %v", userIDs)
+	}
+	ID, err := r.GetDuelID(5)
+	if err != nil || len(ID) != 6 {
+		t.Errorf("the old duel got the ID %q (error %v)", ID, err)
+	}
+	if opponentID, _ := r.GetOpponentID(5); opponentID != 6 {
+		t.Errorf("the opponent of the first player is %d", opponentID)
+	}
+}
+
+func TestSpectators(t *testing.T) {
+	var r = engageTest(t, 1, 2)
+
+	r.AddSpectator(1, -100, 10)
+	r.AddSpectator(2, -200, 20)
+	// A chat watching again moves to the new live view
+	r.AddSpectator(1, -100, 11)
+
+	spectators, err := r.GetSpectators(2)
+	if err != nil || !reflect.DeepEqual(spectators, map[int64]int{-100: 11, -200: 20}) {
+		t.Fatalf("the spectators are %v (error %v)", spectators, err)
+	}
+	spectators[-300] = 30
+	if spectators, _ = r.GetSpectators(1); len(spectators) != 2 {
+		t.Error("the spectators were changed from outside")
+	}
+
+	if removed, _ := r.RemoveSpectator(1, -100); !removed {
+		t.Error("the first chat was not removed")
+	}
+	if removed, _ := r.RemoveSpectator(1, -100); removed {
+		t.Error("the first chat was removed twice")
+	}
+	if spectators, _ = r.GetSpectators(1); !reflect.DeepEqual(spectators, map[int64]int{-200: 20}) {
+		t.Errorf("after the removal the spectators are %v", spectators)
+	}
+
+	// Nobody can watch a duel that is over
+	r.EndDuel(1)
+	if err = r.AddSpectator(1, -100, 12); err == nil {
+		t.Error("added a spectator to a duel that is over")
+	}
+	if _, err = r.GetSpectators(2); err == nil {
+		t.Error("got the spectators of a duel that is over")
+	}
+}
+
+func TestDuelID(t *testing.T) {
+	var r = NewDuelRegistry()
+
+	// Every ID is 6 chars long in base 36 and never used twice by the ongoing duels
+	for i := 0; i < 1000; i++ {
+		ID := r.newDuelID()
+		if n, err := strconv.ParseInt(ID, 36, 64); err != nil || len(ID) != 6 || n < duelIDMin {
+			t.Fatalf("the ID %q is not valid (error %v)", ID, err)
+		}
+		if r.byID[ID] != nil {
+			t.Fatalf("the ID %q was generated twice", ID)
+		}
+		r.byID[ID] = &Duel{}
+	}
+}
+
+func TestEngageDuel(t *testing.T) {
+	var r = NewDuelRegistry()
+
+	d, err := r.EngageDuel(1, 2, DuelSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.EndDuel(1) })
+
+	d.Lock()
+	ID, participants, events := d.ID, d.Participants, len(d.Events)
+	d.Unlock()
+	switch true {
+	case !reflect.DeepEqual(participants, []int64{1, 2}):
+		t.Errorf("the duel is between %v", participants)
+	case events != 1:
+		t.Errorf("the duel started with %d events", events)
+	}
+	if _, err = r.EngageDuel(2, 3, DuelSettings{}); err == nil {
+		t.Error("a player is in two duels at once")
+	}
+
+	// Every clash is counted and logged
+	d.Lock()
+	d.clash(1)
+	clashes, logged := d.Clashes, len(d.Events)-events
+	d.Unlock()
+	if clashes != 1 || logged == 0 {
+		t.Errorf("after a clash the counter is %d and %d events were logged", clashes, logged)
+	}
+
+	// Both players and the ID lead to the same duel
+	for _, userID := range participants {
+		if duelID, _ := r.GetDuelID(userID); duelID != ID {
+			t.Errorf("the player %d is in the duel %s instead of %s", userID, duelID, ID)
+		}
+	}
+	if userID, found := r.FindDuel(strings.ToUpper(ID)); !found || userID != 1 {
+		t.Errorf("the duel %s was found %v with the player %d", ID, found, userID)
+	}
+
+	r.EndDuel(2)
+	if _, found := r.FindDuel(ID); found {
+		t.Error("found a duel that is over")
+	}
+	if _, err = r.GetDuelID(1); err == nil {
+		t.Error("the player is still in the duel after its end")
+	}
+}
+
+func TestBrawl(t *testing.T) {
+	var r = NewDuelRegistry()
+
+	d, err := r.EngageBrawl([]int64{1, 2, 3}, nil, DuelSettings{Ranked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.EndDuel(1) })
+
+	// Everyone is on his own and starts against somebody else
+	d.Lock()
+	teams, settings := d.Teams, d.settings
+	d.Unlock()
+	if !reflect.DeepEqual(teams, []int{0, 1, 2}) || !settings.Brawl || settings.Ranked {
+		t.Fatalf("the brawl has the teams %v and the settings %+v", teams, settings)
+	}
+	if enemyIDs, _ := r.GetEnemies(1); !reflect.DeepEqual(enemyIDs, []int64{2, 3}) {
+		t.Errorf("the enemies of the first player are %v", enemyIDs)
+	}
+
+	// The first two attack the third until he's knocked out
+	clash := func() BattleReport {
+		d.Lock()
+		defer d.Unlock()
+
+		d.players[1].stats.SetAction(pg.ATTACK)
+		d.players[2].stats.SetAction(pg.ATTACK)
+		d.players[1].target, d.players[2].target, d.players[3].target = 3, 3, 1
+		return d.clashTeams()
+	}
+	report := clash()
+	if !report.Team || report.EndDuel || len(report.PlayersInfo) != 3 {
+		t.Fatalf("the first clash is %+v", report)
+	}
+	for _, info := range report.PlayersInfo {
+		switch true {
+		case info.UserID == 3 && (info.Damage != 10 || info.Performed != "GUARD"):
+			t.Errorf("the third player got %+v", info)
+		case info.UserID != 3 && (info.Damage != 0 || !info.Success || info.Target != 3):
+			t.Errorf("an attacker got %+v", info)
+		}
+	}
+	clash()
+	if report = clash(); len(report.PlayersInfo) != 2 || report.EndDuel {
+		t.Errorf("after the third player was knocked out the clash is %+v", report)
+	}
+
+	// The last one standing wins
+	if _, over, _ := r.RetirePlayer(1, EventFlee); !over {
+		t.Fatal("the brawl goes on with a player left")
+	}
+	if record, _ := r.GetDuelLog(1); !reflect.DeepEqual(record.Winners, []int64{2}) {
+		t.Errorf("the brawl was won by %v", record.Winners)
+	}
+}
+
+func TestBrawlWinFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		dead []int
+		want int8
+	}{
+		{"everyone alive", nil, 0},
+		{"two left", []int{1}, 0},
+		{"last one standing", []int{0, 2}, 2},
+		{"everyone dead", []int{0, 1, 2}, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				fighters = make([]pg.Fighter, 3)
+				input    = make([]pg.Creature, 3)
+			)
+
+			for i := range fighters {
+				c := pg.NewCreature(nil)
+				if containsIndex(test.dead, i) {
+					c.Retire()
+				}
+				input[i] = c
+				fighters[i] = pg.Fighter{Creature: &c, Team: i, Target: (i + 1) % 3}
+			}
+			responses := performTeams(fighters, true)
+			winFlag := teamWinFlag(fighters)
+			if winFlag != test.want {
+				t.Fatalf("got the flag %d instead of %d", winFlag, test.want)
+			}
+
+			report := genTeamReport([]int64{1, 2, 3}, []int{0, 1, 2}, []int64{2, 3, 1}, input, winFlag, responses)
+			if len(report.PlayersInfo) != 3-len(test.dead) || report.EndDuel != (winFlag != 0) {
+				t.Errorf("the report is %+v", report)
+			}
+			// The players are numbered like the flags of their teams
+			if winFlag > 0 && !reflect.DeepEqual(report.Winners, []int64{int64(winFlag)}) {
+				t.Errorf("the brawl was won by %v", report.Winners)
+			}
+		})
+	}
+}
+
+// Check if an index is in a list
+func containsIndex(indexes []int, i int) bool {
+	for _, current := range indexes {
+		if current == i {
+			return true
+		}
+	}
+	return false
+}
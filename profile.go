@@ -0,0 +1,217 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"DuelBot/pg"
+)
+
+// Lifetime statistics of a player
+type Profile struct {
+	UserID      int64          `json:"user_id"`
+	Wins        int            `json:"wins"`
+	Losses      int            `json:"losses"`
+	Draws       int            `json:"draws"`
+	Flees       int            `json:"flees"`
+	Rating      int            `json:"rating"` // Elo rating, changed only by ranked duels
+	Ranked      int            `json:"ranked"` // number of ranked duels played
+	DamageDealt int            `json:"damage_dealt"`
+	DamageTaken int            `json:"damage_taken"`
+	Performed   map[string]int `json:"performed"`       // action -> times it was performed
+	Succeeded   map[string]int `json:"succeeded"`       // action -> times it was performed successfully
+	Class       string         `json:"class,omitempty"` // class chosen for the next duels
+}
+
+// Actions that can be choosen by the players, used for the statistics
+var mainActions = []string{"GUARD", "ATTACK", "DEFEND", "DODGE"}
+
+// Used to avoid concurrent updates of the same profile
+var profilesMu sync.Mutex
+
+// Get the profile of a player, an empty one if he never played
+func GetProfile(userID int64) Profile {
+	profile, err := STORE.LoadProfile(userID)
+	if err != nil {
+		log.Println("GetProfile", "LoadProfile", err)
+	}
+	if profile == nil {
+		profile = &Profile{UserID: userID}
+	}
+	if profile.Rating == 0 {
+		profile.Rating = defRating
+	}
+	if profile.Performed == nil {
+		profile.Performed = make(map[string]int)
+	}
+	if profile.Succeeded == nil {
+		profile.Succeeded = make(map[string]int)
+	}
+
+	return *profile
+}
+
+// Modify the profile of a player and save it
+func updateProfile(userID int64, edit func(profile *Profile)) {
+	updateProfiles([]int64{userID}, func(profiles []*Profile) {
+		edit(profiles[0])
+	})
+}
+
+// Modify the profiles of some players all at once and save them
+func updateProfiles(userIDs []int64, edit func(profiles []*Profile)) {
+	var profiles []*Profile
+
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	for _, userID := range userIDs {
+		profile := GetProfile(userID)
+		profiles = append(profiles, &profile)
+	}
+	edit(profiles)
+	for _, profile := range profiles {
+		// AI opponents have no profile
+		if isAI(profile.UserID) {
+			continue
+		}
+		if err := STORE.SaveProfile(*profile); err != nil {
+			log.Println("updateProfiles", "SaveProfile", err)
+			continue
+		}
+		updateLeaderboard(*profile)
+	}
+}
+
+// Add the result of a clash to the statistics of the players
+func RecordClash(report BattleReport) {
+	for i, current := range report.PlayersInfo {
+		dealt := report.damageDealt(i)
+		updateProfile(current.UserID, func(profile *Profile) {
+			profile.DamageTaken += current.Damage
+			profile.DamageDealt += dealt
+			profile.Performed[current.Performed]++
+			if current.Success {
+				profile.Succeeded[current.Performed]++
+			}
+		})
+	}
+}
+
+/* Add the end of a duel to the statistics of the players (winnerID == nil if draw)
+ * and if the duel is ranked it update their rating returning the changes.
+ * Practice duels against the AI are left out
+ */
+func RecordEndDuel(winnerID *int64, firstID, secondID int64, settings DuelSettings) (changes RatingChanges) {
+	if settings.AI != "" {
+		return
+	}
+
+	updateProfiles([]int64{firstID, secondID}, func(profiles []*Profile) {
+		var score = 0.5
+
+		for _, profile := range profiles {
+			switch true {
+			case winnerID == nil:
+				profile.Draws++
+			case *winnerID == profile.UserID:
+				profile.Wins++
+			default:
+				profile.Losses++
+			}
+		}
+
+		if settings.Ranked {
+			if winnerID != nil && *winnerID == firstID {
+				score = 1
+			} else if winnerID != nil {
+				score = 0
+			}
+			changes = updateRatings(profiles[0], profiles[1], score)
+		}
+	})
+
+	if settings.GroupID != 0 {
+		recordGroupResult(settings.GroupID, winnerID, firstID, secondID)
+	}
+	return
+}
+
+// Add the end of a team duel to the statistics of the players (winners is nil if draw), they are never ranked
+func RecordEndTeamDuel(winners, participants []int64) {
+	updateProfiles(participants, func(profiles []*Profile) {
+		for _, profile := range profiles {
+			switch true {
+			case winners == nil:
+				profile.Draws++
+			case containsID(winners, profile.UserID):
+				profile.Wins++
+			default:
+				profile.Losses++
+			}
+		}
+	})
+}
+
+/* Add the withdrawn of a player to the statistics of both players,
+ * if the duel is ranked the fleeing player lose the rating points.
+ * Practice duels against the AI are left out
+ */
+func RecordFlee(fleeingID, opponentID int64, settings DuelSettings) (changes RatingChanges) {
+	if settings.AI != "" {
+		return
+	}
+
+	updateProfiles([]int64{fleeingID, opponentID}, func(profiles []*Profile) {
+		profiles[0].Flees++
+		profiles[1].Wins++
+		if settings.Ranked {
+			changes = updateRatings(profiles[0], profiles[1], 0)
+		}
+	})
+
+	if settings.GroupID != 0 {
+		recordGroupResult(settings.GroupID, &opponentID, fleeingID, opponentID)
+	}
+	return
+}
+
+// Change the class used by a player in his next duels
+func SetClass(userID int64, class string) {
+	updateProfile(userID, func(profile *Profile) {
+		profile.Class = class
+	})
+}
+
+// Get the class chosen by a player for his next duels, AI opponents have none
+func playerClass(userID int64) pg.Class {
+	if isAI(userID) {
+		return pg.NOCLASS
+	}
+	return toClass[GetProfile(userID).Class]
+}
+
+// Get the action performed the most by the player ("" if none)
+func (p Profile) FavouriteAction() (favourite string) {
+	var max int
+
+	for _, action := range mainActions {
+		if p.Performed[action] > max {
+			favourite, max = action, p.Performed[action]
+		}
+	}
+	return
+}
+
+// Get the percentage of times an action was performed successfully (-1 if never performed)
+func (p Profile) SuccessRate(action string) int {
+	if p.Performed[action] == 0 {
+		return -1
+	}
+	return p.Succeeded[action] * 100 / p.Performed[action]
+}
+
+// Get the number of duels played
+func (p Profile) Played() int {
+	return p.Wins + p.Losses + p.Draws + p.Flees
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"DuelBot/pg"
+)
+
+func TestRecordClash(t *testing.T) {
+	const firstID, secondID = 2001, 2002
+
+	tests := []struct {
+		name          string
+		class         pg.Class // class of the first player
+		first, second pg.Status
+		item          pg.Item // used by the first player if he performs ITEM
+		dealt, taken  int     // damage dealt and taken by the first player
+	}{
+		{"attack on guard", pg.NOCLASS, pg.ATTACK, pg.GUARD, pg.POTION, 5, 0},
+		{"both attack", pg.NOCLASS, pg.ATTACK, pg.ATTACK, pg.POTION, 5, 5},
+		{"potion while hit", pg.NOCLASS, pg.ITEM, pg.ATTACK, pg.POTION, 0, 5},
+		{"potion in peace", pg.NOCLASS, pg.ITEM, pg.GUARD, pg.POTION, 0, 0},
+		{"smoke", pg.NOCLASS, pg.ITEM, pg.ATTACK, pg.SMOKE, 0, 0},
+		{"berserker attack on guard", pg.BERSERKER, pg.ATTACK, pg.GUARD, pg.POTION, 8, 0},
+		{"berserker both attack", pg.BERSERKER, pg.ATTACK, pg.ATTACK, pg.POTION, 8, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, second := pg.NewClassCreature(nil, test.class), pg.NewCreature(nil)
+
+			// Hurt the first player before, so the potion heals for real
+			second.SetAction(pg.ATTACK)
+			pg.PerformAction(&first, &second)
+
+			if test.first == pg.ITEM {
+				first.UseItem(test.item)
+			} else {
+				first.SetAction(test.first)
+			}
+			second.SetAction(test.second)
+
+			winFlag, responses := pg.PerformAction(&first, &second)
+			report := genReport(firstID, secondID, winFlag, responses)
+			if dealt, taken := report.damageDealt(0), report.damageDealt(1); dealt != test.dealt || taken != test.taken {
+				t.Fatalf("dealt %d and took %d damage instead of %d and %d", dealt, taken, test.dealt, test.taken)
+			}
+			// The health paid to attack is not a hit of the enemy
+			if enemy := report.PlayersInfo[1]; enemy.Performed == "ATTACK" && enemy.Success != (test.taken > 0) {
+				t.Errorf("the attack of the enemy succeeded is %v, but it dealt %d damage", enemy.Success, test.taken)
+			}
+
+			before := []Profile{GetProfile(firstID), GetProfile(secondID)}
+			RecordClash(report)
+			after := []Profile{GetProfile(firstID), GetProfile(secondID)}
+			switch true {
+			case after[0].DamageDealt-before[0].DamageDealt != test.dealt, after[0].DamageTaken-before[0].DamageTaken != test.taken:
+				t.Errorf("the profile of the first player went from %+v to %+v", before[0], after[0])
+			case after[1].DamageDealt-before[1].DamageDealt != test.taken, after[1].DamageTaken-before[1].DamageTaken != test.dealt:
+				t.Errorf("the profile of the second player went from %+v to %+v", before[1], after[1])
+			}
+		})
+	}
+}
+
+func TestRecordPractice(t *testing.T) {
+	const userID, aiID = 2003, aiIDBase - 1
+
+	var (
+		before         = GetProfile(userID)
+		userWon, aiWon = int64(userID), int64(aiID)
+	)
+
+	for _, winnerID := range []*int64{nil, &userWon, &aiWon} {
+		if changes := RecordEndDuel(winnerID, userID, aiID, DuelSettings{AI: AIRandom}); changes != nil {
+			t.Errorf("a practice duel changed the ratings: %v", changes)
+		}
+	}
+	RecordFlee(userID, aiID, DuelSettings{AI: AIReactive})
+
+	if after := GetProfile(userID); after.Wins != before.Wins || after.Losses != before.Losses || after.Draws != before.Draws || after.Flees != before.Flees {
+		t.Errorf("practice against the AI changed the record from %+v to %+v", before, after)
+	}
+}
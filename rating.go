@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defRating = 1200 // rating of a new player
+	eloFactor = 32   // max number of points that can be won or lost in a single duel
+)
+
+// Rating points gained or lost by the players after a ranked duel (userID -> points)
+type RatingChanges map[int64]int
+
+// Get the probability (0 - 1) that a player will win against his opponent
+func expectedScore(rating, opponentRating int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponentRating-rating)/400))
+}
+
+/* Update the Elo ratings of two players and return the changes.
+ * score is the result of the first player (1 -> win, 0.5 -> draw, 0 -> lost)
+ */
+func updateRatings(first, second *Profile, score float64) RatingChanges {
+	delta := int(math.Round(eloFactor * (score - expectedScore(first.Rating, second.Rating))))
+
+	first.Rating += delta
+	second.Rating -= delta
+	first.Ranked++
+	second.Ranked++
+
+	return RatingChanges{first.UserID: delta, second.UserID: -delta}
+}
+
+// Generate the line with the new rating of the player and the change (empty if not ranked)
+func genRatingLine(userID int64, changes RatingChanges) string {
+	delta, ok := changes[userID]
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("\n🏅 Rating: <code>%d</code> (%+d)", GetProfile(userID).Rating, delta)
+}
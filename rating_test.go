@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestExpectedScore(t *testing.T) {
+	tests := []struct {
+		rating, opponent int
+		want             float64
+	}{
+		{1200, 1200, 0.5},
+		{1600, 1200, 0.909},
+		{1200, 1600, 0.091},
+		{1400, 1200, 0.760},
+		{2000, 1000, 0.997},
+	}
+	for _, test := range tests {
+		got := expectedScore(test.rating, test.opponent)
+		if math.Abs(got-test.want) > 0.001 {
+			t.Errorf("expectedScore(%d, %d) = %.3f, want %.3f", test.rating, test.opponent, got, test.want)
+		}
+		if sum := got + expectedScore(test.opponent, test.rating); math.Abs(sum-1) > 1e-9 {
+			t.Errorf("scores of %d and %d add up to %f", test.rating, test.opponent, sum)
+		}
+	}
+}
+
+func TestUpdateRatings(t *testing.T) {
+	tests := []struct {
+		name             string
+		first, second    int
+		score            float64
+		wantFirst, delta int
+	}{
+		{"even win", 1200, 1200, 1, 1216, 16},
+		{"even draw", 1200, 1200, 0.5, 1200, 0},
+		{"even loss", 1200, 1200, 0, 1184, -16},
+		{"favourite wins", 1600, 1200, 1, 1603, 3},
+		{"underdog wins", 1200, 1600, 1, 1229, 29},
+		{"underdog draws", 1200, 1600, 0.5, 1213, 13},
+		{"sure win", 3000, 1000, 1, 3000, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first := Profile{UserID: 1, Rating: test.first}
+			second := Profile{UserID: 2, Rating: test.second}
+			changes := updateRatings(&first, &second, test.score)
+
+			if first.Rating != test.wantFirst || changes[1] != test.delta || changes[2] != -test.delta {
+				t.Errorf("rating %d and changes %v, want %d and %+d", first.Rating, changes, test.wantFirst, test.delta)
+			}
+			// Points are never created nor lost
+			if first.Rating+second.Rating != test.first+test.second {
+				t.Errorf("ratings went from %d to %d in total", test.first+test.second, first.Rating+second.Rating)
+			}
+			if first.Ranked != 1 || second.Ranked != 1 {
+				t.Errorf("ranked duels are %d and %d instead of 1", first.Ranked, second.Ranked)
+			}
+		})
+	}
+}
+
+func TestRecordRanked(t *testing.T) {
+	const winnerID, loserID = 1001, 1002
+
+	// The profiles could be already on the store, only what changes is checked
+	winner, loser := GetProfile(winnerID), GetProfile(loserID)
+	winnerPtr := int64(winnerID)
+
+	if changes := RecordEndDuel(&winnerPtr, winnerID, loserID, DuelSettings{}); changes != nil {
+		t.Errorf("a friendly duel changed the ratings: %v", changes)
+	}
+	if p := GetProfile(winnerID); p.Rating != winner.Rating || p.Wins != winner.Wins+1 || p.Ranked != winner.Ranked {
+		t.Errorf("after a friendly win the profile went from %+v to %+v", winner, p)
+	}
+
+	want := updateRatings(&loser, &winner, 0)
+	changes := RecordEndDuel(&winnerPtr, loserID, winnerID, DuelSettings{Ranked: true})
+	if !reflect.DeepEqual(changes, want) || changes[winnerID] <= 0 {
+		t.Errorf("a ranked win changed the ratings by %v instead of %v", changes, want)
+	}
+	if p := GetProfile(loserID); p.Rating != loser.Rating || p.Ranked != loser.Ranked {
+		t.Errorf("after a ranked loss the profile is %+v instead of %+v", p, loser)
+	}
+
+	// Who flees loses like he was defeated
+	want = updateRatings(&loser, &winner, 0)
+	changes = RecordFlee(loserID, winnerID, DuelSettings{Ranked: true})
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("fleeing changed the ratings by %v instead of %v", changes, want)
+	}
+	if p := GetProfile(loserID); p.Rating != loser.Rating || p.Flees != loser.Flees+1 {
+		t.Errorf("after fleeing the profile is %+v", p)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"DuelBot/pg"
+)
+
+// Kind of the events of a duel
+const (
+	EventStart   = "start"   // duel started, with the creatures of the participants
+	EventRestore = "restore" // duel restored after a restart, pending actions are lost
+	EventMove    = "move"    // a player changed his action
+	EventClash   = "clash"   // the players clashed, with the creatures before performing
+	EventEffect  = "effect"  // a player gained an effect during the last clash
+	EventFlee    = "flee"    // a player fled from the duel, in team duels he's just knocked out
+	EventTimeout = "timeout" // a player lost because he didn't move for too long (knocked out in team duels)
+	EventAbandon = "abandon" // both players didn't move for too long, nobody won
+)
+
+// Something that happened during a duel
+type DuelEvent struct {
+	Time      time.Time     `json:"time"`
+	Kind      string        `json:"kind"`
+	UserID    int64         `json:"user_id,omitempty"`   // who moved or gained the effect
+	Move      string        `json:"move,omitempty"`      // action choosen
+	Duration  time.Duration `json:"duration,omitempty"`  // duration of the action choosen
+	Effect    string        `json:"effect,omitempty"`    // effect gained
+	Creatures []pg.Creature `json:"creatures,omitempty"` // creatures at the start or before the clash
+	Clash     *ClashEvent   `json:"clash,omitempty"`
+	Report    *BattleReport `json:"report,omitempty"` // report sent to the players after the clash
+}
+
+// Input and result of pg.PerformAction (or of pg.PerformClash in team duels) during a clash
+type ClashEvent struct {
+	Order     []int64        `json:"order"`             // userIDs of the creatures, in team duels all the participants
+	Targets   []int64        `json:"targets,omitempty"` // who every creature acted against (only in team duels)
+	Winner    int8           `json:"winner"`            // in team duels it's the team that won plus one
+	Responses []pg.InvokeRes `json:"responses"`
+}
+
+// Everything that is needed to replay an ended duel
+type DuelLog struct {
+	ID           string      `json:"id"`
+	Participants []int64     `json:"participants"`
+	Teams        []int       `json:"teams,omitempty"` // team of every participant (nil if not a team duel)
+	Rules        *pg.Ruleset `json:"rules"`
+	Started      time.Time   `json:"started"`
+	Ended        time.Time   `json:"ended"`
+	DuelSettings
+	Names   map[int64]string `json:"names,omitempty"`   // name of the players of a team duel when it started
+	Changes RatingChanges    `json:"changes,omitempty"` // nil if the duel was not ranked
+	Winners []int64          `json:"winners,omitempty"` // team that won a team duel (nil if draw)
+	Events  []DuelEvent      `json:"events"`
+}
+
+/* Get how the duel ended looking at the last events: the winner (nil if draw, abandoned or
+ * not ended), the player that fled or was inactive (0 if none) and if the duel is over.
+ * In team duels the winner is the first of the winning team, use Won to check the others
+ */
+func (record DuelLog) Outcome() (winnerID *int64, fleeingID int64, over bool) {
+	if record.Teams != nil {
+		if len(record.Winners) != 0 {
+			winnerID = &record.Winners[0]
+		}
+		return winnerID, 0, !record.Ended.IsZero()
+	}
+
+	for _, event := range record.Events {
+		switch true {
+		case event.Kind == EventClash && event.Report != nil && event.Report.EndDuel:
+			return event.Report.WinnerID, 0, true
+		case event.Kind == EventFlee, event.Kind == EventTimeout:
+			winnerID := record.Opponent(event.UserID)
+			return &winnerID, event.UserID, true
+		case event.Kind == EventAbandon:
+			return nil, 0, true
+		}
+	}
+	return nil, 0, false
+}
+
+// Check if the duel ended without a clash for the given reason (EventFlee, EventTimeout or EventAbandon)
+func (record DuelLog) EndedBy(kind string) bool {
+	return len(record.Events) != 0 && record.Events[len(record.Events)-1].Kind == kind
+}
+
+// Get the opponent of a player of the duel
+func (record DuelLog) Opponent(userID int64) int64 {
+	if record.Participants[0] == userID {
+		return record.Participants[1]
+	}
+	return record.Participants[0]
+}
+
+// Get the players that fought against a player, in a duel between two players it's just his opponent
+func (record DuelLog) Enemies(userID int64) (enemyIDs []int64) {
+	if record.Teams == nil {
+		return []int64{record.Opponent(userID)}
+	}
+
+	var team = -1
+	for i, id := range record.Participants {
+		if id == userID {
+			team = record.Teams[i]
+		}
+	}
+	for i, id := range record.Participants {
+		if record.Teams[i] != team {
+			enemyIDs = append(enemyIDs, id)
+		}
+	}
+	return
+}
+
+// Check if a player was in the team that won a team duel
+func (record DuelLog) Won(userID int64) bool {
+	return containsID(record.Winners, userID)
+}
+
+// Get the reports of all the clashes of the duel, from the first
+func (record DuelLog) Reports() (reports []BattleReport) {
+	for _, event := range record.Events {
+		if event.Kind == EventClash && event.Report != nil {
+			reports = append(reports, *event.Report)
+		}
+	}
+	return
+}
+
+/* Feed the events of the log back into the combat engine and check that every clash
+ * has the same outcome. It returns the reports of all the clashes, or an error
+ * describing the first event that was not reproduced
+ */
+func Replay(record DuelLog) (reports []BattleReport, err error) {
+	var creatures = make(map[int64]*pg.Creature, 2)
+
+	mismatch := func(i int, format string, a ...interface{}) error {
+		return fmt.Errorf("event %d (%s): %s", i, record.Events[i].Kind, fmt.Sprintf(format, a...))
+	}
+
+	for i, event := range record.Events {
+		if event.Kind != EventStart && len(creatures) == 0 {
+			return reports, mismatch(i, "the duel was never started")
+		}
+
+		switch event.Kind {
+		case EventStart:
+			if len(event.Creatures) != len(record.Participants) {
+				return reports, mismatch(i, "missing the starting creatures")
+			}
+			for j, userID := range record.Participants {
+				c := event.Creatures[j].Clone()
+				c.UseRuleset(record.Rules)
+				creatures[userID] = &c
+			}
+
+		case EventRestore:
+			for _, c := range creatures {
+				c.SetAction(defAction)
+			}
+
+		case EventMove:
+			c := creatures[event.UserID]
+			if c == nil {
+				return reports, mismatch(i, "%d is not a participant", event.UserID)
+			}
+			duration, err := setMove(c, event.Move)
+			if err != nil {
+				return reports, mismatch(i, "%v", err)
+			}
+			if duration != event.Duration {
+				return reports, mismatch(i, "%s took %v instead of %v", event.Move, duration, event.Duration)
+			}
+
+		case EventClash:
+			if event.Clash != nil && record.Teams != nil {
+				report, err := replayTeamClash(record, event, creatures)
+				if err != nil {
+					return reports, mismatch(i, "%v", err)
+				}
+				reports = append(reports, report)
+				continue
+			}
+			if event.Clash == nil || len(event.Creatures) != 2 || len(event.Clash.Order) != 2 {
+				return reports, mismatch(i, "missing the input of the clash")
+			}
+			first, second := creatures[event.Clash.Order[0]], creatures[event.Clash.Order[1]]
+			if first == nil || second == nil {
+				return reports, mismatch(i, "the clash is not between the participants")
+			}
+			for j, c := range []*pg.Creature{first, second} {
+				if !sameCreature(*c, event.Creatures[j]) {
+					return reports, mismatch(i, "creature of %d is different before the clash", event.Clash.Order[j])
+				}
+			}
+
+			winFlag, responses := pg.PerformAction(first, second)
+			if winFlag != event.Clash.Winner || !sameResponses(responses[:], event.Clash.Responses) {
+				return reports, mismatch(i, "got winner %d and %+v instead of winner %d and %+v",
+					winFlag, responses, event.Clash.Winner, event.Clash.Responses)
+			}
+			if winFlag == 0 {
+				first.SetAction(defAction)
+				second.SetAction(defAction)
+			}
+			reports = append(reports, genReport(event.Clash.Order[0], event.Clash.Order[1], winFlag, responses))
+
+		case EventEffect:
+			c := creatures[event.UserID]
+			if c == nil || !c.IsOnStatus(toStatus[event.Effect]) {
+				return reports, mismatch(i, "%d is not %s", event.UserID, event.Effect)
+			}
+
+		case EventFlee, EventTimeout:
+			if creatures[event.UserID] == nil {
+				return reports, mismatch(i, "%d is not a participant", event.UserID)
+			}
+			// In team duels the others keep fighting
+			if record.Teams != nil {
+				creatures[event.UserID].Retire()
+			}
+
+		case EventAbandon:
+
+		default:
+			return reports, mismatch(i, "unknown event")
+		}
+	}
+
+	return reports, nil
+}
+
+/* Replay a clash of a team duel on the creatures of the participants and return its report,
+ * error if the creatures or the outcome are not the same of the log
+ */
+func replayTeamClash(record DuelLog, event DuelEvent, creatures map[int64]*pg.Creature) (BattleReport, error) {
+	var (
+		clash    = event.Clash
+		fighters = make([]pg.Fighter, len(record.Participants))
+	)
+
+	if len(event.Creatures) != len(fighters) || len(clash.Order) != len(fighters) || len(clash.Targets) != len(fighters) {
+		return BattleReport{}, errors.New("missing the input of the clash")
+	}
+	for i, userID := range clash.Order {
+		if userID != record.Participants[i] {
+			return BattleReport{}, errors.New("the clash is not between the participants")
+		}
+		if !sameCreature(*creatures[userID], event.Creatures[i]) {
+			return BattleReport{}, fmt.Errorf("creature of %d is different before the clash", userID)
+		}
+		fighters[i] = pg.Fighter{Creature: creatures[userID], Team: record.Teams[i], Target: indexOfID(clash.Order, clash.Targets[i])}
+	}
+
+	responses := performTeams(fighters, record.Brawl)
+	winFlag := teamWinFlag(fighters)
+	if winFlag != clash.Winner || !sameResponses(responses, clash.Responses) {
+		return BattleReport{}, fmt.Errorf("got winner %d and %+v instead of winner %d and %+v",
+			winFlag, responses, clash.Winner, clash.Responses)
+	}
+	if winFlag == 0 {
+		for _, f := range fighters {
+			f.SetAction(defAction)
+		}
+	}
+	return genTeamReport(clash.Order, record.Teams, clash.Targets, event.Creatures, winFlag, responses), nil
+}
+
+// Check if two lists of responses are the same
+func sameResponses(r1, r2 []pg.InvokeRes) bool {
+	if len(r1) != len(r2) {
+		return false
+	}
+	for i := range r1 {
+		if r1[i] != r2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if two creatures have the same stats, action and effects (the ruleset is ignored)
+func sameCreature(c1, c2 pg.Creature) bool {
+	raw1, err1 := json.Marshal(c1)
+	raw2, err2 := json.Marshal(c2)
+	return err1 == nil && err2 == nil && bytes.Equal(raw1, raw2)
+}
+
+/* Replay the ended duel with the given ID loading its log from the store and print
+ * the outcome of every clash, error if the replay doesn't match the log
+ */
+func replayDuel(ID string) error {
+	record, err := STORE.LoadDuelLog(strings.ToLower(ID))
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return errors.New("There is no log of the duel " + ID)
+	}
+	if record.Rules == nil {
+		record.Rules = pg.DefaultRuleset()
+	}
+
+	if record.Teams == nil {
+		fmt.Println("Duel", record.ID, "between", record.Participants[0], "and", record.Participants[1])
+	} else {
+		fmt.Println("Team duel", record.ID, "between", record.Participants, "of the teams", record.Teams)
+	}
+	fmt.Println("Started", record.Started.Format(time.RFC3339), "ended", record.Ended.Format(time.RFC3339))
+
+	reports, err := Replay(*record)
+	for i, report := range reports {
+		fmt.Print("Clash ", i+1, ":")
+		for _, info := range report.PlayersInfo {
+			fmt.Printf(" %d %s (life %+d, stamina %+d)", info.UserID, info.Performed, info.LifeOff, info.StaminaOff)
+		}
+		fmt.Println()
+	}
+	if err != nil {
+		return err
+	}
+
+	switch last := len(reports) - 1; true {
+	case record.Teams != nil && record.Winners == nil:
+		fmt.Println("Replay matches the log, the team duel ended with a draw")
+	case record.Teams != nil:
+		fmt.Println("Replay matches the log, the winners are", record.Winners)
+	case record.EndedBy(EventFlee):
+		fmt.Println("Replay matches the log,", record.Events[len(record.Events)-1].UserID, "fled from the duel")
+	case record.EndedBy(EventTimeout):
+		fmt.Println("Replay matches the log,", record.Events[len(record.Events)-1].UserID, "lost for inactivity")
+	case record.EndedBy(EventAbandon):
+		fmt.Println("Replay matches the log, the duel was abandoned by both players")
+	case last < 0 || !reports[last].EndDuel:
+		fmt.Println("Replay matches the log, the duel did not end with a clash")
+	case reports[last].WinnerID == nil:
+		fmt.Println("Replay matches the log, the duel ended with a draw")
+	default:
+		fmt.Println("Replay matches the log, the winner is", *reports[last].WinnerID)
+	}
+	return nil
+}
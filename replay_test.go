@@ -0,0 +1,162 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"DuelBot/pg"
+)
+
+// Start a duel outside the registry, without the scheduler the clashes happen only when the test wants
+func startTestDuel(t *testing.T, teams []int, participants ...int64) *Duel {
+	d := newDuel(strings.ToLower(t.Name()), participants...)
+	d.Teams = teams
+	for _, userID := range participants {
+		d.AddNewPlayer(userID)
+		d.players[userID].stats.SetAction(defAction)
+	}
+	if d.isTeamDuel() {
+		d.retarget()
+	}
+	d.log(DuelEvent{Kind: EventStart, Creatures: d.creatures()})
+	t.Cleanup(func() { STORE.DeleteDuel(d.ID) })
+	return d
+}
+
+// Set the move of a player the same way SetPlayerMoves does, who can't fight keeps his action
+func moveTest(d *Duel, userID int64, move string) {
+	p := d.players[userID]
+	if p.stats.IsDead() || p.stats.IsOnStatus(pg.HELPLESS) {
+		return
+	}
+	if duration, err := setMove(&p.stats, move); err == nil {
+		d.log(DuelEvent{Kind: EventMove, UserID: userID, Move: move, Duration: duration})
+	}
+}
+
+// Save the log of the duel and load it back, so the replay goes through the store like replayDuel
+func storedRecord(t *testing.T, d *Duel) DuelLog {
+	d.Lock()
+	record := d.record()
+	d.Unlock()
+
+	if err := STORE.SaveDuelLog(record); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := STORE.LoadDuelLog(d.ID)
+	if err != nil || stored == nil {
+		t.Fatal("log of the duel not found", err)
+	}
+	return *stored
+}
+
+func TestReplay(t *testing.T) {
+	var (
+		d     = startTestDuel(t, nil, 1, 2)
+		moves = [][2]string{
+			{"ATTACK", "DEFEND"},
+			{"DODGE", "ATTACK"},
+			{"POTION", "ATTACK"},
+			{"ATTACK", "SMOKE"},
+			{"ATTACK", "ATTACK"},
+		}
+	)
+
+	// The duel is saved in background after every clash, so it must be locked like in the registry
+	for i, finished := 0, false; !finished; i++ {
+		pair := moves[i%len(moves)]
+		d.Lock()
+		moveTest(d, 1, pair[0])
+		moveTest(d, 2, pair[1])
+		d.clash(2)
+		finished = d.finished
+		d.Unlock()
+		if i > 100 {
+			t.Fatal("the duel never ended")
+		}
+	}
+
+	record := storedRecord(t, d)
+	reports, err := Replay(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reports, record.Reports()) {
+		t.Errorf("replay reports %+v instead of %+v", reports, record.Reports())
+	}
+	if winnerID, _, over := record.Outcome(); !over || !reflect.DeepEqual(winnerID, reports[len(reports)-1].WinnerID) {
+		t.Errorf("outcome is %v (over %v) instead of the last report", winnerID, over)
+	}
+}
+
+func TestReplayTeams(t *testing.T) {
+	d := startTestDuel(t, []int{0, 0, 1, 1}, 1, 2, 3, 4)
+
+	for i, finished := 0, false; !finished; i++ {
+		d.Lock()
+		moveTest(d, 1, "ATTACK")
+		moveTest(d, 2, "DEFEND")
+		moveTest(d, 3, "ATTACK")
+		moveTest(d, 4, "DODGE")
+		d.clashTeams()
+		// The fourth player flees, his team keeps fighting
+		if i == 1 {
+			d.players[4].stats.Retire()
+			d.log(DuelEvent{Kind: EventFlee, UserID: 4})
+		}
+		finished = d.finished
+		d.Unlock()
+		if i > 100 {
+			t.Fatal("the team duel never ended")
+		}
+	}
+
+	record := storedRecord(t, d)
+	reports, err := Replay(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reports, record.Reports()) {
+		t.Errorf("replay reports %+v instead of %+v", reports, record.Reports())
+	}
+	if last := reports[len(reports)-1]; !last.EndDuel || !reflect.DeepEqual(last.Winners, record.Winners) {
+		t.Errorf("last report has winners %v instead of %v", last.Winners, record.Winners)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	d := startTestDuel(t, nil, 1, 2)
+	d.Lock()
+	moveTest(d, 1, "ATTACK")
+	moveTest(d, 2, "GUARD")
+	d.clash(1)
+	d.Unlock()
+
+	tests := []struct {
+		name   string
+		change func(record *DuelLog)
+		want   string
+	}{
+		{"not started", func(record *DuelLog) { record.Events = record.Events[1:] }, "never started"},
+		{"unknown player", func(record *DuelLog) { record.Events[1].UserID = 3 }, "not a participant"},
+		{"other duration", func(record *DuelLog) { record.Events[1].Duration++ }, "instead of"},
+		{"other damage", func(record *DuelLog) { record.Events[3].Clash.Responses[0].LifeOffset++ }, "instead of winner"},
+		{"other creature", func(record *DuelLog) {
+			record.Events[3].Creatures[0], record.Events[3].Creatures[1] = record.Events[3].Creatures[1], record.Events[3].Creatures[0]
+		}, "different before the clash"},
+		{"unknown event", func(record *DuelLog) { record.Events[2].Kind = "dance" }, "unknown event"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := storedRecord(t, d)
+			if _, err := Replay(record); err != nil {
+				t.Fatal("the log doesn't replay before the change", err)
+			}
+			test.change(&record)
+			if _, err := Replay(record); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one about %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"time"
+
+	"DuelBot/pg"
+)
+
+// Tell the scheduler of the duel that a player changed action
+func (d *Duel) notifyMove(ownerID int64) {
+	select {
+	case d.moves <- ownerID:
+	case <-d.stop:
+	}
+}
+
+// Check if the action is performed by itself when its duration expires (ATTACK, DODGE or ITEM)
+func isTimed(action pg.Status) bool {
+	return action == pg.ATTACK || action == pg.DODGE || action == pg.ITEM
+}
+
+/* Run the scheduler of the duel until it ends. The clash is resolved exactly when the
+ * duration of the pending ATTACK, DODGE or ITEM expire or as soon as the opponent commit his
+ * action, the timer is canceled if the player changes action before
+ */
+func (d *Duel) schedule(onClash func(BattleReport)) {
+	if d.isTeamDuel() {
+		d.scheduleTeams(onClash)
+		return
+	}
+
+	var (
+		timer   *time.Timer
+		expired <-chan time.Time
+		pending int64 // userID of the player whose action is waiting the timer
+	)
+
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, expired, pending = nil, nil, 0
+	}
+	defer stopTimer()
+
+	for {
+		var (
+			report  BattleReport
+			clashed bool
+		)
+
+		select {
+		case <-d.stop:
+			return
+
+		case ownerID := <-d.moves:
+			d.Lock()
+			if d.ended || d.finished {
+				d.Unlock()
+				return
+			}
+			owner := d.players[ownerID]
+			action, duration, _ := owner.stats.GetStatus()
+
+			switch true {
+			case action == defAction:
+				// Player stopped doing his action
+				if pending == ownerID {
+					stopTimer()
+				}
+			case d.players[d.Opponent(ownerID)].isReady():
+				// Opponent already committed his action
+				stopTimer()
+				report, clashed = d.clash(ownerID), true
+			case isTimed(action):
+				// Wait for the action to be performed or for the opponent
+				stopTimer()
+				timer, pending = time.NewTimer(duration), ownerID
+				expired = timer.C
+			}
+			d.Unlock()
+
+		case <-expired:
+			d.Lock()
+			if d.ended || d.finished {
+				d.Unlock()
+				return
+			}
+			// Ignore it if player changed action and the scheduler is still not aware of it
+			if action, _, _ := d.players[pending].stats.GetStatus(); isTimed(action) {
+				report, clashed = d.clash(pending), true
+			}
+			stopTimer()
+			d.Unlock()
+		}
+
+		// Notify outside the lock so the handlers can access the duel
+		if clashed && onClash != nil {
+			onClash(report)
+		}
+	}
+}
+
+// Execute the action of a player against his opponent and vice versa (duel must be locked)
+func (d *Duel) clash(ownerID int64) BattleReport {
+	var (
+		owner      = d.players[ownerID]
+		opponentID = d.Opponent(ownerID)
+		opponent   = d.players[opponentID]
+	)
+
+	// Perform the action between players and generate the BattleReport
+	input := []pg.Creature{owner.stats.Clone(), opponent.stats.Clone()}
+	winFlag, responses := pg.PerformAction(&owner.stats, &opponent.stats)
+	report := genReport(ownerID, opponentID, winFlag, responses)
+
+	// Keep track of everything so the clash can be replayed
+	d.Clashes++
+	d.log(DuelEvent{
+		Kind:      EventClash,
+		Creatures: input,
+		Clash:     &ClashEvent{Order: []int64{ownerID, opponentID}, Winner: winFlag, Responses: responses[:]},
+		Report:    &report,
+	})
+	for i, res := range responses {
+		if res.GainEffect != pg.HELPLESS {
+			d.log(DuelEvent{Kind: EventEffect, UserID: report.PlayersInfo[i].UserID, Effect: toString[res.GainEffect]})
+		}
+	}
+	if winFlag == 0 {
+		// Set players on default action
+		owner.stats.SetAction(defAction)
+		opponent.stats.SetAction(defAction)
+	} else {
+		// The last clash claims the end, who handles the report records the result
+		d.finished = true
+	}
+	d.save()
+
+	return report
+}
+
+/* Run the scheduler of a team duel until it ends. The clash is resolved as soon as every
+ * player still fighting committed his action or when the first pending ATTACK, DODGE or ITEM expire
+ */
+func (d *Duel) scheduleTeams(onClash func(BattleReport)) {
+	var (
+		timer    *time.Timer
+		expired  <-chan time.Time
+		deadline = make(map[int64]time.Time) // userID -> when his pending action expire
+	)
+
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, expired = nil, nil
+	}
+	defer stopTimer()
+
+	// Wait for the first pending action to expire
+	resetTimer := func() {
+		var first time.Time
+
+		stopTimer()
+		for _, t := range deadline {
+			if first.IsZero() || t.Before(first) {
+				first = t
+			}
+		}
+		if !first.IsZero() {
+			timer = time.NewTimer(time.Until(first))
+			expired = timer.C
+		}
+	}
+
+	for {
+		var (
+			report  BattleReport
+			clashed bool
+		)
+
+		select {
+		case <-d.stop:
+			return
+
+		case ownerID := <-d.moves:
+			d.Lock()
+			if d.ended || d.finished {
+				d.Unlock()
+				return
+			}
+			action, duration, _ := d.players[ownerID].stats.GetStatus()
+			delete(deadline, ownerID)
+
+			switch true {
+			case d.allReady():
+				report, clashed = d.clashTeams(), true
+			case isTimed(action):
+				deadline[ownerID] = time.Now().Add(duration)
+			}
+			if clashed {
+				deadline = make(map[int64]time.Time)
+			}
+			resetTimer()
+			d.Unlock()
+
+		case now := <-expired:
+			d.Lock()
+			if d.ended || d.finished {
+				d.Unlock()
+				return
+			}
+			// Ignore who changed action and the scheduler is still not aware of it
+			for userID, t := range deadline {
+				if action, _, _ := d.players[userID].stats.GetStatus(); !isTimed(action) {
+					delete(deadline, userID)
+				} else if !t.After(now) {
+					clashed = true
+				}
+			}
+			if clashed {
+				report, deadline = d.clashTeams(), make(map[int64]time.Time)
+			}
+			resetTimer()
+			d.Unlock()
+		}
+
+		// Notify outside the lock so the handlers can access the duel
+		if clashed && onClash != nil {
+			onClash(report)
+		}
+	}
+}
+
+// Check if every player still fighting committed his action (duel must be locked)
+func (d *Duel) allReady() bool {
+	for _, p := range d.players {
+		if !p.stats.IsDead() && !p.isReady() {
+			return false
+		}
+	}
+	return true
+}
+
+// Execute the actions of all the players of a team duel against their targets (duel must be locked)
+func (d *Duel) clashTeams() BattleReport {
+	var (
+		input    = d.creatures()
+		fighters = make([]pg.Fighter, len(d.Participants))
+		targets  = make([]int64, len(d.Participants))
+	)
+
+	for i, userID := range d.Participants {
+		p := d.players[userID]
+		targets[i] = p.target
+		if p.protect != 0 && p.stats.IsOnStatus(pg.DEFEND) {
+			targets[i] = p.protect
+		}
+		fighters[i] = pg.Fighter{Creature: &p.stats, Team: d.Teams[i], Target: indexOfID(d.Participants, targets[i])}
+	}
+
+	responses := performTeams(fighters, d.settings.Brawl)
+	winFlag := teamWinFlag(fighters)
+	report := genTeamReport(d.Participants, d.Teams, targets, input, winFlag, responses)
+
+	// Keep track of everything so the clash can be replayed
+	d.Clashes++
+	d.log(DuelEvent{
+		Kind:      EventClash,
+		Creatures: input,
+		Clash:     &ClashEvent{Order: d.Participants, Targets: targets, Winner: winFlag, Responses: responses},
+		Report:    &report,
+	})
+	for i, res := range responses {
+		if res.GainEffect != pg.HELPLESS {
+			d.log(DuelEvent{Kind: EventEffect, UserID: d.Participants[i], Effect: toString[res.GainEffect]})
+		}
+	}
+	if winFlag == 0 {
+		// Set players on default action
+		for _, f := range fighters {
+			f.SetAction(defAction)
+		}
+		d.retarget()
+	} else {
+		d.winners, d.finished = report.Winners, true
+	}
+	d.save()
+
+	return report
+}
+
+// Resolve a clash between teams, in a brawl every pair of players is resolved like in a duel
+func performTeams(fighters []pg.Fighter, brawl bool) []pg.InvokeRes {
+	if !brawl {
+		return pg.PerformClash(fighters)
+	}
+
+	var (
+		creatures = make([]*pg.Creature, len(fighters))
+		targets   = make([]int, len(fighters))
+	)
+	for i, f := range fighters {
+		creatures[i], targets[i] = f.Creature, f.Target
+	}
+	return pg.PerformBrawl(creatures, targets)
+}
+
+// Get the flag of the winner of a team duel: 0 if still going, -1 if draw or the team that won plus one
+func teamWinFlag(fighters []pg.Fighter) int8 {
+	switch team, over := pg.TeamWinner(fighters); true {
+	case !over:
+		return 0
+	case team == -1:
+		return -1
+	default:
+		return int8(team + 1)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"DuelBot/pg"
+)
+
+// Step of the duration of ATTACK and DODGE in the scheduler tests, with 6 of 10 stamina they last 5 steps
+const testSpeedStep = 20 * time.Millisecond
+
+/* Engage a duel between the players 1 and 2 with quick actions, the reports of
+ * the clashes are sent on the returned channel. The duel is ended with the test
+ */
+func scheduleTest(t *testing.T) (*DuelRegistry, <-chan BattleReport) {
+	var (
+		rules   = pg.DefaultRuleset()
+		reports = make(chan BattleReport, 10)
+	)
+
+	rules.Durations.SpeedStep = pg.Duration(testSpeedStep)
+	rules.Durations.Item = pg.Duration(5 * testSpeedStep)
+
+	rules, RULES = RULES, rules
+	t.Cleanup(func() { RULES = rules })
+
+	r := NewDuelRegistry()
+	r.OnClash = func(report BattleReport) { reports <- report }
+	if _, err := r.EngageDuel(1, 2, DuelSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.EndDuel(1) })
+	return r, reports
+}
+
+// Set the move of a player, the test fails if it can't be set
+func setTestMove(t *testing.T, r *DuelRegistry, userID int64, move string) time.Duration {
+	duration, err := r.SetPlayerMoves(userID, move)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return duration
+}
+
+// Check that no clash happens for a while
+func noClash(t *testing.T, reports <-chan BattleReport, wait time.Duration) {
+	select {
+	case report := <-reports:
+		t.Errorf("clashed with %+v", report.PlayersInfo)
+	case <-time.After(wait):
+	}
+}
+
+func TestScheduleReady(t *testing.T) {
+	r, reports := scheduleTest(t)
+
+	// DEFEND is not timed, the clash waits for the opponent
+	setTestMove(t, r, 1, "DEFEND")
+	noClash(t, reports, 10*testSpeedStep)
+
+	start := time.Now()
+	duration := setTestMove(t, r, 2, "ATTACK")
+	select {
+	case report := <-reports:
+		if report.PlayersInfo[0].Performed != "ATTACK" || report.PlayersInfo[1].Performed != "DEFEND" {
+			t.Errorf("clashed with %+v", report.PlayersInfo)
+		}
+		if elapsed := time.Since(start); elapsed >= duration {
+			t.Errorf("the clash waited %v, the whole attack", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no clash with the opponent ready")
+	}
+}
+
+func TestScheduleTimed(t *testing.T) {
+	r, reports := scheduleTest(t)
+
+	start := time.Now()
+	duration := setTestMove(t, r, 1, "ATTACK")
+	select {
+	case report := <-reports:
+		if elapsed := time.Since(start); elapsed < duration {
+			t.Errorf("the attack of %v was performed after %v", duration, elapsed)
+		}
+		if report.PlayersInfo[0].UserID != 1 || report.PlayersInfo[0].Performed != "ATTACK" || report.PlayersInfo[1].Performed != "GUARD" {
+			t.Errorf("clashed with %+v", report.PlayersInfo)
+		}
+	case <-time.After(duration + time.Second):
+		t.Fatal("the attack was never performed")
+	}
+
+	// After the clash the players are back on guard
+	if onGuard, _ := r.IsPlayerOnGuard(1); !onGuard {
+		t.Error("the attacker is not on guard after the clash")
+	}
+}
+
+func TestScheduleChanged(t *testing.T) {
+	r, reports := scheduleTest(t)
+
+	// Going back on guard cancels the attack
+	duration := setTestMove(t, r, 1, "ATTACK")
+	setTestMove(t, r, 1, "GUARD")
+	noClash(t, reports, 3*duration)
+
+	// The new action replaces the old one, the clash happens when the last one expire
+	setTestMove(t, r, 1, "ATTACK")
+	start := time.Now()
+	duration = setTestMove(t, r, 1, "TONIC")
+	select {
+	case report := <-reports:
+		if elapsed := time.Since(start); elapsed < duration || report.PlayersInfo[0].Performed != "TONIC" {
+			t.Errorf("performed %s after %v instead of the tonic after %v", report.PlayersInfo[0].Performed, elapsed, duration)
+		}
+	case <-time.After(duration + time.Second):
+		t.Fatal("the tonic was never used")
+	}
+}
+
+func TestScheduleEnd(t *testing.T) {
+	r, reports := scheduleTest(t)
+
+	duration := setTestMove(t, r, 1, "ATTACK")
+	if err := r.EndDuel(1); err != nil {
+		t.Fatal(err)
+	}
+	noClash(t, reports, 3*duration)
+
+	if _, err := r.SetPlayerMoves(2, "ATTACK"); err == nil {
+		t.Error("moves are accepted after the end of the duel")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+const seriesNextRoundIn = 5 * time.Second // pause between the rounds of a series
+
+// Number of rounds of the series that can be choosen, 1 means a single duel
+var seriesLengths = []int{1, 3, 5, 7}
+
+// Progress of a best-of-N series, every round is a duel between the same players
+type SeriesScore struct {
+	Players [2]int64 `json:"players"`
+	Wins    [2]int   `json:"wins"`              // rounds won by the players, in the same order
+	Round   int      `json:"round"`             // current round, from 1
+	LeftBy  int64    `json:"left_by,omitempty"` // player that fled or was inactive, he lost the series
+	Ended   bool     `json:"ended,omitempty"`   // ended before the end, if nobody left it's decided by the score
+}
+
+// Start a new series between two players
+func newSeries(firstID, secondID int64) *SeriesScore {
+	return &SeriesScore{Players: [2]int64{firstID, secondID}, Round: 1}
+}
+
+// Get the rounds won by a player and the ones won by his opponent
+func (s SeriesScore) Score(userID int64) (own, enemy int) {
+	if s.Players[0] == userID {
+		return s.Wins[0], s.Wins[1]
+	}
+	return s.Wins[1], s.Wins[0]
+}
+
+// Add the result of a round (winnerID is nil if draw, the round is played again)
+func (s *SeriesScore) AddRound(winnerID *int64) {
+	for i, userID := range s.Players {
+		if winnerID != nil && *winnerID == userID {
+			s.Wins[i]++
+		}
+	}
+}
+
+// End the series because a player fled or was inactive
+func (s *SeriesScore) Leave(userID int64) {
+	s.LeftBy, s.Ended = userID, true
+}
+
+// Check if the series is over: someone won enough rounds, left, or there were too many draws
+func (s SeriesScore) Over(bestOf int) bool {
+	needed := bestOf/2 + 1
+	return s.Ended || s.Wins[0] >= needed || s.Wins[1] >= needed || s.Round >= 2*bestOf
+}
+
+// Get the winner of the series, false if it's a draw
+func (s SeriesScore) Winner() (winnerID int64, ok bool) {
+	switch true {
+	case s.LeftBy == s.Players[0], s.LeftBy == 0 && s.Wins[1] > s.Wins[0]:
+		return s.Players[1], true
+	case s.LeftBy == s.Players[1], s.Wins[0] > s.Wins[1]:
+		return s.Players[0], true
+	}
+	return 0, false
+}
+
+// Get the code of the number of rounds used inside the invite tokens
+func seriesCode(bestOf int) byte {
+	for i, length := range seriesLengths {
+		if length == bestOf {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+// Check if the number of rounds of a series can be choosen
+func isSeriesLength(bestOf int) bool {
+	return seriesLengths[seriesCode(bestOf)] == bestOf
+}
+
+// Generate the line with the score of the series from the point of view of a player (empty if single duel)
+func genSeriesLine(userID int64, settings DuelSettings) string {
+	if settings.Series == nil {
+		return ""
+	}
+
+	own, enemy := settings.Series.Score(userID)
+	return fmt.Sprint(
+		"🏆 Round ", settings.Series.Round, " - best of ", settings.BestOf,
+		" - <b>You ", own, " : ", enemy, " Enemy</b>",
+	)
+}
+
+/* Go on with the series after the end of a round (settings must be already updated
+ * with its result): the next round starts after seriesNextRoundIn or, if the series
+ * is over, the players are told who won it. Nothing happens on single duels
+ */
+func (b *bot) ContinueSeries(settings DuelSettings) {
+	switch true {
+	case settings.Series == nil:
+		return
+	case settings.Series.Over(settings.BestOf):
+		b.NotifySeriesEnd(settings)
+	default:
+		b.NotifySeriesScore(settings)
+		time.AfterFunc(seriesNextRoundIn, func() { startNextRound(settings) })
+	}
+}
+
+// Start the next round of a series with fresh creatures for both players
+func startNextRound(settings DuelSettings) {
+	var (
+		series = *settings.Series
+		b      = &bot{series.Players[0], echotron.NewAPI(TOKEN)}
+	)
+
+	series.Round++
+	settings.Series = &series
+	if _, err := duels.EngageDuel(series.Players[0], series.Players[1], settings); err != nil {
+		log.Println("startNextRound", "EngageDuel", err)
+		series.Ended = true
+		b.NotifySeriesEnd(settings)
+		return
+	}
+	b.NotifyAcceptDuel(series.Players[0], series.Players[1])
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSeriesOver(t *testing.T) {
+	const firstID, secondID = 1, 2
+
+	tests := []struct {
+		bestOf  int
+		rounds  []int64 // winner of every round, 0 if draw
+		leftBy  int64   // who leaves after the last round (0 if nobody)
+		winner  int64   // 0 if the series is a draw
+		decided int     // rounds played when the series is over
+	}{
+		{3, []int64{1, 1}, 0, 1, 2},
+		{3, []int64{1, 2, 2}, 0, 2, 3},
+		{3, []int64{1, 0, 1}, 0, 1, 3},
+		{3, []int64{0, 2, 0, 1, 0, 0}, 0, 0, 6},
+		{3, []int64{0, 0, 0, 0, 0, 2}, 0, 2, 6},
+		{5, []int64{1, 1, 1}, 0, 1, 3},
+		{5, []int64{2, 1, 0, 2, 1, 0, 2}, 0, 2, 7},
+		{5, []int64{1, 0, 0, 0, 0, 0, 0, 0, 0, 0}, 0, 1, 10},
+		{5, []int64{1, 1}, 1, 2, 2},
+		{5, []int64{0}, 2, 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint("BO", test.bestOf, test.rounds, test.leftBy), func(t *testing.T) {
+			series := newSeries(firstID, secondID)
+
+			for i, winnerID := range test.rounds {
+				if winnerID != 0 {
+					series.AddRound(&winnerID)
+				} else {
+					series.AddRound(nil)
+				}
+				if i == len(test.rounds)-1 && test.leftBy != 0 {
+					series.Leave(test.leftBy)
+				}
+				// The next round starts only if the series goes on
+				switch over := series.Over(test.bestOf); true {
+				case over && i < len(test.rounds)-1:
+					t.Fatalf("over after %d rounds instead of %d", i+1, test.decided)
+				case !over:
+					series.Round++
+				}
+			}
+
+			if !series.Over(test.bestOf) || series.Round != test.decided {
+				t.Fatalf("over is %v after %d rounds, want over after %d", series.Over(test.bestOf), series.Round, test.decided)
+			}
+			winnerID, ok := series.Winner()
+			if winnerID != test.winner || ok != (test.winner != 0) {
+				t.Errorf("won by %d (%v) instead of %d", winnerID, ok, test.winner)
+			}
+		})
+	}
+}
+
+func TestSeriesScore(t *testing.T) {
+	series := newSeries(1, 2)
+	winnerID := int64(2)
+	series.AddRound(&winnerID)
+	series.AddRound(nil)
+
+	if own, enemy := series.Score(1); own != 0 || enemy != 1 {
+		t.Errorf("the first player has %d to %d", own, enemy)
+	}
+	if own, enemy := series.Score(2); own != 1 || enemy != 0 {
+		t.Errorf("the second player has %d to %d", own, enemy)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"DuelBot/pg"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store persists the state of the bot so it can be restored after a restart
+type Store interface {
+	// Invitations created by the users that are still outstanding (or revoked but not expired)
+	SaveInvites(userID int64, invites []Invite) error
+	LoadInvites() (map[int64][]Invite, error)
+
+	// Snapshots of the ongoing duels
+	SaveDuel(snapshot DuelSnapshot) error
+	DeleteDuel(ID string) error
+	LoadDuels() ([]DuelSnapshot, error)
+
+	// Logs of the ended duels, used to replay them (nil if missing)
+	SaveDuelLog(record DuelLog) error
+	LoadDuelLog(ID string) (*DuelLog, error)
+	LoadPlayedDuels(userID int64) ([]string, error) // IDs of the latest ended duels of a player, from the oldest
+
+	// Lifetime statistics of the players (nil if missing)
+	SaveProfile(profile Profile) error
+	LoadProfile(userID int64) (*Profile, error)
+	LoadProfiles() ([]Profile, error)
+
+	// Results of the players in the duels started from a group
+	SaveGroupRecords(groupID int64, records []GroupRecord) error
+	LoadGroupRecords(groupID int64) ([]GroupRecord, error)
+
+	// Tournaments of the groups that are not over
+	SaveTournament(t Tournament) error
+	DeleteTournament(groupID int64) error
+	LoadTournaments() ([]Tournament, error)
+
+	Close() error
+}
+
+// STORE is where the state of the bot is saved.
+var STORE Store
+
+// Saved state of an ongoing duel
+type DuelSnapshot struct {
+	ID           string        `json:"id"`
+	Key          string        `json:"key,omitempty"` // used instead of the ID by the old versions
+	Participants []int64       `json:"participants"`
+	Teams        []int         `json:"teams,omitempty"` // team of every participant (nil if not a team duel)
+	Started      time.Time     `json:"started"`
+	Clashes      int           `json:"clashes"`
+	Events       []DuelEvent   `json:"events,omitempty"`
+	AFKTimeout   time.Duration `json:"afk_timeout,omitempty"`
+	DuelSettings
+	Posted     *MessageRef      `json:"posted,omitempty"`     // message of the open challenge (nil if none)
+	Spectators map[int64]int    `json:"spectators,omitempty"` // chatID -> message ID of the live view
+	Names      map[int64]string `json:"names,omitempty"`      // userID -> name of the players of a team duel
+	Players    []PlayerSnapshot `json:"players"`
+}
+
+// Saved state of a player inside a duel
+type PlayerSnapshot struct {
+	UserID   int64       `json:"user_id"`
+	MenuID   int         `json:"menu_id"`
+	ReportID int         `json:"report_id"`
+	Stats    pg.Creature `json:"stats"`
+	Target   int64       `json:"target,omitempty"`  // enemy targeted in team duels
+	Protect  int64       `json:"protect,omitempty"` // ally protected in team duels
+}
+
+/* Load the saved state of the bot: invitations, ongoing duels and tournaments.
+ * It returns the players of the duels that need to be resumed
+ */
+func restoreState() (resumed []int64, err error) {
+	invites, err := STORE.LoadInvites()
+	if err != nil {
+		return
+	}
+	for userID, list := range invites {
+		invitesRegister[userID] = list
+	}
+
+	snapshots, err := STORE.LoadDuels()
+	if err != nil {
+		return
+	}
+	resumed = duels.Restore(snapshots)
+
+	saved, err := STORE.LoadTournaments()
+	if err != nil {
+		return
+	}
+	tournaments.Restore(saved)
+
+	return
+}
+
+// Store implementation that use a BoltDB file
+type boltStore struct {
+	db *bolt.DB
+}
+
+const maxPlayedDuels = 100 // the list of the duels played by a player keeps only the latest ones
+
+var (
+	invitesBucket = []byte("invites")
+	duelsBucket   = []byte("duels")
+	profileBucket = []byte("profiles")
+	groupsBucket  = []byte("groups")
+	logsBucket    = []byte("logs")
+	playedBucket  = []byte("played")
+	tourneyBucket = []byte("tournaments")
+)
+
+// Open (or create if missing) the BoltDB file at the given path
+func NewBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{invitesBucket, duelsBucket, profileBucket, groupsBucket, logsBucket, playedBucket, tourneyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db}, nil
+}
+
+// Save a value encoded in JSON inside a bucket
+func (s *boltStore) put(bucket []byte, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), raw)
+	})
+}
+
+// Load a value encoded in JSON from a bucket, found is false if missing
+func (s *boltStore) get(bucket []byte, key string, value interface{}) (found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucket).Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(raw, value)
+	})
+	return
+}
+
+// Delete a value from a bucket
+func (s *boltStore) delete(bucket []byte, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+// Call fn for every key and JSON-encoded value inside a bucket
+func (s *boltStore) forEach(bucket []byte, fn func(key string, raw []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+// Convert a userID into the key used in the buckets
+func userKey(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
+
+func (s *boltStore) SaveInvites(userID int64, invites []Invite) error {
+	if len(invites) == 0 {
+		return s.delete(invitesBucket, userKey(userID))
+	}
+	return s.put(invitesBucket, userKey(userID), invites)
+}
+
+func (s *boltStore) LoadInvites() (invites map[int64][]Invite, err error) {
+	invites = make(map[int64][]Invite)
+
+	err = s.forEach(invitesBucket, func(key string, raw []byte) error {
+		var list []Invite
+
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return err
+		}
+		// Invites saved before the signed tokens are not valid anymore, skip them
+		if json.Unmarshal(raw, &list) != nil {
+			return nil
+		}
+		invites[userID] = list
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) SaveDuel(snapshot DuelSnapshot) error {
+	return s.put(duelsBucket, snapshot.ID, snapshot)
+}
+
+func (s *boltStore) DeleteDuel(ID string) error {
+	return s.delete(duelsBucket, ID)
+}
+
+func (s *boltStore) LoadDuels() (snapshots []DuelSnapshot, err error) {
+	err = s.forEach(duelsBucket, func(key string, raw []byte) error {
+		var snapshot DuelSnapshot
+
+		if err := json.Unmarshal(raw, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) SaveDuelLog(record DuelLog) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(logsBucket).Put([]byte(record.ID), raw); err != nil {
+			return err
+		}
+
+		// Keep the list of the latest duels played by every participant
+		played := tx.Bucket(playedBucket)
+		for _, userID := range record.Participants {
+			var IDs []string
+
+			if isAI(userID) {
+				continue
+			}
+			if raw := played.Get([]byte(userKey(userID))); raw != nil {
+				if err := json.Unmarshal(raw, &IDs); err != nil {
+					return err
+				}
+			}
+			// The same log saved again is not a new duel
+			if len(IDs) > 0 && IDs[len(IDs)-1] == record.ID {
+				continue
+			}
+			if IDs = append(IDs, record.ID); len(IDs) > maxPlayedDuels {
+				IDs = IDs[len(IDs)-maxPlayedDuels:]
+			}
+			rawIDs, err := json.Marshal(IDs)
+			if err != nil {
+				return err
+			}
+			if err = played.Put([]byte(userKey(userID)), rawIDs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) LoadPlayedDuels(userID int64) (IDs []string, err error) {
+	_, err = s.get(playedBucket, userKey(userID), &IDs)
+	return
+}
+
+func (s *boltStore) LoadDuelLog(ID string) (*DuelLog, error) {
+	var record DuelLog
+
+	found, err := s.get(logsBucket, ID, &record)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *boltStore) SaveProfile(profile Profile) error {
+	return s.put(profileBucket, userKey(profile.UserID), profile)
+}
+
+func (s *boltStore) LoadProfile(userID int64) (*Profile, error) {
+	var profile Profile
+
+	found, err := s.get(profileBucket, userKey(userID), &profile)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (s *boltStore) LoadProfiles() (profiles []Profile, err error) {
+	err = s.forEach(profileBucket, func(key string, raw []byte) error {
+		var profile Profile
+
+		if err := json.Unmarshal(raw, &profile); err != nil {
+			return err
+		}
+		profiles = append(profiles, profile)
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) SaveGroupRecords(groupID int64, records []GroupRecord) error {
+	return s.put(groupsBucket, userKey(groupID), records)
+}
+
+func (s *boltStore) LoadGroupRecords(groupID int64) (records []GroupRecord, err error) {
+	_, err = s.get(groupsBucket, userKey(groupID), &records)
+	return
+}
+
+func (s *boltStore) SaveTournament(t Tournament) error {
+	return s.put(tourneyBucket, userKey(t.GroupID), t)
+}
+
+func (s *boltStore) DeleteTournament(groupID int64) error {
+	return s.delete(tourneyBucket, userKey(groupID))
+}
+
+func (s *boltStore) LoadTournaments() (saved []Tournament, err error) {
+	err = s.forEach(tourneyBucket, func(key string, raw []byte) error {
+		var t Tournament
+
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return err
+		}
+		saved = append(saved, t)
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
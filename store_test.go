@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"DuelBot/pg"
+)
+
+// Open a store on a new temporary file, closed when the test is over
+func openTestStore(t *testing.T) Store {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// Check that two values are encoded the same way, the creatures can't be compared with reflect
+func sameJSON(t *testing.T, got, want interface{}) {
+	rawGot, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawWant, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rawGot) != string(rawWant) {
+		t.Errorf("loaded %s instead of %s", rawGot, rawWant)
+	}
+}
+
+func TestStoreProfiles(t *testing.T) {
+	var (
+		store   = openTestStore(t)
+		profile = Profile{
+			UserID: 1, Wins: 3, Losses: 2, Draws: 1, Flees: 1, Rating: 1234, Ranked: 5,
+			DamageDealt: 40, DamageTaken: 35, Class: "rogue",
+			Performed: map[string]int{"ATTACK": 10, "DODGE": 4},
+			Succeeded: map[string]int{"ATTACK": 6},
+		}
+	)
+
+	if loaded, err := store.LoadProfile(1); loaded != nil || err != nil {
+		t.Fatalf("loaded %+v (error %v) before saving it", loaded, err)
+	}
+	if err := store.SaveProfile(profile); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := store.LoadProfile(1); err != nil || !reflect.DeepEqual(*loaded, profile) {
+		t.Errorf("loaded %+v (error %v) instead of %+v", loaded, err, profile)
+	}
+
+	// Saving again replaces the profile
+	profile.Wins++
+	store.SaveProfile(profile)
+	store.SaveProfile(Profile{UserID: 2, Rating: defRating})
+	profiles, err := store.LoadProfiles()
+	if err != nil || len(profiles) != 2 || !reflect.DeepEqual(profiles[0], profile) {
+		t.Errorf("loaded %+v (error %v)", profiles, err)
+	}
+}
+
+func TestStoreDuels(t *testing.T) {
+	var (
+		store = openTestStore(t)
+		stats = pg.NewClassCreature(nil, pg.KNIGHT)
+	)
+
+	stats.SetAction(pg.ATTACK)
+	snapshot := DuelSnapshot{
+		ID:           "abc123",
+		Participants: []int64{1, 2},
+		Started:      time.Now().Truncate(time.Second),
+		Clashes:      3,
+		Events:       []DuelEvent{{Kind: EventMove, UserID: 1, Move: "ATTACK", Duration: time.Second}},
+		AFKTimeout:   time.Minute,
+		DuelSettings: DuelSettings{Ranked: true, BestOf: 3, Series: &SeriesScore{}},
+		Spectators:   map[int64]int{-100: 42},
+		Players:      []PlayerSnapshot{{UserID: 1, MenuID: 10, ReportID: 11, Stats: stats}, {UserID: 2, Stats: pg.NewCreature(nil)}},
+	}
+	if err := store.SaveDuel(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	store.SaveDuel(DuelSnapshot{ID: "def456", Participants: []int64{3, 4}})
+
+	snapshots, err := store.LoadDuels()
+	if err != nil || len(snapshots) != 2 {
+		t.Fatalf("loaded %d duels (error %v) instead of 2", len(snapshots), err)
+	}
+	sameJSON(t, snapshots[0], snapshot)
+
+	if err = store.DeleteDuel("def456"); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ = store.LoadDuels(); len(snapshots) != 1 || snapshots[0].ID != snapshot.ID {
+		t.Errorf("loaded %+v after deleting the second duel", snapshots)
+	}
+}
+
+func TestStoreTournaments(t *testing.T) {
+	var (
+		store      = openTestStore(t)
+		tournament = Tournament{
+			GroupID: -1001, HostID: 1, Format: DoubleElimination,
+			Players: []int64{1, 2, 3}, Names: map[int64]string{1: "Anna", 2: "Bruno", 3: "Carla"},
+			MessageID: 7, Started: true, Round: 2,
+			Matches: []TournamentMatch{{Round: 1, Players: [2]int64{1, 2}, DuelID: "abc123", Played: true, WinnerID: 1}, {Round: 1, Players: [2]int64{3, 0}}},
+		}
+	)
+
+	if err := store.SaveTournament(tournament); err != nil {
+		t.Fatal(err)
+	}
+	store.SaveTournament(Tournament{GroupID: -1002, Format: RoundRobin})
+
+	saved, err := store.LoadTournaments()
+	if err != nil || len(saved) != 2 || !reflect.DeepEqual(saved[0], tournament) {
+		t.Errorf("loaded %+v (error %v) instead of %+v", saved, err, tournament)
+	}
+
+	if err = store.DeleteTournament(-1002); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ = store.LoadTournaments(); len(saved) != 1 || saved[0].GroupID != tournament.GroupID {
+		t.Errorf("loaded %+v after deleting the second tournament", saved)
+	}
+}
+
+func TestStorePlayedDuels(t *testing.T) {
+	const userID, aiID = 1, aiIDBase - 1
+
+	var store = openTestStore(t)
+
+	for i := 0; i < maxPlayedDuels+10; i++ {
+		record := DuelLog{ID: fmt.Sprint("duel", i), Participants: []int64{userID, aiID}, Events: []DuelEvent{{Kind: EventStart}}}
+		if err := store.SaveDuelLog(record); err != nil {
+			t.Fatal(err)
+		}
+		// The same log saved again is still the same duel
+		store.SaveDuelLog(record)
+	}
+
+	IDs, err := store.LoadPlayedDuels(userID)
+	if err != nil || len(IDs) != maxPlayedDuels {
+		t.Fatalf("loaded %d duels (error %v) instead of the latest %d", len(IDs), err, maxPlayedDuels)
+	}
+	if IDs[0] != "duel10" || IDs[len(IDs)-1] != fmt.Sprint("duel", maxPlayedDuels+9) {
+		t.Errorf("played from %s to %s", IDs[0], IDs[len(IDs)-1])
+	}
+	if record, err := store.LoadDuelLog(IDs[0]); err != nil || record == nil || record.ID != IDs[0] {
+		t.Errorf("loaded %+v (error %v) instead of the log of %s", record, err, IDs[0])
+	}
+
+	// AI opponents play too much to keep track
+	if IDs, _ = store.LoadPlayedDuels(aiID); IDs != nil {
+		t.Errorf("the AI played %v", IDs)
+	}
+}
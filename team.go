@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+const (
+	teamSize        = 2 // players of every team of a team duel
+	brawlMinPlayers = 3 // with less players it would be just a duel
+	brawlMaxPlayers = 6 // more would not fit inside the status message
+)
+
+// Names of the teams of a team duel, in order
+var teamNames = []string{"🔴 Red", "🔵 Blue"}
+
+/* Players gathering inside a group for a team duel or a brawl. A team duel starts by itself
+ * when all the teams are full, a brawl when the host starts it. Lobbies are not saved on
+ * the store, after a restart they need to be opened again
+ */
+type Lobby struct {
+	GroupID   int64
+	HostID    int64
+	MessageID int              // message with the players inside the group
+	Brawl     bool             // if it's for a free-for-all, everyone is inside the first team
+	Teams     [][]int64        // players of every team, in order of join
+	Names     map[int64]string // userID -> name of the player
+}
+
+// LobbyRegistry keeps the lobbies of all the groups, one for every group
+type LobbyRegistry struct {
+	mu      sync.Mutex
+	lobbies map[int64]*Lobby // groupID -> lobby
+}
+
+var (
+	lobbies = &LobbyRegistry{lobbies: make(map[int64]*Lobby)}
+
+	errNoLobby = errors.New("Nobody is waiting for a team duel or a brawl in this group, open one using /teamduel or /brawl")
+)
+
+// Get a copy of the lobby that can be read without locking the registry
+func (l Lobby) copy() Lobby {
+	names := make(map[int64]string, len(l.Names))
+	for userID, name := range l.Names {
+		names[userID] = name
+	}
+	l.Names = names
+
+	teams := make([][]int64, len(l.Teams))
+	for i, members := range l.Teams {
+		teams[i] = append([]int64(nil), members...)
+	}
+	l.Teams = teams
+	return l
+}
+
+// Get the team of a player of the lobby, -1 if he didn't join
+func (l Lobby) team(userID int64) int {
+	for i, members := range l.Teams {
+		if containsID(members, userID) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get how many players can join a team of the lobby
+func (l Lobby) capacity() int {
+	if l.Brawl {
+		return brawlMaxPlayers
+	}
+	return teamSize
+}
+
+// Check if every team of the lobby has all of its players
+func (l Lobby) full() bool {
+	for _, members := range l.Teams {
+		if len(members) < l.capacity() {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove a player from his team (he must be inside the lobby)
+func (l *Lobby) remove(userID int64) {
+	team := l.team(userID)
+	members := l.Teams[team]
+	i := indexOfID(members, userID)
+	l.Teams[team] = append(members[:i:i], members[i+1:]...)
+	delete(l.Names, userID)
+}
+
+// Open the lobby of a team duel or of a brawl inside a group, the host joins the first team
+func (r *LobbyRegistry) Open(groupID, hostID int64, name string, brawl bool) (Lobby, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if l := r.lobbies[groupID]; l != nil {
+		return l.copy(), errors.New("Somebody is already waiting for a team duel or a brawl in this group")
+	}
+	l := &Lobby{
+		GroupID: groupID,
+		HostID:  hostID,
+		Brawl:   brawl,
+		Teams:   make([][]int64, len(teamNames)),
+		Names:   map[int64]string{hostID: name},
+	}
+	if brawl {
+		l.Teams = make([][]int64, 1)
+	}
+	l.Teams[0] = []int64{hostID}
+	r.lobbies[groupID] = l
+	return l.copy(), nil
+}
+
+// Set the message with the teams of the lobby of a group
+func (r *LobbyRegistry) SetMessage(groupID int64, messageID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.lobbies[groupID]
+	if l == nil {
+		return errNoLobby
+	}
+	l.MessageID = messageID
+	return nil
+}
+
+// Get the lobby of a group
+func (r *LobbyRegistry) Get(groupID int64) (Lobby, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.lobbies[groupID]
+	if l == nil {
+		return Lobby{}, errNoLobby
+	}
+	return l.copy(), nil
+}
+
+// Add a player to a team of the lobby of a group, if he was in the other one he changes team
+func (r *LobbyRegistry) Join(groupID, userID int64, name string, team int) (Lobby, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.lobbies[groupID]
+	switch true {
+	case l == nil:
+		return Lobby{}, errNoLobby
+	case team < 0 || team >= len(l.Teams):
+		return l.copy(), errors.New("There is no such team")
+	case l.team(userID) == team:
+		return l.copy(), errors.New("You already joined")
+	case len(l.Teams[team]) >= l.capacity():
+		return l.copy(), errors.New("There is no more room")
+	}
+
+	if l.team(userID) != -1 {
+		l.remove(userID)
+	}
+	l.Teams[team] = append(l.Teams[team], userID)
+	l.Names[userID] = name
+	return l.copy(), nil
+}
+
+// Remove a player from the lobby of a group, it's closed when the last one leaves
+func (r *LobbyRegistry) Leave(groupID, userID int64) (Lobby, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.lobbies[groupID]
+	switch true {
+	case l == nil:
+		return Lobby{}, errNoLobby
+	case l.team(userID) == -1:
+		return l.copy(), errors.New("You didn't join")
+	}
+
+	l.remove(userID)
+	if len(l.Names) == 0 {
+		delete(r.lobbies, groupID)
+	}
+	return l.copy(), nil
+}
+
+// Delete the lobby of a group, if hostID is not 0 only the host can do it
+func (r *LobbyRegistry) Close(groupID, hostID int64) (Lobby, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.lobbies[groupID]
+	switch true {
+	case l == nil:
+		return Lobby{}, errNoLobby
+	case hostID != 0 && l.HostID != hostID:
+		return l.copy(), errors.New("Only who opened it can cancel it")
+	}
+
+	delete(r.lobbies, groupID)
+	return l.copy(), nil
+}
+
+// Get the lobby of the brawl of a group if it can be started, only the host can do it
+func (r *LobbyRegistry) Ready(groupID, hostID int64) (Lobby, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l := r.lobbies[groupID]
+	switch true {
+	case l == nil:
+		return Lobby{}, errNoLobby
+	case !l.Brawl:
+		return l.copy(), errors.New("The team duel starts by itself when both teams are full")
+	case l.HostID != hostID:
+		return l.copy(), errors.New("Only who opened the brawl can start it")
+	case len(l.Names) < brawlMinPlayers:
+		return l.copy(), fmt.Errorf("At least %d players are needed to start the brawl", brawlMinPlayers)
+	}
+	return l.copy(), nil
+}
+
+// Check if a player is fighting in a brawl
+func isBrawl(userID int64) bool {
+	settings, err := duels.GetSettings(userID)
+	return err == nil && settings.Brawl
+}
+
+// Start the team duel or the brawl of a lobby, it's closed only if the duel started
+func (b *bot) StartLobby(l Lobby) (err error) {
+	if l.Brawl {
+		_, err = duels.EngageBrawl(l.Teams[0], l.Names, DuelSettings{GroupID: l.GroupID})
+	} else {
+		_, err = duels.EngageTeamDuel(l.Teams, l.Names, DuelSettings{GroupID: l.GroupID})
+	}
+	if err != nil {
+		return err
+	}
+	lobbies.Close(l.GroupID, 0)
+
+	firstID := l.Teams[0][0]
+	if l.MessageID != 0 {
+		posted := MessageRef{ChatID: l.GroupID, MessageID: l.MessageID}
+		duels.SetPosted(firstID, posted)
+		b.AnnounceTeamDuel(posted, l)
+	}
+	b.NotifyTeamDuelStart(firstID)
+	return nil
+}
+
+/* Knock out a player of a team duel that fled or was inactive (EventFlee or EventTimeout),
+ * his allies keep fighting and the duel ends if he was the last one of his team
+ */
+func (b *bot) RetireTeamPlayer(userID int64, kind string) error {
+	winners, over, err := duels.RetirePlayer(userID, kind)
+	if err != nil {
+		return err
+	}
+
+	line := "🏳️ <b>" + GenUserLink(userID, b.GetUserName(userID)) + " fled from the duel</b>"
+	if kind == EventTimeout {
+		line = "⏰ <b>" + GenUserLink(userID, b.GetUserName(userID)) + " is out for inactivity</b>"
+	}
+	if over {
+		b.EndTeamDuel(userID, winners, line)
+		return nil
+	}
+
+	b.UpdateSpectators(userID, line, false)
+	text := "🏳️ <b>You are out of the team duel</b>\n<i>Your allies will keep fighting without you</i>"
+	if isBrawl(userID) {
+		text = "🏳️ <b>You are out of the brawl</b>\n<i>The others will keep fighting without you</i>"
+	}
+	members, _ := duels.GetTeamMembers(userID)
+	for _, member := range members {
+		switch true {
+		case isAI(member.UserID):
+		case member.UserID == userID:
+			b.SendMessage(text, userID, &echotron.MessageOptions{ParseMode: echotron.HTML})
+		case !member.Out:
+			b.SendMessage(line, member.UserID, &echotron.MessageOptions{ParseMode: echotron.HTML})
+			DisplayStatus(member.UserID, false)
+		}
+	}
+	return nil
+}
+
+// End the team duel of a player recording the result and notifying everyone (winners is nil if draw)
+func (b *bot) EndTeamDuel(userID int64, winners []int64, footer string) {
+	var IDs []int64
+
+	members, err := duels.GetTeamMembers(userID)
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		IDs = append(IDs, member.UserID)
+	}
+	RecordEndTeamDuel(winners, IDs)
+	brawl := isBrawl(userID)
+
+	var result string
+	switch true {
+	case brawl && winners == nil:
+		result = "⚖️ <b>The brawl is a draw</b>"
+	case brawl:
+		result = "🏆 <b>" + b.genUserList(winners) + " won the brawl</b>"
+	case winners == nil:
+		result = "⚖️ <b>The team duel is a draw</b>"
+	default:
+		result = fmt.Sprint("🏆 <b>", genTeamName(members, winners[0]), " team won the duel</b>: ", b.genUserList(winners))
+	}
+	b.UpdateSpectators(userID, footer+"\n\n"+result, true)
+	b.AnnounceResult(userID, result)
+	b.NotifyTeamDuelEnd(members, winners, brawl)
+
+	duels.EndDuel(userID)
+	for _, ID := range IDs {
+		StopAI(ID)
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tournamentMaxPlayers = 16              // more would not fit inside the bracket message
+	tournamentCheckEvery = 5 * time.Second // how often the matches ready to be played are started
+)
+
+// Formats of a tournament
+const (
+	SingleElimination = "single"     // who loses once is out
+	DoubleElimination = "double"     // who loses twice is out, the ones with a loss play between them
+	RoundRobin        = "roundrobin" // everyone fights everyone, who has more points wins
+)
+
+var tournamentFormats = []string{SingleElimination, DoubleElimination, RoundRobin}
+
+// Readable names of the formats
+var tournamentFormatNames = map[string]string{
+	SingleElimination: "single elimination",
+	DoubleElimination: "double elimination",
+	RoundRobin:        "round robin",
+}
+
+// A tournament organized inside a group, there can be only one for every group
+type Tournament struct {
+	GroupID   int64             `json:"group_id"`
+	HostID    int64             `json:"host_id"`
+	Format    string            `json:"format"`
+	Players   []int64           `json:"players"` // in order of join, by seed once started
+	Names     map[int64]string  `json:"names"`
+	MessageID int               `json:"message_id"` // message of the bracket inside the group
+	Started   bool              `json:"started"`
+	Round     int               `json:"round"` // current round, from 1
+	Matches   []TournamentMatch `json:"matches"`
+	Ended     bool              `json:"ended"`
+	WinnerID  int64             `json:"winner_id,omitempty"` // 0 if nobody won
+}
+
+// A match of a tournament between two players
+type TournamentMatch struct {
+	Round    int      `json:"round"`
+	Losses   int      `json:"losses,omitempty"`    // losses of the players before it, tells apart the brackets
+	Final    bool     `json:"final,omitempty"`     // last two players left of an elimination
+	Players  [2]int64 `json:"players"`             // the second one is 0 if the first has a bye
+	DuelID   string   `json:"duel_id,omitempty"`   // "" if the duel is still not started
+	Played   bool     `json:"played,omitempty"`    // if the duel is over
+	WinnerID int64    `json:"winner_id,omitempty"` // 0 if draw or abandoned
+}
+
+// TournamentRegistry keeps the tournaments of all the groups and starts their matches
+type TournamentRegistry struct {
+	mu          sync.Mutex
+	tournaments map[int64]*Tournament                     // groupID -> tournament
+	OnMatch     func(t Tournament, match TournamentMatch) // called when the duel of a match started
+}
+
+var (
+	tournaments = &TournamentRegistry{tournaments: make(map[int64]*Tournament)}
+
+	errNoTournament = errors.New("There is no tournament in this group, create one using /tournament create")
+)
+
+// Check if a tournament can be organized with the given format
+func isTournamentFormat(format string) bool {
+	for _, current := range tournamentFormats {
+		if current == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Get a copy of the tournament that can be read without locking the registry
+func (t Tournament) copy() Tournament {
+	names := make(map[int64]string, len(t.Names))
+	for userID, name := range t.Names {
+		names[userID] = name
+	}
+	t.Names = names
+	t.Players = append([]int64(nil), t.Players...)
+	t.Matches = append([]TournamentMatch(nil), t.Matches...)
+	return t
+}
+
+// Save the tournament on the store
+func (t Tournament) save() {
+	if err := STORE.SaveTournament(t); err != nil {
+		log.Println("save", "SaveTournament", err)
+	}
+}
+
+// Check if a user is a player of the tournament
+func (t Tournament) isPlayer(userID int64) bool {
+	for _, playerID := range t.Players {
+		if playerID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Get how many times a player lost, byes are not counted
+func (t Tournament) losses(userID int64) (losses int) {
+	for _, match := range t.Matches {
+		if match.Played && match.Players[1] != 0 && match.WinnerID != userID &&
+			(match.Players[0] == userID || match.Players[1] == userID) {
+			losses++
+		}
+	}
+	return
+}
+
+// Get the points of a player in a round robin: 2 for a win and 1 for a draw
+func (t Tournament) points(userID int64) (points int) {
+	for _, match := range t.Matches {
+		if !match.Played || match.Players[0] != userID && match.Players[1] != userID {
+			continue
+		}
+		switch match.WinnerID {
+		case userID:
+			points += 2
+		case 0:
+			points++
+		}
+	}
+	return
+}
+
+// Get the players of a round robin from the one with more points, ties are broken by the seed
+func (t Tournament) Standings() (standings []int64) {
+	standings = append(standings, t.Players...)
+	sort.SliceStable(standings, func(i, j int) bool { return t.points(standings[i]) > t.points(standings[j]) })
+	return
+}
+
+// Sort the players by seed: the ones that played ranked duels by rating, then the others in order of join
+func (t *Tournament) seed() {
+	var ratings = make(map[int64]int, len(t.Players))
+
+	for _, userID := range t.Players {
+		if profile, err := STORE.LoadProfile(userID); err == nil && profile != nil && profile.Ranked > 0 {
+			ratings[userID] = profile.Rating
+		}
+	}
+	sort.SliceStable(t.Players, func(i, j int) bool {
+		rating1, rated1 := ratings[t.Players[i]]
+		rating2, rated2 := ratings[t.Players[j]]
+		return rated1 && (!rated2 || rating1 > rating2)
+	})
+}
+
+// Schedule all the rounds of a round robin using the circle method
+func (t *Tournament) scheduleRoundRobin() {
+	var players = append([]int64(nil), t.Players...)
+
+	// With an odd number of players every round one of them rests
+	if len(players)%2 == 1 {
+		players = append(players, 0)
+	}
+	for round, n := 1, len(players); round < n; round++ {
+		for i := 0; i < n/2; i++ {
+			if first, second := players[i], players[n-1-i]; first != 0 && second != 0 {
+				t.Matches = append(t.Matches, TournamentMatch{Round: round, Players: [2]int64{first, second}})
+			}
+		}
+		// The first player stays still and the others rotate
+		players = append([]int64{players[0], players[n-1]}, players[1:n-1]...)
+	}
+}
+
+/* Pair the players still in game of an elimination: the ones with the same losses play between
+ * them, the best seed against the worst. If they are odd the best seed has a bye
+ */
+func (t *Tournament) pairPlayers(alive []int64) {
+	if len(alive) == 2 {
+		t.Matches = append(t.Matches, TournamentMatch{
+			Round:   t.Round,
+			Losses:  t.losses(alive[0]),
+			Final:   true,
+			Players: [2]int64{alive[0], alive[1]},
+		})
+		return
+	}
+
+	for losses := 0; losses < t.maxLosses(); losses++ {
+		var group []int64
+		for _, userID := range alive {
+			if t.losses(userID) == losses {
+				group = append(group, userID)
+			}
+		}
+
+		if len(group)%2 == 1 {
+			t.Matches = append(t.Matches, TournamentMatch{
+				Round:    t.Round,
+				Losses:   losses,
+				Players:  [2]int64{group[0], 0},
+				Played:   true,
+				WinnerID: group[0],
+			})
+			group = group[1:]
+		}
+		for i := 0; i < len(group)/2; i++ {
+			t.Matches = append(t.Matches, TournamentMatch{
+				Round:   t.Round,
+				Losses:  losses,
+				Players: [2]int64{group[i], group[len(group)-1-i]},
+			})
+		}
+	}
+}
+
+// Get how many losses are needed to be out of the tournament
+func (t Tournament) maxLosses() int {
+	if t.Format == DoubleElimination {
+		return 2
+	}
+	return 1
+}
+
+// Get the players still in game of an elimination, in order of seed
+func (t Tournament) alive() (alive []int64) {
+	for _, userID := range t.Players {
+		if t.losses(userID) < t.maxLosses() {
+			alive = append(alive, userID)
+		}
+	}
+	return
+}
+
+// Go to the next round if all the matches of the current one are over, ending the tournament after the last
+func (t *Tournament) advance() {
+	var lastRound int
+
+	for _, match := range t.Matches {
+		if match.Round == t.Round && !match.Played {
+			return
+		}
+		if match.Round > lastRound {
+			lastRound = match.Round
+		}
+	}
+	t.Round++
+
+	if t.Format == RoundRobin {
+		if t.Round > lastRound {
+			t.Ended, t.WinnerID = true, t.Standings()[0]
+		}
+		return
+	}
+
+	switch alive := t.alive(); len(alive) {
+	case 0:
+		t.Ended = true
+	case 1:
+		t.Ended, t.WinnerID = true, alive[0]
+	default:
+		t.pairPlayers(alive)
+	}
+}
+
+// Create a new tournament in a group, the host is the first player
+func (r *TournamentRegistry) Create(groupID, hostID int64, hostName, format string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tournaments[groupID] != nil {
+		return errors.New("There is already a tournament in this group")
+	}
+	t := &Tournament{
+		GroupID: groupID,
+		HostID:  hostID,
+		Format:  format,
+		Players: []int64{hostID},
+		Names:   map[int64]string{hostID: hostName},
+	}
+	r.tournaments[groupID] = t
+	t.save()
+	return nil
+}
+
+// Set the message of the bracket of the tournament of a group
+func (r *TournamentRegistry) SetMessage(groupID int64, messageID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.tournaments[groupID]
+	if t == nil {
+		return errNoTournament
+	}
+	t.MessageID = messageID
+	t.save()
+	return nil
+}
+
+// Get the tournament of a group
+func (r *TournamentRegistry) Get(groupID int64) (Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.tournaments[groupID]
+	if t == nil {
+		return Tournament{}, errNoTournament
+	}
+	return t.copy(), nil
+}
+
+// Add a player to the tournament of a group, error if it's already started or full
+func (r *TournamentRegistry) Join(groupID, userID int64, name string) (Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch t := r.tournaments[groupID]; true {
+	case t == nil:
+		return Tournament{}, errNoTournament
+	case t.Started:
+		return t.copy(), errors.New("The tournament is already started")
+	case t.isPlayer(userID):
+		return t.copy(), errors.New("You already joined the tournament")
+	case len(t.Players) >= tournamentMaxPlayers:
+		return t.copy(), errors.New("The tournament is full")
+	default:
+		t.Players = append(t.Players, userID)
+		t.Names[userID] = name
+		t.save()
+		return t.copy(), nil
+	}
+}
+
+// Remove a player from the tournament of a group, only before it starts
+func (r *TournamentRegistry) Leave(groupID, userID int64) (Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.tournaments[groupID]
+	switch true {
+	case t == nil:
+		return Tournament{}, errNoTournament
+	case t.Started:
+		return t.copy(), errors.New("The tournament is already started, you can only flee from your duels")
+	case !t.isPlayer(userID):
+		return t.copy(), errors.New("You are not a player of this tournament")
+	}
+
+	for i, playerID := range t.Players {
+		if playerID == userID {
+			t.Players = append(t.Players[:i], t.Players[i+1:]...)
+			break
+		}
+	}
+	delete(t.Names, userID)
+	t.save()
+	return t.copy(), nil
+}
+
+// Seed the players and schedule the first round of the tournament of a group, only the host can do it
+func (r *TournamentRegistry) Start(groupID, userID int64) (Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.tournaments[groupID]
+	switch true {
+	case t == nil:
+		return Tournament{}, errNoTournament
+	case t.HostID != userID:
+		return t.copy(), errors.New("Only the host can start the tournament")
+	case t.Started:
+		return t.copy(), errors.New("The tournament is already started")
+	case len(t.Players) < 2:
+		return t.copy(), errors.New("At least 2 players are needed to start the tournament")
+	}
+
+	t.seed()
+	t.Started, t.Round = true, 1
+	if t.Format == RoundRobin {
+		t.scheduleRoundRobin()
+	} else {
+		t.pairPlayers(t.Players)
+	}
+	t.save()
+	return t.copy(), nil
+}
+
+// Delete the tournament of a group, only the host can do it. The ongoing duels keep going
+func (r *TournamentRegistry) Cancel(groupID, userID int64) (Tournament, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.tournaments[groupID]
+	switch true {
+	case t == nil:
+		return Tournament{}, errNoTournament
+	case t.HostID != userID:
+		return t.copy(), errors.New("Only the host can cancel the tournament")
+	}
+
+	delete(r.tournaments, groupID)
+	if err := STORE.DeleteTournament(groupID); err != nil {
+		log.Println("Cancel", "DeleteTournament", err)
+	}
+	return t.copy(), nil
+}
+
+/* Register the result of the duel of a match (winnerID is 0 if nobody won) and go to the next round
+ * when all the matches of the current one are over. A draw is a point for both players in a round
+ * robin, otherwise the match is played again. found is false if the duel is not part of a tournament
+ */
+func (r *TournamentRegistry) Report(duelID string, winnerID int64, draw bool) (t Tournament, found bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for groupID, current := range r.tournaments {
+		for i := range current.Matches {
+			match := &current.Matches[i]
+			if match.DuelID != duelID || match.Played {
+				continue
+			}
+
+			if draw && current.Format != RoundRobin {
+				match.DuelID = ""
+			} else {
+				match.Played, match.WinnerID = true, winnerID
+				current.advance()
+			}
+
+			if current.Ended {
+				delete(r.tournaments, groupID)
+				if err := STORE.DeleteTournament(groupID); err != nil {
+					log.Println("Report", "DeleteTournament", err)
+				}
+			} else {
+				current.save()
+			}
+			return current.copy(), true
+		}
+	}
+	return
+}
+
+// A match that just started with its tournament
+type startedMatch struct {
+	Tournament
+	Match TournamentMatch
+}
+
+// Start the duels of the matches of the current rounds, the ones with a busy player are skipped
+func (r *TournamentRegistry) startMatches() (started []startedMatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tournaments {
+		var matches []TournamentMatch
+
+		if !t.Started {
+			continue
+		}
+		for i := range t.Matches {
+			match := &t.Matches[i]
+			if match.Round != t.Round || match.Played || match.DuelID != "" {
+				continue
+			}
+			settings := DuelSettings{Ranked: true, GroupID: t.GroupID, Tournament: true}
+			d, err := duels.EngageDuel(match.Players[0], match.Players[1], settings)
+			if err != nil {
+				// One of them is busy, it will be tried again later
+				continue
+			}
+			match.DuelID = d.ID
+			matches = append(matches, *match)
+		}
+
+		if matches != nil {
+			t.save()
+			for _, match := range matches {
+				started = append(started, startedMatch{t.copy(), match})
+			}
+		}
+	}
+	return
+}
+
+// Keep starting the matches of the tournaments, it should run on its own goroutine
+func (r *TournamentRegistry) Run() {
+	var ticker = time.NewTicker(tournamentCheckEvery)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, current := range r.startMatches() {
+			if r.OnMatch != nil {
+				go r.OnMatch(current.Tournament, current.Match)
+			}
+		}
+	}
+}
+
+// Put back on the register the saved tournaments
+func (r *TournamentRegistry) Restore(saved []Tournament) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range saved {
+		t := saved[i]
+		if t.Names == nil {
+			t.Names = make(map[int64]string)
+		}
+		r.tournaments[t.GroupID] = &t
+	}
+}
+
+// Report the result of a duel to its tournament (if any) and update the bracket inside the group
+func (b *bot) AdvanceTournament(userID, winnerID int64, draw bool) {
+	settings, err := duels.GetSettings(userID)
+	if err != nil || !settings.Tournament {
+		return
+	}
+	duelID, err := duels.GetDuelID(userID)
+	if err != nil {
+		return
+	}
+	if t, found := tournaments.Report(duelID, winnerID, draw); found {
+		b.UpdateBracket(t)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Create a started tournament between the players 1 to n, seeded in this order
+func startTestTournament(format string, n int) *Tournament {
+	t := &Tournament{Format: format, Started: true, Round: 1}
+	for userID := int64(1); userID <= int64(n); userID++ {
+		t.Players = append(t.Players, userID)
+	}
+	if format == RoundRobin {
+		t.scheduleRoundRobin()
+	} else {
+		t.pairPlayers(t.Players)
+	}
+	return t
+}
+
+// Get the players of the matches of a round, 0 is who has a bye
+func roundPairs(t *Tournament, round int) (pairs [][2]int64) {
+	for _, match := range t.Matches {
+		if match.Round == round {
+			pairs = append(pairs, match.Players)
+		}
+	}
+	return
+}
+
+func TestPairPlayers(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		players int
+		want    [][2]int64
+	}{
+		{"final", SingleElimination, 2, [][2]int64{{1, 2}}},
+		{"bye to the best seed", SingleElimination, 3, [][2]int64{{1, 0}, {2, 3}}},
+		{"best against worst", SingleElimination, 4, [][2]int64{{1, 4}, {2, 3}}},
+		{"odd", DoubleElimination, 5, [][2]int64{{1, 0}, {2, 5}, {3, 4}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tournament := startTestTournament(test.format, test.players)
+			if got := roundPairs(tournament, 1); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("first round is %v, want %v", got, test.want)
+			}
+			for _, match := range tournament.Matches {
+				if bye := match.Players[1] == 0; bye != match.Played || bye && match.WinnerID != match.Players[0] {
+					t.Errorf("match %+v has a wrong bye", match)
+				}
+			}
+		})
+	}
+}
+
+func TestPairPlayersLosses(t *testing.T) {
+	tournament := startTestTournament(DoubleElimination, 4)
+	tournament.Matches[0].Played, tournament.Matches[0].WinnerID = true, 1
+	tournament.Matches[1].Played, tournament.Matches[1].WinnerID = true, 3
+	tournament.advance()
+
+	// Who lost once plays in the lower bracket
+	for _, match := range tournament.Matches[2:] {
+		if want := tournament.losses(match.Players[0]); match.Losses != want || tournament.losses(match.Players[1]) != want {
+			t.Errorf("match %+v is between players with different losses", match)
+		}
+	}
+	if got, want := roundPairs(tournament, 2), [][2]int64{{1, 3}, {2, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second round is %v, want %v", got, want)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	tests := []struct {
+		format  string
+		players int
+		rounds  int
+	}{
+		{SingleElimination, 2, 1},
+		{SingleElimination, 5, 3},
+		{SingleElimination, 8, 3},
+		{DoubleElimination, 4, 4},
+		{DoubleElimination, 3, 4},
+		{RoundRobin, 4, 3},
+		{RoundRobin, 5, 5},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.format, test.players), func(t *testing.T) {
+			tournament := startTestTournament(test.format, test.players)
+
+			for !tournament.Ended {
+				var round = tournament.Round
+				if round > 2*test.players {
+					t.Fatal("the tournament never ended")
+				}
+
+				// Nobody plays twice in the same round
+				seen := make(map[int64]bool)
+				for _, pair := range roundPairs(tournament, round) {
+					for _, userID := range pair {
+						if userID != 0 && seen[userID] {
+							t.Errorf("%d plays twice in round %d", userID, round)
+						}
+						seen[userID] = true
+					}
+				}
+
+				// The best seed always wins, the round is over only after the last match
+				var pending []int
+				for i, match := range tournament.Matches {
+					if match.Round == round && !match.Played {
+						pending = append(pending, i)
+					}
+				}
+				for _, i := range pending {
+					if tournament.Round != round {
+						t.Fatalf("went to round %d before the end of round %d", tournament.Round, round)
+					}
+					match := &tournament.Matches[i]
+					match.Played, match.WinnerID = true, match.Players[0]
+					tournament.advance()
+				}
+			}
+
+			if tournament.Round-1 != test.rounds || tournament.WinnerID != 1 {
+				t.Errorf("ended after %d rounds won by %d, want %d rounds won by 1", tournament.Round-1, tournament.WinnerID, test.rounds)
+			}
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
 	"strings"
+	"time"
+
+	"DuelBot/pg"
 
 	"github.com/NicoNex/echotron/v3"
 )
@@ -61,10 +66,10 @@ func extractMessageIDOpt(update *echotron.Update) *echotron.MessageIDOptions {
 	switch true {
 	case update.Message != nil:
 		message = update.Message
-		userID = message.From.ID
+		userID = message.Chat.ID
 	case update.EditedMessage != nil:
 		message = update.EditedMessage
-		userID = message.From.ID
+		userID = message.Chat.ID
 	case update.ChannelPost != nil:
 		message = update.ChannelPost
 		userID = message.SenderChat.ID
@@ -80,10 +85,10 @@ func extractMessageIDOpt(update *echotron.Update) *echotron.MessageIDOptions {
 	case update.CallbackQuery != nil:
 		message = update.CallbackQuery.Message
 		if message == nil {
-			msgID = echotron.NewInlineMessageID(update.CallbackQuery.ID)
+			msgID = echotron.NewInlineMessageID(update.CallbackQuery.InlineMessageID)
 			return &msgID
 		}
-		userID = update.CallbackQuery.From.ID
+		userID = message.Chat.ID
 	}
 
 	if message == nil {
@@ -93,6 +98,57 @@ func extractMessageIDOpt(update *echotron.Update) *echotron.MessageIDOptions {
 	return &msgID
 }
 
+// Reference to a message that can be edited later, sent on a chat or inline
+type MessageRef struct {
+	ChatID    int64  `json:"chat_id,omitempty"`
+	MessageID int    `json:"message_id,omitempty"`
+	InlineID  string `json:"inline_id,omitempty"` // used only if the message was sent inline
+}
+
+// Get the MessageIDOptions to edit the referenced message
+func (ref MessageRef) IDO() echotron.MessageIDOptions {
+	if ref.InlineID != "" {
+		return echotron.NewInlineMessageID(ref.InlineID)
+	}
+	return echotron.NewMessageID(ref.ChatID, ref.MessageID)
+}
+
+// Get the reference to the message where the button of a callback was pressed (nil if none)
+func extractMessageRef(update *echotron.Update) *MessageRef {
+	switch true {
+	case update.CallbackQuery == nil:
+		return nil
+	case update.CallbackQuery.Message != nil:
+		return &MessageRef{ChatID: update.CallbackQuery.Message.Chat.ID, MessageID: update.CallbackQuery.Message.ID}
+	case update.CallbackQuery.InlineMessageID != "":
+		return &MessageRef{InlineID: update.CallbackQuery.InlineMessageID}
+	}
+	return nil
+}
+
+/* Get the chat that will handle an update, false if there is none. Callbacks of inline
+ * messages have no chat so they are handled by the private chat of who pressed the button
+ */
+func extractChatID(update *echotron.Update) (chatID int64, ok bool) {
+	switch true {
+	case update.Message != nil:
+		return update.Message.Chat.ID, true
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID, true
+	case update.ChannelPost != nil:
+		return update.ChannelPost.Chat.ID, true
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost.Chat.ID, true
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID, true
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return update.CallbackQuery.From.ID, true
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From.ID, true
+	}
+	return 0, false
+}
+
 // Return the /command and the payload (other element separated by ' ' or '_' if /start)
 func extractCommand(update *echotron.Update) (command string, payload []string) {
 	var (
@@ -101,11 +157,18 @@ func extractCommand(update *echotron.Update) (command string, payload []string)
 	)
 
 	if ind == -1 {
+		if at := strings.IndexRune(text, '@'); at != -1 && strings.HasPrefix(text, "/") {
+			return text[:at], nil
+		}
 		return text, nil
 	}
 
 	command = text[:ind]
 	payload = append(payload, text[ind+1:])
+	// Commands sent in groups might be followed by the bot username (ex. /top@DuellingRobot)
+	if at := strings.IndexRune(command, '@'); at != -1 {
+		command = command[:at]
+	}
 	if strings.ContainsRune(text[ind+1:], ' ') {
 		payload = strings.Split(text[ind+1:], " ")
 	} else if command == "/start" && strings.ContainsRune(text[ind+1:], '_') {
@@ -114,10 +177,8 @@ func extractCommand(update *echotron.Update) (command string, payload []string)
 	return
 }
 
-// Return the parsed FirstName of the user who sent the message
-func extractName(update *echotron.Update) (FirstName string) {
-	var user *echotron.User
-
+// Return the user who sent the message (nil if none)
+func extractUser(update *echotron.Update) (user *echotron.User) {
 	switch true {
 	case update.Message != nil:
 		user = update.Message.From
@@ -135,6 +196,46 @@ func extractName(update *echotron.Update) (FirstName string) {
 		user = update.CallbackQuery.From
 	}
 
+	return
+}
+
+// Return the ID of the user who sent the message (useful in groups where it's not the chatID)
+func extractUserID(update *echotron.Update) int64 {
+	if user := extractUser(update); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// Check if a userID is inside a list
+func containsID(IDs []int64, userID int64) bool {
+	for _, ID := range IDs {
+		if ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the position of a userID inside a list, -1 if it's not there
+func indexOfID(IDs []int64, userID int64) int {
+	for i, ID := range IDs {
+		if ID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check if a chatID belongs to a group
+func isGroup(chatID int64) bool {
+	return chatID < 0
+}
+
+// Return the parsed FirstName of the user who sent the message
+func extractName(update *echotron.Update) (FirstName string) {
+	var user = extractUser(update)
+
 	if user == nil {
 		return "Unknown User"
 	}
@@ -154,7 +255,12 @@ func UpdateReport(userID int64, text string) (err error) {
 		res      echotron.APIResponseMessage
 	)
 
-	reportID, err = GetPlayerReportID(userID)
+	// There is nobody to show the report to
+	if isAI(userID) {
+		return
+	}
+
+	reportID, err = duels.GetPlayerReportID(userID)
 	if err != nil {
 		log.Println("UpdateReport", "GetPlayerReportID", err)
 		return
@@ -174,7 +280,7 @@ func UpdateReport(userID int64, text string) (err error) {
 		log.Println("UpdateReport", "SendMessage", err)
 		return
 	}
-	SetPlayerReportID(userID, res.Result.ID)
+	duels.SetPlayerReportID(userID, res.Result.ID)
 
 	return
 }
@@ -188,14 +294,19 @@ func UpdateStatus(userID int64, text string, newMessage bool) (err error) {
 		move   string
 	)
 
-	move, err = GetPlayerAction(userID)
+	// There is nobody to show the status to
+	if isAI(userID) {
+		return
+	}
+
+	move, err = duels.GetPlayerAction(userID)
 	if err != nil {
 		log.Println("UpdateStatus", "GetPlayerAction", err)
 		return
 	}
 
 	if !newMessage {
-		menuID, err = GetPlayerMenuID(userID)
+		menuID, err = duels.GetPlayerMenuID(userID)
 		if err != nil {
 			log.Println("UpdateStatus", "GetPlayerMenuID", err)
 			return
@@ -203,21 +314,21 @@ func UpdateStatus(userID int64, text string, newMessage bool) (err error) {
 		messageID := echotron.NewMessageID(userID, menuID)
 		_, err = b.EditMessageText(text, messageID, &echotron.MessageTextOptions{
 			ParseMode:   echotron.HTML,
-			ReplyMarkup: genActionKbd(move),
+			ReplyMarkup: genStatusKbd(userID, move),
 		})
 	}
 
 	if newMessage || err != nil {
 		res, err = b.SendMessage(text, userID, &echotron.MessageOptions{
 			ParseMode:   echotron.HTML,
-			BaseOptions: echotron.BaseOptions{ReplyMarkup: genActionKbd(move)},
+			BaseOptions: echotron.BaseOptions{ReplyMarkup: genStatusKbd(userID, move)},
 		})
 		if err != nil || res.Result == nil {
 			log.Println("UpdateStatus", err)
 			return
 		}
 
-		SetPlayerMenuID(userID, res.Result.ID)
+		duels.SetPlayerMenuID(userID, res.Result.ID)
 	}
 
 	return
@@ -232,6 +343,10 @@ func parseName(rawName string) string {
 
 // Vreating an HTML link to the specified user
 func GenUserLink(userID int64, name string) string {
+	// AI opponents are not Telegram users
+	if isAI(userID) {
+		return name
+	}
 	return fmt.Sprint("<a href=\"tg://user?id=", userID, "\">", name, "</a>")
 }
 
@@ -248,38 +363,184 @@ func validateToken(token string) error {
 	return nil
 }
 
+/* Split the command line arguments (os.Args) into values and options.
+ * An option is an argument that start with "--" followed by its value (ex. --readfrom mytoken.txt)
+ * and it's saved using the uppercase name without the "--"
+ */
+func parseArgs() (values []string, options map[string]string, err error) {
+	options = make(map[string]string)
+
+	for i := 1; i < len(os.Args); i++ {
+		if !strings.HasPrefix(os.Args[i], "--") {
+			values = append(values, os.Args[i])
+			continue
+		}
+		if i+1 >= len(os.Args) {
+			return nil, nil, errors.New("Missing value for " + os.Args[i])
+		}
+		options[strings.ToUpper(os.Args[i][2:])] = os.Args[i+1]
+		i++
+	}
+
+	return
+}
+
 /* Load the token from using the command line arguments (os.Args)
  * put the token next to the executable file name on the console or
- * use put readfrom followed by the file in witch there is the token (ex. .\DuelBot.exe readfrom mytoken.txt)
+ * use put readfrom followed by the file in witch there is the token (ex. .\DuelBot.exe --readfrom mytoken.txt)
  */
 func LoadToken() (token string, err error) {
-	switch len(os.Args) {
-	case 1:
-		return "", errors.New("Missing TOKEN value")
-
-	case 2:
-		token = os.Args[1]
+	values, options, err := parseArgs()
+	if err != nil {
+		return "", err
+	}
 
-	case 3:
-		if strings.ToUpper(os.Args[1]) != "--READFROM" {
+	if path, ok := options["READFROM"]; ok {
+		if len(values) != 0 {
 			return "", errors.New("Invalid format")
 		}
-		if content, err := os.ReadFile(os.Args[2]); err != nil {
+		if content, err := os.ReadFile(path); err != nil {
 			return "", err
 		} else {
 			token = strings.TrimSpace(string(content))
 		}
-
-	default:
-		return "", errors.New("Too many arguments")
+	} else {
+		switch len(values) {
+		case 0:
+			return "", errors.New("Missing TOKEN value")
+		case 1:
+			token = values[0]
+		default:
+			return "", errors.New("Too many arguments")
+		}
 	}
 
 	err = validateToken(token)
 	return
 }
 
+/* Load the ruleset of the combat engine from the file passed using
+ * the command line argument --rules (ex. .\DuelBot.exe <token> --rules myrules.json)
+ * if there is none the default ruleset will be used
+ */
+func LoadRuleset() (*pg.Ruleset, error) {
+	_, options, err := parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := options["RULES"]
+	if !ok {
+		return pg.DefaultRuleset(), nil
+	}
+	return pg.LoadRuleset(path)
+}
+
+/* Load the key used to sign the invites from the file passed using the command line
+ * argument --secret (ex. .\DuelBot.exe <token> --secret mysecret.txt) if there is none
+ * it's derived from the TOKEN, so the invites remains valid after a restart
+ */
+func LoadSecret() ([]byte, error) {
+	_, options, err := parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := options["SECRET"]
+	if !ok {
+		secret := sha256.Sum256([]byte("DuelBot invites " + TOKEN))
+		return secret[:], nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if secret := bytes.TrimSpace(content); len(secret) != 0 {
+		return secret, nil
+	}
+	return nil, errors.New("Empty secret file")
+}
+
+// Get a duration in a readable format rounded to the minutes (ex. 1h 30m or 2d 4h)
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	var (
+		days    = int(d.Hours()) / 24
+		hours   = int(d.Hours()) % 24
+		minutes = int(d.Minutes()) % 60
+	)
+
+	switch true {
+	case days != 0 && hours == 0:
+		return fmt.Sprint(days, "d")
+	case days != 0:
+		return fmt.Sprint(days, "d ", hours, "h")
+	case hours == 0:
+		return fmt.Sprint(minutes, "m")
+	case minutes == 0:
+		return fmt.Sprint(hours, "h")
+	}
+	return fmt.Sprint(hours, "h ", minutes, "m")
+}
+
+/* Load how long a player can stay without moving before losing the duel, passed using
+ * the command line argument --afk (ex. .\DuelBot.exe <token> --afk 10m) if there is none
+ * defAFKTimeout will be used
+ */
+func LoadAFKTimeout() (time.Duration, error) {
+	_, options, err := parseArgs()
+	if err != nil {
+		return 0, err
+	}
+
+	raw, ok := options["AFK"]
+	if !ok {
+		return defAFKTimeout, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < minAFKTimeout {
+		return 0, errors.New("AFK timeout must be at least " + minAFKTimeout.String())
+	}
+	return timeout, nil
+}
+
+/* Get the ID of the ended duel to replay passed using the command line argument --replay
+ * (ex. .\DuelBot.exe --replay 1a2b3c) instead of starting the bot, "" if there is none
+ */
+func LoadReplayID() (string, error) {
+	_, options, err := parseArgs()
+	if err != nil {
+		return "", err
+	}
+	return options["REPLAY"], nil
+}
+
+/* Open the store where the state of the bot is saved, using the path passed with
+ * the command line argument --store (ex. .\DuelBot.exe <token> --store duels.db)
+ * if there is none "DuelBot.db" will be used
+ */
+func LoadStore() (Store, error) {
+	_, options, err := parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := options["STORE"]
+	if !ok {
+		path = "DuelBot.db"
+	}
+	return NewBoltStore(path)
+}
+
 // Get the name of a player
 func (b *bot) GetUserName(chatID int64) (name string) {
+	if isAI(chatID) {
+		return aiName(chatID)
+	}
 	res, err := b.GetChat(chatID)
 	if err != nil || res.Result == nil {
 		return "Unnamed User"